@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+)
+
+// requireAdmin only lets through requests authenticated as one of
+// ADMIN_USERS. Responses are marked no-store so admin data never lands in a
+// shared cache.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if !isAdmin(r) {
+			writeError(w, r, http.StatusForbidden, "Admin access required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAdmin reports whether r is authenticated as one of ADMIN_USERS.
+func isAdmin(r *http.Request) bool {
+	user := authUser(r)
+	return user != "" && slices.Contains(config.AdminUsers, user)
+}
+
+// includeDeleted reports whether r asked for soft-deleted users with
+// ?include_deleted=true. Only admins may; anyone else gets a 403 and ok is
+// false.
+func includeDeleted(w http.ResponseWriter, r *http.Request) (include, ok bool) {
+	if r.URL.Query().Get("include_deleted") != "true" {
+		return false, true
+	}
+	if !isAdmin(r) {
+		writeError(w, r, http.StatusForbidden, "Admin access required to include deleted users")
+		return false, false
+	}
+	return true, true
+}
+
+// poolLimits are the connection pool settings applied in connectDB.
+type poolLimits struct {
+	MaxOpenConns    int    `json:"max_open_conns"`
+	MaxIdleConns    int    `json:"max_idle_conns"`
+	ConnMaxLifetime string `json:"conn_max_lifetime"`
+	ConnMaxIdleTime string `json:"conn_max_idle_time"`
+}
+
+// dbStats mirrors sql.DBStats alongside the configured limits.
+type dbStats struct {
+	OpenConnections   int        `json:"open_connections"`
+	InUse             int        `json:"in_use"`
+	Idle              int        `json:"idle"`
+	WaitCount         int64      `json:"wait_count"`
+	WaitDuration      string     `json:"wait_duration"`
+	MaxIdleClosed     int64      `json:"max_idle_closed"`
+	MaxIdleTimeClosed int64      `json:"max_idle_time_closed"`
+	MaxLifetimeClosed int64      `json:"max_lifetime_closed"`
+	Limits            poolLimits `json:"limits"`
+}
+
+func getDBStats(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to read database stats")
+		return
+	}
+
+	s := sqlDB.Stats()
+	stats := dbStats{
+		OpenConnections:   s.OpenConnections,
+		InUse:             s.InUse,
+		Idle:              s.Idle,
+		WaitCount:         s.WaitCount,
+		WaitDuration:      s.WaitDuration.String(),
+		MaxIdleClosed:     s.MaxIdleClosed,
+		MaxIdleTimeClosed: s.MaxIdleTimeClosed,
+		MaxLifetimeClosed: s.MaxLifetimeClosed,
+		Limits: poolLimits{
+			MaxOpenConns:    config.DBMaxOpenConns,
+			MaxIdleConns:    config.DBMaxIdleConns,
+			ConnMaxLifetime: config.DBConnMaxLifetime.String(),
+			ConnMaxIdleTime: config.DBConnMaxIdleTime.String(),
+		},
+	}
+
+	writeJSON(w, r, http.StatusOK, stats)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// withAuthUser returns r as authMiddleware would pass it on for user. An
+// empty user leaves r anonymous.
+func withAuthUser(r *http.Request, user string) *http.Request {
+	if user == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), authUserKey, user))
+}
+
+func TestIncludeDeleted(t *testing.T) {
+	testConfig(t, map[string]string{"ADMIN_USERS": "root"})
+	tests := []struct {
+		name    string
+		query   string
+		user    string
+		include bool
+		ok      bool
+	}{
+		{"not asked", "", "", false, true},
+		{"not asked by an admin", "", "root", false, true},
+		{"asked anonymously", "?include_deleted=true", "", false, false},
+		{"asked by a non-admin", "?include_deleted=true", "alice", false, false},
+		{"asked by an admin", "?include_deleted=true", "root", true, true},
+		{"anything but true", "?include_deleted=1", "root", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := withAuthUser(httptest.NewRequest(http.MethodGet, "/api/users"+tt.query, nil), tt.user)
+			rec := httptest.NewRecorder()
+			include, ok := includeDeleted(rec, req)
+			if include != tt.include || ok != tt.ok {
+				t.Fatalf("includeDeleted = %v, %v, want %v, %v", include, ok, tt.include, tt.ok)
+			}
+			if !ok && rec.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want 403", rec.Code)
+			}
+		})
+	}
+}
+
+// Soft-deleted users stay hidden from the list and from direct lookups
+// unless an admin asks for them, and then carry their deleted_at.
+func TestReadsIncludeDeleted(t *testing.T) {
+	testConfig(t, map[string]string{"ADMIN_USERS": "root"})
+	testDB(t)
+	seedUser(t, "alice", "Alice", "alice@example.com")
+	gone := seedUser(t, "bob", "Bob", "bob@example.com")
+	if err := db.Delete(&gone).Error; err != nil {
+		t.Fatal(err)
+	}
+	id := strconv.FormatUint(uint64(gone.ID), 10)
+
+	tests := []struct {
+		name   string
+		user   string
+		query  string
+		list   int
+		status int
+	}{
+		{"default", "", "", 1, http.StatusNotFound},
+		{"admin by default", "root", "", 1, http.StatusNotFound},
+		{"admin including deleted", "root", "?include_deleted=true", 2, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := withAuthUser(httptest.NewRequest(http.MethodGet, "/api/users"+tt.query, nil), tt.user)
+			rec := httptest.NewRecorder()
+			getUsers(rec, req)
+			var page userPageResponse
+			decodeBody(t, rec, &page)
+			if len(page.Data) != tt.list {
+				t.Errorf("list has %d users, want %d", len(page.Data), tt.list)
+			}
+			for _, u := range page.Data {
+				if u.ID == gone.ID && !u.DeletedAt.Valid {
+					t.Error("deleted user listed without deleted_at")
+				}
+			}
+
+			req = withAuthUser(httptest.NewRequest(http.MethodGet, "/api/users/"+id+tt.query, nil), tt.user)
+			rec = serveRequest(getUser, req, map[string]string{"id": id})
+			if rec.Code != tt.status {
+				t.Fatalf("get: status = %d, want %d", rec.Code, tt.status)
+			}
+			if rec.Code == http.StatusOK {
+				var user userResponse
+				decodeBody(t, rec, &user)
+				if !user.DeletedAt.Valid {
+					t.Error("deleted user returned without deleted_at")
+				}
+			}
+		})
+	}
+
+	req := withAuthUser(httptest.NewRequest(http.MethodGet, "/api/users?include_deleted=true", nil), "alice")
+	rec := httptest.NewRecorder()
+	getUsers(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("non-admin including deleted: status = %d, want 403", rec.Code)
+	}
+}
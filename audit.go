@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Actions recorded in the audit log.
+const (
+	auditCreated = "created"
+	auditUpdated = "updated"
+	auditDeleted = "deleted"
+)
+
+// auditEntry records one change to a user: what happened, who did it, the
+// request that did it, and the user as it stood afterwards. Entries written
+// before snapshots were recorded have none.
+type auditEntry struct {
+	ID        uint          `json:"id" gorm:"primaryKey"`
+	UserID    uint          `json:"user_id" gorm:"index"`
+	Action    string        `json:"action" gorm:"size:20;index"`
+	Actor     string        `json:"actor,omitempty" gorm:"size:100"`
+	RequestID string        `json:"request_id,omitempty" gorm:"size:128"`
+	Snapshot  auditSnapshot `json:"snapshot,omitempty" gorm:"type:jsonb"`
+	CreatedAt time.Time     `json:"created_at" gorm:"index"`
+}
+
+// auditSnapshot is a user's JSON representation, decoded into its
+// top-level fields so snapshots can be compared field by field.
+type auditSnapshot map[string]any
+
+func newAuditSnapshot(user User) auditSnapshot {
+	var s auditSnapshot
+	if b, err := json.Marshal(user); err == nil {
+		json.Unmarshal(b, &s)
+	}
+	return s
+}
+
+// Value implements driver.Valuer.
+func (s auditSnapshot) Value() (driver.Value, error) { return userMetadata(s).Value() }
+
+// Scan implements sql.Scanner.
+func (s *auditSnapshot) Scan(src any) error { return (*userMetadata)(s).Scan(src) }
+
+// newAuditEntry describes action on user by the request behind ctx. user is
+// the state after the change.
+func newAuditEntry(ctx context.Context, action string, user User) *auditEntry {
+	actor, _ := ctx.Value(authUserKey).(string)
+	return &auditEntry{UserID: user.ID, Action: action, Actor: actor, RequestID: requestID(ctx), Snapshot: newAuditSnapshot(user)}
+}
+
+// auditedWrite runs write and the audit entry for it in one transaction, so
+// the trail can neither record a change that rolled back nor miss one that
+// committed. write returns the ID of the user it changed, or 0 if it turned
+// out to change nothing.
+func auditedWrite(r *http.Request, action string, write func(tx *gorm.DB) (uint, error)) error {
+	return db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		id, err := write(tx)
+		if err != nil || id == 0 {
+			return err
+		}
+		// Read the row back for the snapshot; it is still there, with
+		// deleted_at set, after a delete.
+		var user User
+		if err := tx.Unscoped().First(&user, id).Error; err != nil {
+			return err
+		}
+		return tx.Create(newAuditEntry(r.Context(), action, user)).Error
+	})
+}
+
+// auditPage is one page of the audit log. NextCursor is empty on the last
+// page.
+type auditPage struct {
+	Data       []auditEntry `json:"data"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+func encodeAuditCursor(id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(id), 10)))
+}
+
+func decodeAuditCursor(cursor string) (uint, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(string(raw), 10, 0)
+	return uint(id), err == nil && id > 0
+}
+
+// getAuditLog serves GET /api/admin/audit, newest entries first. Pages are
+// keyset-paginated on the entry ID, so each page is an index range scan no
+// matter how deep into the history it is; next_cursor continues after the
+// last entry returned. user_id, action, and an RFC 3339 from/to range on
+// created_at narrow the results.
+func getAuditLog(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	limit, _, err := parsePagination(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	q := r.URL.Query()
+	query := db.WithContext(r.Context()).Model(&auditEntry{})
+	if v := q.Get("cursor"); v != "" {
+		after, ok := decodeAuditCursor(v)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		query = query.Where("id < ?", after)
+	}
+	if v := q.Get("user_id"); v != "" {
+		userID, err := strconv.ParseUint(v, 10, 0)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "user_id must be a positive integer")
+			return
+		}
+		query = query.Where("user_id = ?", userID)
+	}
+	if v := q.Get("action"); v != "" {
+		switch v {
+		case auditCreated, auditUpdated, auditDeleted:
+		default:
+			writeError(w, r, http.StatusBadRequest, "action must be one of created, updated or deleted")
+			return
+		}
+		query = query.Where("action = ?", v)
+	}
+	for _, bound := range []struct{ param, cond string }{{"from", "created_at >= ?"}, {"to", "created_at < ?"}} {
+		if v := q.Get(bound.param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, bound.param+" must be an RFC 3339 timestamp")
+				return
+			}
+			query = query.Where(bound.cond, t)
+		}
+	}
+
+	// Fetch one extra row to learn whether another page follows.
+	page := auditPage{}
+	if err := query.Order("id DESC").Limit(limit + 1).Find(&page.Data).Error; err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve audit log")
+		return
+	}
+	if len(page.Data) > limit {
+		page.Data = page.Data[:limit]
+		page.NextCursor = encodeAuditCursor(page.Data[limit-1].ID)
+	}
+
+	writeJSON(w, r, http.StatusOK, page)
+}
+
+// fieldChange is one field that differs between two audit snapshots.
+type fieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+// auditDiff is the response of getAuditDiff.
+type auditDiff struct {
+	From    uint          `json:"from"`
+	To      uint          `json:"to"`
+	Changes []fieldChange `json:"changes"`
+}
+
+// diffSnapshots lists the top-level fields that differ between from and to,
+// sorted by name. A field missing on one side shows up as null there.
+func diffSnapshots(from, to auditSnapshot) []fieldChange {
+	fields := make([]string, 0, len(from)+len(to))
+	for k := range from {
+		fields = append(fields, k)
+	}
+	for k := range to {
+		if _, ok := from[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	slices.Sort(fields)
+
+	changes := []fieldChange{}
+	for _, f := range fields {
+		if !reflect.DeepEqual(from[f], to[f]) {
+			changes = append(changes, fieldChange{Field: f, Old: from[f], New: to[f]})
+		}
+	}
+	return changes
+}
+
+// getAuditDiff serves GET /api/users/{id}/audit/diff?from=&to=, the fields
+// that changed on the user between two of its audit entries. Admins only.
+func getAuditDiff(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	var ids [2]uint
+	for i, param := range []string{"from", "to"} {
+		n, err := strconv.ParseUint(r.URL.Query().Get(param), 10, 0)
+		if err != nil || n == 0 {
+			writeError(w, r, http.StatusBadRequest, param+" must be an audit entry ID")
+			return
+		}
+		ids[i] = uint(n)
+	}
+
+	var entries []auditEntry
+	if err := db.WithContext(r.Context()).Where("id IN ? AND user_id = ?", ids[:], userID).Find(&entries).Error; err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve audit entries")
+		return
+	}
+	byID := make(map[uint]auditEntry, len(entries))
+	for _, e := range entries {
+		byID[e.ID] = e
+	}
+	for _, id := range ids {
+		e, ok := byID[id]
+		if !ok {
+			writeError(w, r, http.StatusNotFound, fmt.Sprintf("Audit entry %d not found for user %d", id, userID))
+			return
+		}
+		if e.Snapshot == nil {
+			writeError(w, r, http.StatusUnprocessableEntity, fmt.Sprintf("Audit entry %d predates snapshots and can't be compared", id))
+			return
+		}
+	}
+
+	writeJSON(w, r, http.StatusOK, auditDiff{
+		From:    ids[0],
+		To:      ids[1],
+		Changes: diffSnapshots(byID[ids[0]].Snapshot, byID[ids[1]].Snapshot),
+	})
+}
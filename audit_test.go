@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// Any request ID the middleware accepts must fit the audit column, or the
+// audited write it belongs to rolls back.
+func TestAuditRequestIDFits(t *testing.T) {
+	stmt := &gorm.Statement{DB: dryRunDB(t)}
+	if err := stmt.Parse(&auditEntry{}); err != nil {
+		t.Fatal(err)
+	}
+	if size := stmt.Schema.LookUpField("RequestID").Size; size < maxRequestIDLength {
+		t.Errorf("request_id column holds %d characters, want at least %d", size, maxRequestIDLength)
+	}
+}
+
+func TestCreateUserAuditsLongRequestID(t *testing.T) {
+	testDB(t)
+	id := strings.Repeat("r", 100)
+	h := requestIDMiddleware(config)(http.HandlerFunc(createUser))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(`{"username": "alice", "name": "Alice", "email": "alice@example.com"}`))
+	req.Header.Set(config.RequestIDHeader, id)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get(config.RequestIDHeader); got != id {
+		t.Errorf("echoed request ID = %q, want the one sent", got)
+	}
+
+	var entry auditEntry
+	if err := db.Where("action = ?", auditCreated).First(&entry).Error; err != nil {
+		t.Fatal(err)
+	}
+	if entry.RequestID != id {
+		t.Errorf("audited request ID = %q, want the one sent", entry.RequestID)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported values for AUTH_MODE.
+const (
+	authModeNone  = "none"
+	authModeBasic = "basic"
+)
+
+type contextKey string
+
+const authUserKey contextKey = "authUser"
+
+// authExemptPaths are reachable without credentials so probes and scrapers
+// keep working when auth is enabled. The user schema describes no data and
+// is public so sign-up forms can render before anyone has logged in.
+var authExemptPaths = map[string]bool{
+	"/healthz":          true,
+	"/readyz":           true,
+	"/metrics":          true,
+	"/api/users/schema": true,
+}
+
+// dummyHash is compared against when the username is unknown so a miss takes
+// as long as a wrong password.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+// basicCredential is one user:bcrypthash entry from BASIC_AUTH_USERS.
+type basicCredential struct {
+	Username string
+	Hash     []byte
+}
+
+// parseBasicAuthUsers parses a comma-separated list of user:bcrypthash pairs.
+func parseBasicAuthUsers(s string) ([]basicCredential, error) {
+	var creds []basicCredential
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		user, hash, ok := strings.Cut(entry, ":")
+		if !ok || user == "" || hash == "" {
+			return nil, fmt.Errorf("BASIC_AUTH_USERS entry %q must be user:bcrypthash", entry)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("BASIC_AUTH_USERS entry for %q has an invalid bcrypt hash: %v", user, err)
+		}
+		creds = append(creds, basicCredential{Username: user, Hash: []byte(hash)})
+	}
+	return creds, nil
+}
+
+// authMiddleware enforces the authentication scheme selected by AUTH_MODE.
+func authMiddleware(cfg *Config) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if cfg.AuthMode != authModeBasic {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if authExemptPaths[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			user, pass, ok := r.BasicAuth()
+			if !ok || !checkBasicCredentials(cfg.BasicAuthUsers, user, pass) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="api"`)
+				writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), authUserKey, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// checkBasicCredentials reports whether user/pass match a configured
+// credential. Every entry is compared so the lookup does not leak which
+// usernames exist through timing.
+func checkBasicCredentials(creds []basicCredential, user, pass string) bool {
+	hash := dummyHash
+	found := 0
+	for _, c := range creds {
+		if subtle.ConstantTimeCompare([]byte(c.Username), []byte(user)) == 1 {
+			hash = c.Hash
+			found = 1
+		}
+	}
+
+	match := bcrypt.CompareHashAndPassword(hash, []byte(pass)) == nil
+	return found == 1 && match
+}
+
+// authUser returns the authenticated username, if any.
+func authUser(r *http.Request) string {
+	user, _ := r.Context().Value(authUserKey).(string)
+	return user
+}
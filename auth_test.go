@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func basicAuthConfig(t *testing.T) *Config {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := parseBasicAuthUsers("alice:" + string(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Config{AuthMode: authModeBasic, BasicAuthUsers: creds}
+}
+
+func TestCheckBasicCredentials(t *testing.T) {
+	creds := basicAuthConfig(t).BasicAuthUsers
+	tests := []struct {
+		name, user, pass string
+		want             bool
+	}{
+		{"valid", "alice", "s3cret", true},
+		{"wrong password", "alice", "nope", false},
+		{"unknown user", "bob", "s3cret", false},
+		{"empty", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkBasicCredentials(creds, tt.user, tt.pass); got != tt.want {
+				t.Errorf("checkBasicCredentials(%q, %q) = %v, want %v", tt.user, tt.pass, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBasicAuthUsers(t *testing.T) {
+	for _, bad := range []string{"alice", "alice:", ":hash", "alice:not-a-bcrypt-hash"} {
+		if _, err := parseBasicAuthUsers(bad); err == nil {
+			t.Errorf("parseBasicAuthUsers(%q) succeeded", bad)
+		}
+	}
+	creds, err := parseBasicAuthUsers("")
+	if err != nil || len(creds) != 0 {
+		t.Errorf("empty list = %v, %v", creds, err)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	testConfig(t, nil)
+	cfg := basicAuthConfig(t)
+	var seenUser string
+	h := authMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenUser = authUser(r)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name       string
+		path       string
+		user, pass string
+		basic      bool
+		want       int
+		wantUser   string
+	}{
+		{"valid credentials", "/api/users", "alice", "s3cret", true, http.StatusNoContent, "alice"},
+		{"invalid credentials", "/api/users", "alice", "wrong", true, http.StatusUnauthorized, ""},
+		{"missing credentials", "/api/users", "", "", false, http.StatusUnauthorized, ""},
+		{"exempt path", "/healthz", "", "", false, http.StatusNoContent, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seenUser = ""
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.basic {
+				req.SetBasicAuth(tt.user, tt.pass)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+			if seenUser != tt.wantUser {
+				t.Errorf("authUser = %q, want %q", seenUser, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareOff(t *testing.T) {
+	called := false
+	h := authMiddleware(&Config{AuthMode: authModeNone})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/users", nil))
+	if !called {
+		t.Error("AUTH_MODE=none blocked a request")
+	}
+}
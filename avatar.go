@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxAvatarBytes caps the size of an uploaded avatar.
+const maxAvatarBytes = 2 << 20
+
+// avatarTypes maps the image types accepted as avatars to the extension
+// their keys get. The type is sniffed from the content, not taken from the
+// request's Content-Type.
+var avatarTypes = map[string]string{
+	"image/gif":  ".gif",
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// userAvatar records where a user's current avatar is stored. Every upload
+// gets a fresh key, so URLs can be cached forever.
+type userAvatar struct {
+	UserID      uint   `gorm:"primaryKey"`
+	Key         string `gorm:"size:512;not null"`
+	URL         string `gorm:"size:2048;not null"`
+	ContentType string `gorm:"size:100;not null"`
+	UpdatedAt   time.Time
+}
+
+// putAvatar serves PUT /api/users/{id}/avatar. The body is the image
+// itself. It is written to blob storage first and recorded second, so the
+// user's avatar is never a key that doesn't exist; the replaced image is
+// deleted afterwards.
+func putAvatar(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	id, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	var user User
+	if result := db.WithContext(r.Context()).Select("id").First(&user, id); result.Error != nil {
+		if requestCanceled(w, r, result.Error) {
+			return
+		}
+		writeError(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxAvatarBytes+1))
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if len(body) == 0 {
+		writeDecodeError(w, r, io.EOF)
+		return
+	}
+	if len(body) > maxAvatarBytes {
+		writeError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("Avatar exceeds %d bytes", maxAvatarBytes))
+		return
+	}
+	contentType := http.DetectContentType(body)
+	ext, ok := avatarTypes[contentType]
+	if !ok {
+		writeError(w, r, http.StatusUnsupportedMediaType, "Avatar must be a GIF, JPEG, PNG or WebP image")
+		return
+	}
+
+	avatar := userAvatar{UserID: id, Key: fmt.Sprintf("avatars/%d/%d%s", id, time.Now().UnixNano(), ext), ContentType: contentType}
+	if avatar.URL, err = blobs.Put(r.Context(), avatar.Key, contentType, bytes.NewReader(body)); err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		log.Printf("❌ Failed to store avatar for user %d: %v", id, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to store avatar")
+		return
+	}
+
+	var previous userAvatar
+	err = db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&previous, id).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&avatar).Error
+	})
+	if err != nil {
+		deleteBlob(r, avatar.Key)
+		if requestCanceled(w, r, err) {
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "Failed to save avatar")
+		return
+	}
+	if previous.Key != "" {
+		deleteBlob(r, previous.Key)
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]string{"avatar_url": avatar.URL})
+}
+
+// getAvatar serves GET /api/users/{id}/avatar by redirecting to wherever
+// the storage backend serves the image from.
+func getAvatar(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	id, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	var avatar userAvatar
+	if result := db.WithContext(r.Context()).First(&avatar, id); result.Error != nil {
+		if requestCanceled(w, r, result.Error) {
+			return
+		}
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			writeError(w, r, http.StatusNotFound, "Avatar not found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve avatar")
+		return
+	}
+
+	w.Header().Set("Cache-Control", config.ReadCacheControl)
+	http.Redirect(w, r, avatar.URL, http.StatusFound)
+}
+
+// deleteAvatar serves DELETE /api/users/{id}/avatar. It succeeds whether or
+// not the user had an avatar to delete.
+func deleteAvatar(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	id, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	var avatars []userAvatar
+	err := db.WithContext(r.Context()).Clauses(clause.Returning{}).Where("user_id = ?", id).Delete(&avatars).Error
+	if err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "Failed to delete avatar")
+		return
+	}
+	for _, avatar := range avatars {
+		deleteBlob(r, avatar.Key)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteBlob removes a blob that is no longer referenced. A failure only
+// leaves an orphaned file behind, so it is logged rather than reported.
+func deleteBlob(r *http.Request, key string) {
+	if err := blobs.Delete(r.Context(), key); err != nil {
+		log.Printf("⚠️  Failed to delete blob %s: %v", key, err)
+	}
+}
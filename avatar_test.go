@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pngHeader is enough of a PNG for content sniffing.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestPutAvatarRejects(t *testing.T) {
+	testConfig(t, nil)
+	prev := db
+	// The user lookup finds a blank user in a dry run, which is all the
+	// checks on the body need.
+	db = dryRunDB(t)
+	t.Cleanup(func() { db = prev })
+	store := &memoryStorage{}
+	useBlobs(t, store)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"empty", "", http.StatusBadRequest},
+		{"too large", string(pngHeader) + strings.Repeat("x", maxAvatarBytes), http.StatusRequestEntityTooLarge},
+		{"not an image", "just some text", http.StatusUnsupportedMediaType},
+		{"unaccepted image type", "BM" + strings.Repeat("\x00", 32), http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(putAvatar, http.MethodPut, "/api/users/1/avatar", map[string]string{"id": "1"}, tt.body, nil)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if keys := store.keys(); len(keys) > 0 {
+				t.Errorf("rejected avatar was stored: %v", keys)
+			}
+		})
+	}
+}
+
+func TestAvatarLifecycle(t *testing.T) {
+	testDB(t)
+	store := &memoryStorage{}
+	useBlobs(t, store)
+	user := seedUser(t, "alice", "Alice", "alice@example.com")
+	id := strconv.FormatUint(uint64(user.ID), 10)
+	vars := map[string]string{"id": id}
+	target := "/api/users/" + id + "/avatar"
+
+	rec := serve(getAvatar, http.MethodGet, target, vars, "", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("before upload: status = %d, want 404", rec.Code)
+	}
+
+	upload := func() string {
+		t.Helper()
+		rec := serve(putAvatar, http.MethodPut, target, vars, string(pngHeader), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("upload: status = %d: %s", rec.Code, rec.Body)
+		}
+		var body map[string]string
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body["avatar_url"]
+	}
+
+	first := upload()
+	keys := store.keys()
+	if len(keys) != 1 || !strings.HasPrefix(keys[0], "avatars/"+id+"/") || !strings.HasSuffix(keys[0], ".png") {
+		t.Fatalf("stored keys = %v", keys)
+	}
+	if blob := store.blobs[keys[0]]; blob.contentType != "image/png" || !bytes.Equal(blob.data, pngHeader) {
+		t.Errorf("stored blob = %+v", blob)
+	}
+
+	rec = serve(getAvatar, http.MethodGet, target, vars, "", nil)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != first {
+		t.Errorf("get: status = %d, Location %q, want 302 to %q", rec.Code, rec.Header().Get("Location"), first)
+	}
+
+	// A new upload gets a new key and deletes the replaced blob.
+	second := upload()
+	if second == first {
+		t.Error("replacement reused the URL")
+	}
+	if got := store.keys(); len(got) != 1 || slices.Contains(got, keys[0]) {
+		t.Errorf("after replacing, stored keys = %v", got)
+	}
+
+	rec = serve(deleteAvatar, http.MethodDelete, target, vars, "", nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: status = %d", rec.Code)
+	}
+	if got := store.keys(); len(got) != 0 {
+		t.Errorf("after delete, stored keys = %v", got)
+	}
+	rec = serve(deleteAvatar, http.MethodDelete, target, vars, "", nil)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("deleting again: status = %d, want 204", rec.Code)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// backupPath is the on-demand backup endpoint. It runs for as long as the
+// upload takes, bounded by BACKUP_TIMEOUT rather than the route timeout.
+const backupPath = "/api/admin/backup"
+
+// backupConfig is the object storage a backup is written to.
+type backupConfig struct {
+	s3Config
+	Prefix  string
+	Timeout time.Duration
+}
+
+// backupColumns is the header of a CSV backup. It starts with the columns
+// the CSV import reads, so a backup can be imported back.
+var backupColumns = []string{"username", "name", "email", "id", "uuid", "role", "active", "metadata", "created_at", "updated_at", "deleted_at"}
+
+// startBackup serves POST /api/admin/backup. Every user, soft-deleted ones
+// included, is written to the configured bucket as NDJSON, or as CSV with
+// ?format=csv. Rows are read from a cursor and uploaded in parts as they
+// are encoded, so neither the table nor the object is ever held in memory.
+// The response names the object once the upload has completed.
+func startBackup(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	var contentType string
+	switch format {
+	case "", "json":
+		format, contentType = "ndjson", "application/x-ndjson"
+	case "csv":
+		contentType = "text/csv"
+	default:
+		writeError(w, r, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Backup.Timeout)
+	defer cancel()
+
+	key := fmt.Sprintf("%susers-%s.%s", config.Backup.Prefix, time.Now().UTC().Format("20060102T150405Z"), format)
+	pr, pw := io.Pipe()
+	written := make(chan int, 1)
+	go func() {
+		n, err := writeBackup(ctx, pw, format)
+		written <- n
+		pw.CloseWithError(err)
+	}()
+
+	size, err := newS3Client(config.Backup.s3Config).upload(ctx, key, contentType, pr)
+	// Unblock the writer if the upload gave up before reading everything.
+	pr.CloseWithError(io.ErrClosedPipe)
+	users := <-written
+	if err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		log.Printf("❌ Backup to %s failed: %v", key, err)
+		writeError(w, r, http.StatusBadGateway, "Backup failed")
+		return
+	}
+
+	log.Printf("💾 Backed up %d users to s3://%s/%s (%d bytes)", users, config.Backup.Bucket, key, size)
+	writeJSON(w, r, http.StatusOK, map[string]any{
+		"bucket": config.Backup.Bucket,
+		"key":    key,
+		"format": format,
+		"users":  users,
+		"bytes":  size,
+	})
+}
+
+// writeBackup encodes every user to w in format, returning how many were
+// written.
+func writeBackup(ctx context.Context, w io.Writer, format string) (int, error) {
+	rows, err := db.WithContext(ctx).Unscoped().Model(&User{}).Order("id").Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var encode func(User) error
+	var flush func() error
+	if format == "csv" {
+		cw := csv.NewWriter(w)
+		if err := cw.Write(backupColumns); err != nil {
+			return 0, err
+		}
+		encode = func(u User) error { return cw.Write(backupRecord(u)) }
+		flush = func() error { cw.Flush(); return cw.Error() }
+	} else {
+		enc := json.NewEncoder(w)
+		encode = func(u User) error { return enc.Encode(u) }
+		flush = func() error { return nil }
+	}
+
+	written := 0
+	for rows.Next() {
+		var user User
+		if err := db.ScanRows(rows, &user); err != nil {
+			return written, err
+		}
+		if err := encode(user); err != nil {
+			return written, err
+		}
+		written++
+	}
+	if err := rows.Err(); err != nil {
+		return written, err
+	}
+	return written, flush()
+}
+
+// backupRecord is user as a CSV row in backupColumns order.
+func backupRecord(u User) []string {
+	var metadata string
+	if u.Metadata != nil {
+		b, _ := json.Marshal(u.Metadata)
+		metadata = string(b)
+	}
+	var deletedAt string
+	if u.DeletedAt.Valid {
+		deletedAt = u.DeletedAt.Time.UTC().Format(time.RFC3339Nano)
+	}
+	return []string{
+		u.Username,
+		u.Name,
+		u.Email,
+		strconv.FormatUint(uint64(u.ID), 10),
+		u.UUID,
+		u.Role,
+		strconv.FormatBool(u.Active),
+		metadata,
+		u.CreatedAt.UTC().Format(time.RFC3339Nano),
+		u.UpdatedAt.UTC().Format(time.RFC3339Nano),
+		deletedAt,
+	}
+}
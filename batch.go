@@ -0,0 +1,603 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxBatchItems caps how many users a batch request may carry.
+const maxBatchItems = 1000
+
+// batchChunkSize is how many items a non-atomic batch create decodes before
+// inserting them, so they don't pile up while the rest of the array is read.
+const batchChunkSize = 100
+
+var (
+	errBatchNotArray = errors.New("A batch must be a JSON array")
+	errBatchTooLarge = fmt.Errorf("A batch may contain at most %d users", maxBatchItems)
+)
+
+// decodeBatch reads a JSON array from dec one element at a time, calling fn
+// with each as soon as it is decoded. It stops with errBatchTooLarge at the
+// first element past maxBatchItems, without reading the rest of the body.
+func decodeBatch[T any](dec *json.Decoder, fn func(index int, item T) error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return errBatchNotArray
+	}
+	for i := 0; dec.More(); i++ {
+		if i == maxBatchItems {
+			return errBatchTooLarge
+		}
+		var item T
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+		if err := fn(i, item); err != nil {
+			return err
+		}
+	}
+	// The closing bracket; a truncated array fails here.
+	_, err = dec.Token()
+	return err
+}
+
+// batchDecodeAPIError is the response to a decodeBatch failure.
+func batchDecodeAPIError(err error) apiError {
+	if errors.Is(err, errBatchNotArray) || errors.Is(err, errBatchTooLarge) {
+		return apiError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	return decodeAPIError(err)
+}
+
+// batchItemReport is the validation outcome for one item of a batch.
+type batchItemReport struct {
+	Index  int          `json:"index"`
+	Valid  bool         `json:"valid"`
+	Errors []fieldError `json:"errors,omitempty"`
+}
+
+// batchValidationReport is the response of validateUserBatch.
+type batchValidationReport struct {
+	Valid   bool              `json:"valid"`
+	Results []batchItemReport `json:"results"`
+}
+
+// validateUserBatch runs the create validation over every user in a JSON
+// array and also flags emails and usernames that are already taken or
+// repeated within the batch. Nothing is written; the report lets a client
+// fix every problem before importing.
+func validateUserBatch(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	users := []User{}
+	err := decodeBatch(json.NewDecoder(r.Body), func(_ int, user User) error {
+		users = append(users, user)
+		return nil
+	})
+	if err != nil {
+		writeAPIError(w, r, batchDecodeAPIError(err))
+		return
+	}
+
+	emails := make([]string, 0, len(users))
+	usernames := make([]string, 0, len(users))
+	for i := range users {
+		users[i].Username = normalizeUsername(users[i].Username)
+		users[i].Email = normalizeEmail(users[i].Email)
+		emails = append(emails, users[i].Email)
+		usernames = append(usernames, users[i].Username)
+	}
+
+	// The unique indexes on LOWER(email) and username cover soft-deleted
+	// rows too.
+	var takenEmails, takenUsernames []string
+	if err := db.WithContext(r.Context()).Unscoped().Model(&User{}).Where("LOWER(email) IN ?", emails).Pluck("LOWER(email)", &takenEmails).Error; err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "Failed to check existing users")
+		return
+	}
+	if err := db.WithContext(r.Context()).Unscoped().Model(&User{}).Where("username IN ?", usernames).Pluck("username", &takenUsernames).Error; err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "Failed to check existing users")
+		return
+	}
+
+	inDB := map[string]bool{}
+	for _, e := range takenEmails {
+		inDB["email:"+e] = true
+	}
+	for _, u := range takenUsernames {
+		inDB["username:"+u] = true
+	}
+
+	report := batchValidationReport{Valid: true, Results: make([]batchItemReport, len(users))}
+	firstSeen := map[string]int{}
+	for i, user := range users {
+		errs := validateNewUser(user)
+		for _, key := range []struct{ field, value, taken, repeated string }{
+			{"email", emails[i], codeEmailDuplicate, codeEmailRepeated},
+			{"username", user.Username, codeUsernameDuplicate, codeUsernameRepeated},
+		} {
+			if key.value == "" {
+				continue
+			}
+			k := key.field + ":" + key.value
+			if inDB[k] {
+				errs = append(errs, fieldError{Field: key.field, Code: key.taken, Message: fmt.Sprintf("%s is already taken", capitalize(key.field))})
+			} else if j, ok := firstSeen[k]; ok {
+				errs = append(errs, fieldError{Field: key.field, Code: key.repeated, Message: fmt.Sprintf("%s duplicates item %d", capitalize(key.field), j)})
+			} else {
+				firstSeen[k] = i
+			}
+		}
+
+		report.Results[i] = batchItemReport{Index: i, Valid: len(errs) == 0, Errors: errs}
+		if len(errs) > 0 {
+			report.Valid = false
+		}
+	}
+
+	writeJSON(w, r, http.StatusOK, report)
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// maxBatchKeyLength caps the client-chosen idempotency key on batch items.
+const maxBatchKeyLength = 255
+
+// batchItemKey records which user a keyed batch item created, so replaying
+// the item returns that user instead of creating a second one.
+type batchItemKey struct {
+	Key       string    `gorm:"column:idempotency_key;primaryKey;size:255"`
+	UserID    uint      `gorm:"not null"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// batchKeyEvictBatch is how many expired keys one DELETE removes, so
+// eviction never holds locks on a large part of the table.
+const batchKeyEvictBatch = 1000
+
+// batchKeyEvictor deletes batch item keys older than ttl, after which
+// retrying their items creates them again.
+type batchKeyEvictor struct {
+	ttl time.Duration
+}
+
+func (e batchKeyEvictor) evictExpired(now time.Time) int {
+	if db == nil {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	evicted := 0
+	for {
+		expired := db.Model(&batchItemKey{}).Select("idempotency_key").Where("created_at < ?", now.Add(-e.ttl)).Limit(batchKeyEvictBatch)
+		result := db.WithContext(ctx).Where("idempotency_key IN (?)", expired).Delete(&batchItemKey{})
+		if result.Error != nil {
+			log.Printf("⚠️  Failed to evict batch item keys: %v", result.Error)
+			return evicted
+		}
+		evicted += int(result.RowsAffected)
+		if result.RowsAffected < batchKeyEvictBatch {
+			return evicted
+		}
+	}
+}
+
+func (batchKeyEvictor) name() string { return "batch item keys" }
+
+// batchCreateItem is one element of a batch create: the user plus an
+// optional idempotency key.
+type batchCreateItem struct {
+	Key string `json:"key,omitempty"`
+	User
+}
+
+// UnmarshalJSON decodes the key and the user separately; otherwise the
+// promoted User.UnmarshalJSON would take over and drop the key.
+func (item *batchCreateItem) UnmarshalJSON(data []byte) error {
+	var key struct {
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return err
+	}
+	item.Key = key.Key
+	return json.Unmarshal(data, &item.User)
+}
+
+// Outcomes of a batch create item.
+const (
+	batchItemCreated   = "created"
+	batchItemDuplicate = "duplicate"
+	batchItemInvalid   = "invalid"
+	batchItemFailed    = "failed"
+)
+
+// batchCreateResult is the outcome for one item of a batch create. Code is
+// the status the item would have had as a single create.
+type batchCreateResult struct {
+	Index  int          `json:"index"`
+	Key    string       `json:"key,omitempty"`
+	Status string       `json:"status"`
+	Code   int          `json:"code"`
+	User   *User        `json:"user,omitempty"`
+	Errors []fieldError `json:"errors,omitempty"`
+}
+
+// createUserBatch serves POST /api/users/batch. An item carrying a key that
+// was already processed is skipped and answered with the user it created
+// the first time, which makes retrying a batch safe.
+//
+// By default the batch is atomic: every item is created in one transaction,
+// and a single invalid or failing item means nothing is written, so the
+// client can fix the batch and resend it whole. With ?atomic=false items
+// are created one by one so a bad item doesn't sink the rest, and the
+// response is a 207 listing each item's outcome. That gets more in on the
+// first try, but leaves the client to retry just the failed items.
+func createUserBatch(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	atomic := true
+	if v := r.URL.Query().Get("atomic"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "atomic must be true or false")
+			return
+		}
+		atomic = b
+	}
+
+	dec := json.NewDecoder(r.Body)
+	if !atomic {
+		createUserBatchStreaming(w, r, dec)
+		return
+	}
+
+	// An atomic batch is validated whole before anything is written, so its
+	// items are all kept; maxBatchItems bounds them.
+	items := []batchCreateItem{}
+	err := decodeBatch(dec, func(_ int, item batchCreateItem) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		writeAPIError(w, r, batchDecodeAPIError(err))
+		return
+	}
+
+	createUserBatchAtomic(w, r, items)
+}
+
+func batchKeys(items []batchCreateItem) []string {
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.Key != "" {
+			keys = append(keys, item.Key)
+		}
+	}
+	return keys
+}
+
+// createUserBatchStreaming creates the items of a non-atomic batch
+// batchChunkSize at a time while the array is still being decoded. Items
+// processed before a malformed element, or before the one past
+// maxBatchItems, stay processed; the error response lists their results.
+func createUserBatchStreaming(w http.ResponseWriter, r *http.Request, dec *json.Decoder) {
+	results := []batchCreateResult{}
+	chunk := make([]batchCreateItem, 0, batchChunkSize)
+	var keysErr error
+	flush := func() error {
+		processed, err := processedBatchKeys(db.WithContext(r.Context()), batchKeys(chunk))
+		if err != nil {
+			keysErr = err
+			return err
+		}
+		for _, item := range chunk {
+			result := createBatchItem(r.Context(), len(results), item, processed)
+			if result.Status == batchItemCreated && item.Key != "" {
+				processed[item.Key] = *result.User
+			}
+			results = append(results, result)
+		}
+		chunk = chunk[:0]
+		return nil
+	}
+
+	err := decodeBatch(dec, func(_ int, item batchCreateItem) error {
+		chunk = append(chunk, item)
+		if len(chunk) < batchChunkSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil && len(chunk) > 0 {
+		err = flush()
+	}
+	if requestCanceled(w, r, err) || requestCanceled(w, r, r.Context().Err()) {
+		return
+	}
+	if err != nil {
+		e := batchDecodeAPIError(err)
+		if keysErr != nil {
+			e = apiError{Status: http.StatusInternalServerError, Message: "Failed to check batch keys"}
+		}
+		if len(results) > 0 {
+			e.Message += fmt.Sprintf("; the first %d items were processed", len(results))
+			if e.Extra == nil {
+				e.Extra = map[string]any{}
+			}
+			e.Extra["results"] = results
+		}
+		writeAPIError(w, r, e)
+		return
+	}
+
+	writeJSON(w, r, http.StatusMultiStatus, map[string]any{"results": results})
+}
+
+// errBatchInvalid rolls back an atomic batch that has invalid items.
+var errBatchInvalid = errors.New("batch contains invalid items")
+
+// errBatchKeysLookup wraps a failure to load the keys of an atomic batch.
+var errBatchKeysLookup = errors.New("failed to check batch keys")
+
+// createUserBatchAtomic creates every new item of the batch in a single
+// transaction. Invalid items are reported with a 422 before anything is
+// written; an item failing on insert rolls the whole batch back.
+//
+// The keys are looked up in the same transaction that claims the new
+// ones. A concurrent request claiming one of them in between makes an
+// insert fail on the key, or on the user, once that request commits, so
+// the batch is then run once more and the items it created are replayed.
+// If that fails too the conflict is real, and the batch is a 409.
+func createUserBatchAtomic(w http.ResponseWriter, r *http.Request, items []batchCreateItem) {
+	var (
+		results      []batchCreateResult
+		users        []User
+		firstWithKey map[string]int
+		failed       int
+		err          error
+	)
+	for attempt := 0; attempt < 2; attempt++ {
+		failed = -1
+		err = db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+			processed, err := processedBatchKeys(tx, batchKeys(items))
+			if err != nil {
+				return fmt.Errorf("%w: %w", errBatchKeysLookup, err)
+			}
+			var invalid bool
+			results, users, firstWithKey, invalid = prepareAtomicBatch(items, processed)
+			if invalid {
+				return errBatchInvalid
+			}
+			for i := range items {
+				if results[i].Status != "" {
+					continue
+				}
+				if err := insertBatchItem(r.Context(), tx, &users[i], items[i].Key); err != nil {
+					failed = i
+					return err
+				}
+			}
+			return nil
+		})
+		if !retriedConcurrently(err, batchKeys(items)...) {
+			break
+		}
+	}
+	if requestCanceled(w, r, err) {
+		return
+	}
+	if errors.Is(err, errBatchInvalid) {
+		writeAPIError(w, r, apiError{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "Batch contains invalid items; nothing was created",
+			Extra:   map[string]any{"results": results},
+		})
+		return
+	}
+	if errors.Is(err, errBatchKeysLookup) {
+		writeError(w, r, http.StatusInternalServerError, "Failed to check batch keys")
+		return
+	}
+	if err != nil {
+		e := apiError{Status: http.StatusInternalServerError, Message: fmt.Sprintf("Item %d: Failed to create user; nothing was created", failed)}
+		if conflict, ok := uniqueViolation(err); ok {
+			e.Status, e.Message = http.StatusConflict, fmt.Sprintf("Item %d: %s; nothing was created", failed, conflict.Message)
+			e.Errors = []fieldError{conflict}
+		}
+		e.Extra = map[string]any{"index": failed}
+		writeAPIError(w, r, e)
+		return
+	}
+
+	for i := range items {
+		switch {
+		case results[i].Status == "":
+			results[i].Status, results[i].Code, results[i].User = batchItemCreated, http.StatusCreated, &users[i]
+			hub.publish(userEvent{Type: eventUserCreated, User: users[i]})
+		case results[i].User == nil:
+			// Repeats a key created earlier in this batch.
+			results[i].User = &users[firstWithKey[items[i].Key]]
+		}
+	}
+	writeJSON(w, r, http.StatusOK, map[string]any{"results": results})
+}
+
+// prepareAtomicBatch prepares every item of an atomic batch. firstWithKey
+// maps a key to the item that will create its user, so a key repeated
+// within the batch answers with that item's user.
+func prepareAtomicBatch(items []batchCreateItem, processed map[string]User) (results []batchCreateResult, users []User, firstWithKey map[string]int, invalid bool) {
+	results = make([]batchCreateResult, len(items))
+	users = make([]User, len(items))
+	firstWithKey = map[string]int{}
+	for i, item := range items {
+		var ready bool
+		results[i], users[i], ready = prepareBatchItem(i, item, processed)
+		if results[i].Status == batchItemInvalid {
+			invalid = true
+		}
+		if !ready || item.Key == "" {
+			continue
+		}
+		if _, ok := firstWithKey[item.Key]; ok {
+			results[i].Status, results[i].Code = batchItemDuplicate, http.StatusOK
+			continue
+		}
+		firstWithKey[item.Key] = i
+	}
+	return results, users, firstWithKey, invalid
+}
+
+// retriedConcurrently reports whether err may be an insert losing the race
+// for one of keys to a concurrent retry: a violation of any unique
+// constraint, since the retry claims the user's username and email along
+// with its key.
+func retriedConcurrently(err error, keys ...string) bool {
+	_, conflict := uniqueViolation(err)
+	return conflict && slices.ContainsFunc(keys, func(k string) bool { return k != "" })
+}
+
+// processedBatchKeys loads the users previously created under any of keys,
+// reading through tx. Users deleted since are still returned: the key was
+// processed all the same, and recreating them on retry would undo the
+// delete.
+func processedBatchKeys(tx *gorm.DB, keys []string) (map[string]User, error) {
+	processed := map[string]User{}
+	if len(keys) == 0 {
+		return processed, nil
+	}
+
+	var rows []batchItemKey
+	if err := tx.Where("idempotency_key IN ?", keys).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]uint, len(rows))
+	for i, row := range rows {
+		ids[i] = row.UserID
+	}
+	var users []User
+	if len(ids) > 0 {
+		if err := tx.Unscoped().Find(&users, ids).Error; err != nil {
+			return nil, err
+		}
+	}
+	byID := make(map[uint]User, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+	for _, row := range rows {
+		processed[row.Key] = byID[row.UserID]
+	}
+	return processed, nil
+}
+
+// prepareBatchItem normalizes and validates an item. ready is false when the
+// item needs no insert, either because its key was already processed or
+// because it is invalid; result then holds its outcome.
+func prepareBatchItem(index int, item batchCreateItem, processed map[string]User) (result batchCreateResult, user User, ready bool) {
+	result = batchCreateResult{Index: index, Key: item.Key}
+	if len(item.Key) > maxBatchKeyLength {
+		result.Status, result.Code = batchItemInvalid, http.StatusUnprocessableEntity
+		result.Errors = []fieldError{{Field: "key", Code: codeKeyTooLong, Message: fmt.Sprintf("Key must be at most %d characters", maxBatchKeyLength)}}
+		return result, user, false
+	}
+	if prior, ok := processed[item.Key]; ok {
+		result.Status, result.Code, result.User = batchItemDuplicate, http.StatusOK, &prior
+		return result, user, false
+	}
+
+	user = item.User
+	user.Username = normalizeUsername(user.Username)
+	user.Email = normalizeEmail(user.Email)
+	user.DeletedAt = gorm.DeletedAt{}
+	user.UUID = ""
+	user.Role = ""
+	user.Active = true
+	if errs := validateNewUser(user); len(errs) > 0 {
+		result.Status, result.Code, result.Errors = batchItemInvalid, http.StatusUnprocessableEntity, errs
+		return result, user, false
+	}
+	return result, user, true
+}
+
+// insertBatchItem creates user along with its audit entry and, if key is
+// set, the row claiming the key.
+func insertBatchItem(ctx context.Context, tx *gorm.DB, user *User, key string) error {
+	if err := tx.Create(user).Error; err != nil {
+		return err
+	}
+	if err := tx.Create(newAuditEntry(ctx, auditCreated, *user)).Error; err != nil {
+		return err
+	}
+	if key == "" {
+		return nil
+	}
+	return tx.Create(&batchItemKey{Key: key, UserID: user.ID}).Error
+}
+
+// createBatchItem creates one item in its own transaction.
+func createBatchItem(ctx context.Context, index int, item batchCreateItem, processed map[string]User) batchCreateResult {
+	result, user, ready := prepareBatchItem(index, item, processed)
+	if !ready {
+		return result
+	}
+
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return insertBatchItem(ctx, tx, &user, item.Key)
+	})
+	if err != nil {
+		// A concurrent retry of the same batch may have claimed the key
+		// between the lookup and the insert, and then the insert fails on
+		// the key or on the user that retry created.
+		if retriedConcurrently(err, item.Key) {
+			again, lookupErr := processedBatchKeys(db.WithContext(ctx), []string{item.Key})
+			if prior, ok := again[item.Key]; lookupErr == nil && ok {
+				result.Status, result.Code, result.User = batchItemDuplicate, http.StatusOK, &prior
+				return result
+			}
+		}
+		result.Status, result.Code = batchItemFailed, http.StatusInternalServerError
+		conflict, ok := uniqueViolation(err)
+		if ok {
+			result.Code = http.StatusConflict
+		} else {
+			conflict = fieldError{Code: codeUserCreateFailed, Message: "Failed to create user"}
+		}
+		result.Errors = []fieldError{conflict}
+		return result
+	}
+
+	hub.publish(userEvent{Type: eventUserCreated, User: user})
+	result.Status, result.Code, result.User = batchItemCreated, http.StatusCreated, &user
+	return result
+}
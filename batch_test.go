@@ -0,0 +1,422 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// countUsersInDB returns how many users are stored, deleted ones included.
+func countUsersInDB(t *testing.T) int64 {
+	t.Helper()
+	var n int64
+	if err := db.Unscoped().Model(&User{}).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestCreateUserBatchModes(t *testing.T) {
+	const mixed = `[
+		{"username": "bob", "name": "Bob", "email": "bob@example.com"},
+		{"username": "x", "name": "", "email": "not-an-email"},
+		{"username": "alice2", "name": "Alice Again", "email": "alice@example.com"},
+		{"username": "carol", "name": "Carol", "email": "carol@example.com"}
+	]`
+	const valid = `[
+		{"username": "bob", "name": "Bob", "email": "bob@example.com"},
+		{"username": "carol", "name": "Carol", "email": "carol@example.com"}
+	]`
+
+	tests := []struct {
+		name     string
+		query    string
+		body     string
+		status   int
+		statuses []string
+		codes    []int
+		created  int64
+	}{
+		{"atomic, all valid", "", valid, http.StatusOK, []string{batchItemCreated, batchItemCreated}, []int{201, 201}, 2},
+		{"atomic, mixed", "", mixed, http.StatusUnprocessableEntity, []string{"", batchItemInvalid, "", ""}, []int{0, 422, 0, 0}, 0},
+		{"best effort, all valid", "?atomic=false", valid, http.StatusMultiStatus, []string{batchItemCreated, batchItemCreated}, []int{201, 201}, 2},
+		{"best effort, mixed", "?atomic=false", mixed, http.StatusMultiStatus, []string{batchItemCreated, batchItemInvalid, batchItemFailed, batchItemCreated}, []int{201, 422, 409, 201}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testDB(t)
+			seedUser(t, "alice", "Alice", "alice@example.com")
+
+			rec := serve(createUserBatch, http.MethodPost, "/api/users/batch"+tt.query, nil, tt.body, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			var body struct {
+				Results []batchCreateResult `json:"results"`
+			}
+			decodeBody(t, rec, &body)
+			if len(body.Results) != len(tt.statuses) {
+				t.Fatalf("got %d results, want %d: %s", len(body.Results), len(tt.statuses), rec.Body)
+			}
+			for i, res := range body.Results {
+				if res.Index != i || res.Status != tt.statuses[i] || res.Code != tt.codes[i] {
+					t.Errorf("item %d: index %d, %q %d, want %q %d", i, res.Index, res.Status, res.Code, tt.statuses[i], tt.codes[i])
+				}
+			}
+			if n := countUsersInDB(t) - 1; n != tt.created {
+				t.Errorf("%d users created, want %d", n, tt.created)
+			}
+		})
+	}
+}
+
+// Resending a keyed batch answers with the users the first one created.
+func TestCreateUserBatchReplay(t *testing.T) {
+	for _, query := range []string{"", "?atomic=false"} {
+		t.Run("atomic="+strings.TrimPrefix(query, "?atomic="), func(t *testing.T) {
+			testDB(t)
+			const body = `[{"key": "k1", "username": "bob", "name": "Bob", "email": "bob@example.com"}]`
+			var ids []uint
+			for range 2 {
+				rec := serve(createUserBatch, http.MethodPost, "/api/users/batch"+query, nil, body, nil)
+				var resp struct {
+					Results []batchCreateResult `json:"results"`
+				}
+				decodeBody(t, rec, &resp)
+				if len(resp.Results) != 1 || resp.Results[0].User == nil {
+					t.Fatalf("status %d: %s", rec.Code, rec.Body)
+				}
+				ids = append(ids, resp.Results[0].User.ID)
+			}
+			if ids[0] != ids[1] || countUsersInDB(t) != 1 {
+				t.Errorf("replay created another user: ids %v", ids)
+			}
+		})
+	}
+}
+
+// batchUsers returns a JSON array of n valid users, numbered from first.
+func batchUsers(first, n int) string {
+	items := make([]string, n)
+	for i := range items {
+		items[i] = fmt.Sprintf(`{"username": "user%[1]d", "name": "User %[1]d", "email": "user%[1]d@example.com"}`, first+i)
+	}
+	return "[" + strings.Join(items, ",") + "]"
+}
+
+func TestDecodeBatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		items int
+		fails bool
+		err   error
+	}{
+		{"empty array", `[]`, 0, false, nil},
+		{"one item", `[{"username": "a"}]`, 1, false, nil},
+		{"at the cap", batchUsers(0, maxBatchItems), maxBatchItems, false, nil},
+		{"past the cap", batchUsers(0, maxBatchItems+1), maxBatchItems, true, errBatchTooLarge},
+		{"object", `{"username": "a"}`, 0, true, errBatchNotArray},
+		{"truncated", `[{"username": "a"},`, 1, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := 0
+			err := decodeBatch(json.NewDecoder(strings.NewReader(tt.body)), func(i int, _ batchCreateItem) error {
+				if i != n {
+					t.Fatalf("index %d, want %d", i, n)
+				}
+				n++
+				return nil
+			})
+			if (err != nil) != tt.fails || tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v (fails %v)", err, tt.err, tt.fails)
+			}
+			if n != tt.items {
+				t.Errorf("%d items decoded, want %d", n, tt.items)
+			}
+		})
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// A batch past the cap is rejected without reading the rest of it.
+func TestDecodeBatchStopsAtCap(t *testing.T) {
+	body := batchUsers(0, 10*maxBatchItems)
+	r := &countingReader{r: strings.NewReader(body)}
+	err := decodeBatch(json.NewDecoder(r), func(int, batchCreateItem) error { return nil })
+	if !errors.Is(err, errBatchTooLarge) {
+		t.Fatalf("err = %v, want errBatchTooLarge", err)
+	}
+	if r.n > len(body)/5 {
+		t.Errorf("read %d of %d bytes", r.n, len(body))
+	}
+}
+
+// Items reach the callback while the rest of the array is still unsent.
+func TestDecodeBatchStreams(t *testing.T) {
+	pr, pw := io.Pipe()
+	seen := make(chan struct{})
+	go func() {
+		io.WriteString(pw, `[{"username": "first"},`)
+		select {
+		case <-seen:
+		case <-time.After(5 * time.Second):
+		}
+		io.WriteString(pw, `{"username": "second"}]`)
+		pw.Close()
+	}()
+
+	var got []string
+	err := decodeBatch(json.NewDecoder(pr), func(i int, item batchCreateItem) error {
+		got = append(got, item.Username)
+		if i == 0 {
+			close(seen)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != "first" || got[1] != "second" {
+		t.Errorf("items = %v", got)
+	}
+}
+
+// A best-effort batch commits each chunk as soon as it is decoded, so a
+// large batch never has to be held whole: the first chunk is stored while
+// the client is still sending the rest.
+func TestCreateUserBatchStreamsChunks(t *testing.T) {
+	testDB(t)
+
+	pr, pw := io.Pipe()
+	req := httptest.NewRequest(http.MethodPost, "/api/users/batch?atomic=false", pr)
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serveRequest(createUserBatch, req, nil) }()
+
+	first := batchUsers(0, batchChunkSize+1)
+	io.WriteString(pw, strings.TrimSuffix(first, "]")+",")
+	deadline := time.Now().Add(5 * time.Second)
+	for countUsersInDB(t) < batchChunkSize {
+		if time.Now().After(deadline) {
+			t.Fatalf("first chunk not committed while the batch was still open")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := countUsersInDB(t); n != batchChunkSize {
+		t.Errorf("%d users stored mid-batch, want exactly one chunk (%d)", n, batchChunkSize)
+	}
+
+	rest := batchUsers(batchChunkSize+1, 2*batchChunkSize)
+	io.WriteString(pw, strings.TrimPrefix(rest, "["))
+	pw.Close()
+
+	rec := <-done
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d, want 207: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Results []batchCreateResult `json:"results"`
+	}
+	decodeBody(t, rec, &body)
+	if want := 3*batchChunkSize + 1; len(body.Results) != want || countUsersInDB(t) != int64(want) {
+		t.Errorf("%d results, %d users stored, want %d", len(body.Results), countUsersInDB(t), want)
+	}
+}
+
+// Best-effort items before the one past the cap stay created.
+func TestCreateUserBatchStreamedCap(t *testing.T) {
+	testDB(t)
+	rec := serve(createUserBatch, http.MethodPost, "/api/users/batch?atomic=false", nil, batchUsers(0, maxBatchItems+1), nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body)
+	}
+	if n := countUsersInDB(t); n != maxBatchItems {
+		t.Errorf("%d users stored, want %d", n, maxBatchItems)
+	}
+}
+
+func TestBatchKeyEvictor(t *testing.T) {
+	testDB(t)
+	user := seedUser(t, "alice", "Alice", "alice@example.com")
+	now := time.Now()
+	for i, age := range []time.Duration{time.Minute, 23 * time.Hour, 25 * time.Hour, 72 * time.Hour} {
+		key := batchItemKey{Key: fmt.Sprintf("key-%d", i), UserID: user.ID, CreatedAt: now.Add(-age)}
+		if err := db.Create(&key).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	evictor := batchKeyEvictor{ttl: 24 * time.Hour}
+	if n := evictor.evictExpired(now); n != 2 {
+		t.Errorf("evicted %d keys, want 2", n)
+	}
+	var kept []string
+	if err := db.Model(&batchItemKey{}).Order("idempotency_key").Pluck("idempotency_key", &kept).Error; err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"key-0", "key-1"}; strings.Join(kept, ",") != strings.Join(want, ",") {
+		t.Errorf("kept keys = %v, want %v", kept, want)
+	}
+	if n := evictor.evictExpired(now); n != 0 {
+		t.Errorf("second run evicted %d keys, want 0", n)
+	}
+}
+
+// Eviction deletes in bounded batches until nothing expired is left.
+func TestBatchKeyEvictorBatches(t *testing.T) {
+	testDB(t)
+	user := seedUser(t, "alice", "Alice", "alice@example.com")
+	old := time.Now().Add(-48 * time.Hour)
+	keys := make([]batchItemKey, batchKeyEvictBatch+10)
+	for i := range keys {
+		keys[i] = batchItemKey{Key: fmt.Sprintf("key-%d", i), UserID: user.ID, CreatedAt: old}
+	}
+	if err := db.CreateInBatches(keys, 500).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if n := (batchKeyEvictor{ttl: 24 * time.Hour}).evictExpired(time.Now()); n != len(keys) {
+		t.Errorf("evicted %d keys, want %d", n, len(keys))
+	}
+}
+
+func TestBatchKeyEvictorWithoutDB(t *testing.T) {
+	prev := db
+	db = nil
+	t.Cleanup(func() { db = prev })
+	if n := (batchKeyEvictor{ttl: time.Hour}).evictExpired(time.Now()); n != 0 {
+		t.Errorf("evicted %d keys without a database", n)
+	}
+}
+
+func TestRetriedConcurrently(t *testing.T) {
+	keyTaken := &pgconn.PgError{Code: "23505", ConstraintName: "batch_item_keys_pkey"}
+	usernameTaken := &pgconn.PgError{Code: "23505", ConstraintName: "idx_users_username"}
+	tests := []struct {
+		name string
+		err  error
+		keys []string
+		want bool
+	}{
+		{"no error", nil, []string{"k1"}, false},
+		{"other error", errors.New("boom"), []string{"k1"}, false},
+		{"key taken", keyTaken, []string{"k1"}, true},
+		{"wrapped", fmt.Errorf("insert: %w", keyTaken), []string{"k1"}, true},
+		{"username taken by a keyed item", usernameTaken, []string{"", "k1"}, true},
+		{"username taken without keys", usernameTaken, []string{"", ""}, false},
+		{"nothing keyed", usernameTaken, nil, false},
+	}
+	for _, tt := range tests {
+		if got := retriedConcurrently(tt.err, tt.keys...); got != tt.want {
+			t.Errorf("%s: retriedConcurrently = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Concurrent retries of the same keyed batch create its users once. Every
+// other attempt is answered with a replay or, if the key stayed contested,
+// a 409 naming the key rather than the user.
+func TestCreateUserBatchConcurrentRetries(t *testing.T) {
+	for _, query := range []string{"", "?atomic=false"} {
+		t.Run("atomic"+query, func(t *testing.T) {
+			testDB(t)
+			const body = `[
+				{"key": "k1", "username": "bob", "name": "Bob", "email": "bob@example.com"},
+				{"key": "k2", "username": "carol", "name": "Carol", "email": "carol@example.com"}
+			]`
+
+			const clients = 5
+			recs := make([]*httptest.ResponseRecorder, clients)
+			var wg sync.WaitGroup
+			for i := range clients {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					recs[i] = serve(createUserBatch, http.MethodPost, "/api/users/batch"+query, nil, body, nil)
+				}()
+			}
+			wg.Wait()
+
+			for i, rec := range recs {
+				switch rec.Code {
+				case http.StatusOK, http.StatusMultiStatus:
+					var resp struct {
+						Results []batchCreateResult `json:"results"`
+					}
+					decodeBody(t, rec, &resp)
+					for _, res := range resp.Results {
+						if res.Status != batchItemCreated && res.Status != batchItemDuplicate {
+							t.Errorf("client %d: item %d is %s: %+v", i, res.Index, res.Status, res.Errors)
+						}
+					}
+				case http.StatusConflict:
+					var e struct {
+						Errors []fieldError `json:"errors"`
+					}
+					decodeBody(t, rec, &e)
+					if len(e.Errors) != 1 || e.Errors[0].Code != codeKeyConflict {
+						t.Errorf("client %d: 409 with %s, want %s", i, rec.Body, codeKeyConflict)
+					}
+				default:
+					t.Errorf("client %d: status = %d: %s", i, rec.Code, rec.Body)
+				}
+			}
+			if n := countUsersInDB(t); n != 2 {
+				t.Errorf("%d users created, want 2", n)
+			}
+		})
+	}
+}
+
+// Soft-deleted users still hold their email and username under the unique
+// indexes, so the validation report flags them like live ones.
+func TestValidateUserBatchSoftDeleted(t *testing.T) {
+	testDB(t)
+	gone := seedUser(t, "alice", "Alice", "alice@example.com")
+	if err := db.Delete(&gone).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	body := `[
+		{"username": "alice2", "name": "Alice", "email": "Alice@Example.com"},
+		{"username": "alice", "name": "Alice", "email": "alice2@example.com"},
+		{"username": "bob", "name": "Bob", "email": "bob@example.com"}
+	]`
+	rec := serve(validateUserBatch, http.MethodPost, "/api/users/batch/validate", nil, body, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var report batchValidationReport
+	decodeBody(t, rec, &report)
+	want := [][]string{{codeEmailDuplicate}, {codeUsernameDuplicate}, {}}
+	if report.Valid || len(report.Results) != len(want) {
+		t.Fatalf("report = %+v, want 3 results, not all valid", report)
+	}
+	for i, codes := range want {
+		if got := errorCodes(report.Results[i].Errors); !slices.Equal(got, codes) {
+			t.Errorf("item %d: codes = %v, want %v", i, got, codes)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// A gzipped batch reaches the handler decompressed, and anything the
+// middleware can't or won't decompress is rejected before it.
+func TestRequestBodyBatch(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"MAX_BODY_BYTES": "4096"})
+	prev := db
+	// validateUserBatch only looks up existing users, which a dry run finds
+	// none of.
+	db = dryRunDB(t)
+	t.Cleanup(func() { db = prev })
+
+	batch := `[{"username": "alice", "name": "Alice", "email": "alice@example.com"}, {"username": "bob", "name": "Bob", "email": "bob@example.com"}]`
+	full := gzipped(t, batch)
+	bomb := gzipped(t, "["+strings.Repeat(" ", 64<<10)+"]")
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     string
+		status   int
+		message  string
+	}{
+		{"plain", "", batch, http.StatusOK, `"valid":true`},
+		{"gzip", "gzip", full, http.StatusOK, `"valid":true`},
+		{"x-gzip", "x-gzip", full, http.StatusOK, `"valid":true`},
+		{"encoding in capitals", " GZIP ", full, http.StatusOK, `"valid":true`},
+		{"identity", "identity", batch, http.StatusOK, `"valid":true`},
+		{"not gzip", "gzip", batch, http.StatusBadRequest, "Malformed gzip body"},
+		{"truncated gzip", "gzip", full[:len(full)/2], http.StatusBadRequest, ""},
+		{"empty gzip body", "gzip", "", http.StatusBadRequest, "Request body is empty"},
+		{"gzip bomb", "gzip", bomb, http.StatusRequestEntityTooLarge, "Request body exceeds 4096 bytes"},
+		{"unsupported encoding", "br", batch, http.StatusUnsupportedMediaType, "Unsupported Content-Encoding 'br'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/users/batch/validate", strings.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			rec := httptest.NewRecorder()
+			requestBody(cfg)(http.HandlerFunc(validateUserBatch)).ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.message) {
+				t.Errorf("body = %s, want it to mention %s", rec.Body, tt.message)
+			}
+			if tt.status == http.StatusUnsupportedMediaType && rec.Header().Get("Accept-Encoding") != "gzip" {
+				t.Errorf("Accept-Encoding = %q, want gzip", rec.Header().Get("Accept-Encoding"))
+			}
+		})
+	}
+}
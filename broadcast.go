@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// broadcastBatchSize is how many users are loaded at a time while a
+// broadcast runs.
+const broadcastBatchSize = 500
+
+// broadcastSendTimeout bounds a single send, so one stuck SMTP exchange
+// can't stall the rest of the broadcast.
+const broadcastSendTimeout = 30 * time.Second
+
+var broadcastEmails = newCounterVec("broadcast_emails_total",
+	"Emails sent by admin broadcasts, by outcome.", "status")
+
+// broadcastRequest is the body of POST /api/admin/broadcast. AfterID
+// resumes an interrupted broadcast after the last user it reached.
+type broadcastRequest struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	AfterID uint   `json:"after_id"`
+}
+
+// broadcaster runs at most one broadcast at a time.
+type broadcaster struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var broadcasts broadcaster
+
+// start launches send in the background unless a broadcast is already
+// running.
+func (b *broadcaster) start(send func(ctx context.Context)) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done != nil {
+		return false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel, b.done = cancel, make(chan struct{})
+	go func() {
+		defer func() {
+			b.mu.Lock()
+			close(b.done)
+			b.cancel, b.done = nil, nil
+			b.mu.Unlock()
+		}()
+		send(ctx)
+	}()
+	return true
+}
+
+// stop cancels the running broadcast, if any, and waits until it has
+// logged where it stopped or ctx expires.
+func (b *broadcaster) stop(ctx context.Context) {
+	b.mu.Lock()
+	cancel, done := b.cancel, b.done
+	b.mu.Unlock()
+	if done == nil {
+		return
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// startBroadcast serves POST /api/admin/broadcast. It answers 202 at once
+// and emails every active user in the background through mailer, at most
+// BROADCAST_RATE messages per second so the SMTP server isn't swamped.
+// Progress is logged after each batch and counted in
+// broadcast_emails_total. Only one broadcast runs at a time; another
+// request meanwhile gets a 409.
+func startBroadcast(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	var req broadcastRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	req.Subject = strings.TrimSpace(req.Subject)
+	var errs []fieldError
+	if req.Subject == "" {
+		errs = append(errs, fieldError{Field: "subject", Code: codeSubjectRequired, Message: "Subject is required"})
+	} else if strings.ContainsAny(req.Subject, "\r\n") {
+		errs = append(errs, fieldError{Field: "subject", Code: codeSubjectInvalid, Message: "Subject must be a single line"})
+	}
+	if strings.TrimSpace(req.Body) == "" {
+		errs = append(errs, fieldError{Field: "body", Code: codeBodyRequired, Message: "Body is required"})
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	actor := authUser(r)
+	started := broadcasts.start(func(ctx context.Context) {
+		runBroadcast(ctx, req, actor, config.BroadcastRate)
+	})
+	if !started {
+		writeError(w, r, http.StatusConflict, "A broadcast is already running")
+		return
+	}
+	writeJSON(w, r, http.StatusAccepted, map[string]string{"status": "started"})
+}
+
+// runBroadcast sends req to every active user with an ID above
+// req.AfterID, in ID order. If ctx is cancelled, by shutdown for instance,
+// it stops between messages and logs the last user reached so the
+// broadcast can be resumed with after_id.
+func runBroadcast(ctx context.Context, req broadcastRequest, actor string, perSecond int) {
+	log.Printf("📣 Broadcast %q started by %s", req.Subject, actor)
+	tick := time.NewTicker(time.Second / time.Duration(perSecond))
+	defer tick.Stop()
+
+	var sent, failed int
+	lastID := req.AfterID
+	var batch []User
+	err := db.WithContext(ctx).Where("id > ? AND active", req.AfterID).Order("id").
+		FindInBatches(&batch, broadcastBatchSize, func(tx *gorm.DB, _ int) error {
+			for _, u := range batch {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-tick.C:
+				}
+				sendCtx, cancel := context.WithTimeout(ctx, broadcastSendTimeout)
+				err := mailer.Send(sendCtx, u.Email, req.Subject, req.Body)
+				cancel()
+				if err != nil {
+					failed++
+					broadcastEmails.inc("failed")
+					log.Printf("❌ Broadcast to user %d failed: %v", u.ID, err)
+				} else {
+					sent++
+					broadcastEmails.inc("sent")
+				}
+				lastID = u.ID
+			}
+			log.Printf("📣 Broadcast %q: %d sent, %d failed, through user %d", req.Subject, sent, failed, lastID)
+			return nil
+		}).Error
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		log.Printf("🛑 Broadcast %q stopped: %d sent, %d failed; resume with after_id=%d", req.Subject, sent, failed, lastID)
+	case err != nil:
+		log.Printf("❌ Broadcast %q failed after user %d: %v; resume with after_id=%d", req.Subject, lastID, err, lastID)
+	default:
+		log.Printf("✅ Broadcast %q finished: %d sent, %d failed", req.Subject, sent, failed)
+	}
+}
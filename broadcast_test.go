@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestStartBroadcastRejects(t *testing.T) {
+	testConfig(t, nil)
+	prev := db
+	db = dryRunDB(t)
+	t.Cleanup(func() { db = prev })
+
+	tests := []struct {
+		name  string
+		body  string
+		codes []string
+	}{
+		{"nothing", `{}`, []string{codeSubjectRequired, codeBodyRequired}},
+		{"blank subject", `{"subject": "  ", "body": "Hi"}`, []string{codeSubjectRequired}},
+		{"multi-line subject", `{"subject": "Hello\r\nBcc: mallory@example.com", "body": "Hi"}`, []string{codeSubjectInvalid}},
+		{"blank body", `{"subject": "Hello", "body": "\n"}`, []string{codeBodyRequired}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(startBroadcast, http.MethodPost, "/api/admin/broadcast", nil, tt.body, nil)
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status = %d, want 422: %s", rec.Code, rec.Body)
+			}
+			var apiErr apiError
+			decodeBody(t, rec, &apiErr)
+			if got := errorCodes(apiErr.Errors); !slices.Equal(got, tt.codes) {
+				t.Errorf("codes = %v, want %v", got, tt.codes)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// userChange is a user as reported to delta-sync clients. Deleted users are
+// included so clients can drop them from their local copy.
+type userChange struct {
+	User
+	Deleted bool `json:"deleted"`
+}
+
+// userChangesPage is the envelope returned by getUserChanges.
+type userChangesPage struct {
+	Data   []userChange `json:"data"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+}
+
+// changedAt is the time a user row last changed. A soft delete only sets
+// deleted_at, so it has to be considered alongside updated_at.
+const changedAt = "GREATEST(updated_at, COALESCE(deleted_at, updated_at))"
+
+// getUserChanges returns users created, updated or deleted after the
+// RFC 3339 timestamp in the since parameter, oldest change first.
+func getUserChanges(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	sinceParam := r.URL.Query().Get("since")
+	if sinceParam == "" {
+		writeError(w, r, http.StatusBadRequest, "since is required")
+		return
+	}
+	since, err := time.Parse(time.RFC3339, sinceParam)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "since must be an RFC 3339 timestamp")
+		return
+	}
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var users []User
+	result := db.WithContext(r.Context()).Unscoped().
+		Where(changedAt+" > ?", since).
+		Order(changedAt + ", id").
+		Limit(limit).
+		Offset(offset).
+		Find(&users)
+	if result.Error != nil {
+		if requestCanceled(w, r, result.Error) {
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve user changes")
+		return
+	}
+
+	page := userChangesPage{Data: make([]userChange, len(users)), Limit: limit, Offset: offset}
+	for i, u := range users {
+		page.Data[i] = userChange{User: u, Deleted: u.DeletedAt.Valid}
+	}
+
+	writeJSON(w, r, http.StatusOK, page)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The changes feed runs its query under the request context, so it gives
+// up with the request and answers 503 like every other read.
+func TestGetUserChangesHonorsRequestContext(t *testing.T) {
+	tests := []struct {
+		name        string
+		poolTimeout time.Duration
+		deadline    time.Duration
+		retryAfter  string
+	}{
+		{"pool exhausted", 50 * time.Millisecond, 0, "1"},
+		{"time budget spent", time.Minute, 50 * time.Millisecond, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testConfig(t, nil)
+			pool := saturatedPool(t, tt.poolTimeout)
+			conn, err := gorm.Open(postgres.New(postgres.Config{Conn: pool.db}), &gorm.Config{DisableAutomaticPing: true, Logger: logger.Discard})
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.ConnPool, conn.Statement.ConnPool = pool, pool
+			prev := db
+			db = conn
+			t.Cleanup(func() { db = prev })
+
+			req := httptest.NewRequest(http.MethodGet, "/api/users/changes?since=2025-03-01T12:00:00Z", nil)
+			if tt.deadline > 0 {
+				ctx, cancel := context.WithTimeout(req.Context(), tt.deadline)
+				defer cancel()
+				req = req.WithContext(ctx)
+			}
+			start := time.Now()
+			rec := serveRequest(getUserChanges, req, nil)
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Fatalf("status = %d, want 503: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.retryAfter)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("answered after %s", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	testConfig(t, map[string]string{"TRUSTED_PROXIES": "10.0.0.0/8, 192.168.1.1"})
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		realIP    string
+		want      string
+	}{
+		{"untrusted peer ignores headers", "203.0.113.9:4000", "198.51.100.1", "198.51.100.2", "203.0.113.9"},
+		{"trusted peer, single hop", "10.1.2.3:4000", "198.51.100.1", "", "198.51.100.1"},
+		{"trusted peer, bare address entry", "192.168.1.1:4000", "198.51.100.1", "", "198.51.100.1"},
+		{"spoofed leftmost hop is skipped", "10.1.2.3:4000", "1.2.3.4, 198.51.100.1", "", "198.51.100.1"},
+		{"trusted hops are walked past", "10.1.2.3:4000", "198.51.100.1, 10.9.9.9", "", "198.51.100.1"},
+		{"all hops trusted", "10.1.2.3:4000", "10.0.0.5, 10.9.9.9", "", "10.0.0.5"},
+		{"X-Real-IP from a trusted peer", "10.1.2.3:4000", "", "198.51.100.7", "198.51.100.7"},
+		{"malformed X-Forwarded-For", "10.1.2.3:4000", "not-an-ip", "", "10.1.2.3"},
+		{"no headers", "10.1.2.3:4000", "", "", "10.1.2.3"},
+		{"IPv4-mapped trusted peer", "[::ffff:10.1.2.3]:4000", "198.51.100.1", "", "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := ClientIP(req); got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, err := parseTrustedProxies([]string{"10.1.2.3/8", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := prefixes[0].String(); got != "10.0.0.0/8" {
+		t.Errorf("prefix not masked: %s", got)
+	}
+	if got := prefixes[1].String(); got != "::1/128" {
+		t.Errorf("bare address = %s, want ::1/128", got)
+	}
+	for _, bad := range []string{"10.0.0.0/33", "proxy.internal"} {
+		if _, err := parseTrustedProxies([]string{bad}); err == nil {
+			t.Errorf("parseTrustedProxies(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// streamingPaths hold their connection for as long as the client stays, so
+// they would pin concurrency slots indefinitely. They are exempt from the
+// in-flight limit and bounded by STREAM_MAX_LIFETIME instead.
+var streamingPaths = map[string]bool{
+	"/api/users/stream": true,
+	"/api/users/events": true,
+	"/api/users/ws":     true,
+}
+
+// concurrencyLimiter caps how many requests are processed at once. When
+// every slot is taken a request waits up to cfg.ConcurrencyWait for one to
+// free up, then gets a 503 with Retry-After. A zero wait rejects at once.
+func concurrencyLimiter(cfg *Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if cfg.MaxConcurrentRequests <= 0 {
+			return next
+		}
+		slots := make(chan struct{}, cfg.MaxConcurrentRequests)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if streamingPaths[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !acquireSlot(r, slots, cfg.ConcurrencyWait) {
+				if requestCanceled(w, r, r.Context().Err()) {
+					return
+				}
+				w.Header().Set("Retry-After", "1")
+				writeError(w, r, http.StatusServiceUnavailable, "Server is busy, try again shortly")
+				return
+			}
+			defer func() { <-slots }()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func acquireSlot(r *http.Request, slots chan struct{}, wait time.Duration) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+	if wait <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// saturatedLimiter returns a limiter allowing two requests at once that
+// already has both slots held by requests blocked in the handler. Closing
+// the returned channel lets them finish.
+func saturatedLimiter(t *testing.T, wait time.Duration) (http.Handler, chan struct{}, chan int) {
+	t.Helper()
+	cfg := testConfig(t, nil)
+	cfg.MaxConcurrentRequests = 2
+	cfg.ConcurrencyWait = wait
+
+	release := make(chan struct{})
+	entered := make(chan struct{}, 8)
+	h := concurrencyLimiter(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !streamingPaths[r.URL.Path] {
+			entered <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	held := make(chan int, cfg.MaxConcurrentRequests)
+	for range cfg.MaxConcurrentRequests {
+		go func() {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+			held <- rec.Code
+		}()
+		<-entered
+	}
+	t.Cleanup(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	})
+	return h, release, held
+}
+
+func TestConcurrencyLimiterOverflow(t *testing.T) {
+	tests := []struct {
+		name string
+		wait time.Duration
+		path string
+		want int
+	}{
+		{"rejects at once without a wait", 0, "/api/users", http.StatusServiceUnavailable},
+		{"rejects after the wait runs out", 20 * time.Millisecond, "/api/users", http.StatusServiceUnavailable},
+		{"streaming paths are exempt", 0, "/api/users/stream", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _, _ := saturatedLimiter(t, tt.wait)
+			rec := httptest.NewRecorder()
+			start := time.Now()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want != http.StatusServiceUnavailable {
+				return
+			}
+			if got := rec.Header().Get("Retry-After"); got != "1" {
+				t.Errorf("Retry-After = %q, want 1", got)
+			}
+			if elapsed := time.Since(start); elapsed < tt.wait {
+				t.Errorf("rejected after %v, before the %v wait", elapsed, tt.wait)
+			}
+		})
+	}
+}
+
+// A request waiting for a slot gets the first one freed.
+func TestConcurrencyLimiterWaitsForSlot(t *testing.T) {
+	h, release, held := saturatedLimiter(t, 5*time.Second)
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+		done <- rec.Code
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	for range 2 {
+		if code := <-held; code != http.StatusOK {
+			t.Errorf("held request: status = %d", code)
+		}
+	}
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("waiting request: status = %d, want 200", code)
+	}
+}
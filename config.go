@@ -0,0 +1,633 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the settings the server reads from the environment at startup.
+type Config struct {
+	Port            string
+	ListenSocket    string
+	LogLevel        slog.Level
+	DefaultPageSize int
+	MaxPageSize     int
+	// DefaultSort orders the user list when the request has no ?sort=
+	// (DEFAULT_SORT, default "id"), in the same syntax as the parameter.
+	DefaultSort string
+	// Timezone is the zone user timestamps are rendered in when the request
+	// has no ?tz= (DEFAULT_TIMEZONE, an IANA name, default "UTC").
+	Timezone *time.Location
+
+	// Features turns optional endpoint groups on and off (FEATURE_*).
+	Features Features
+
+	AuthMode       string
+	BasicAuthUsers []basicCredential
+
+	CORSAllowedOrigins   []string
+	CORSAllowCredentials bool
+	CORSMaxAge           int
+	CORSExposedHeaders   []string
+
+	TrustedProxies []netip.Prefix
+
+	AdminUsers []string
+
+	DBMaxOpenConns    int
+	DBMaxIdleConns    int
+	DBConnMaxLifetime time.Duration
+	// DBConnMaxIdleTime closes connections idle for longer, so they are
+	// recycled before a server-side idle timeout (managed Postgres,
+	// PgBouncer) drops them (DB_CONN_MAX_IDLE_TIME, default 1m, 0 = never).
+	DBConnMaxIdleTime time.Duration
+	// DBWarmupConns connections are opened and pinged before the server
+	// starts accepting traffic, so the first requests don't each pay for a
+	// new connection (DB_WARMUP_CONNS, default 0). At most
+	// DB_MAX_IDLE_CONNS, since the pool closes any idle ones beyond that.
+	DBWarmupConns int
+	// DBAcquireTimeout is how long a query waits for a free pooled
+	// connection, including dialing a new one, before the request gets a
+	// 503 with Retry-After (DB_ACQUIRE_TIMEOUT, default 2s, 0 = wait for
+	// the request's own deadline).
+	DBAcquireTimeout time.Duration
+
+	StreamMaxLifetime time.Duration
+
+	RateLimitDefault *rateLimit
+	RateLimits       map[string]rateLimit
+	// RateLimitMode is "enforce" to answer requests over their limit with
+	// 429, or "monitor" to only log and count them (RATE_LIMIT_MODE, or the
+	// contents of RATE_LIMIT_MODE_FILE, which SIGHUP re-reads; default
+	// enforce).
+	RateLimitMode string
+
+	DisposableEmailDomains domainSet
+	// AllowedEmailDomains, when set, restricts the domains new and updated
+	// emails may use (ALLOWED_EMAIL_DOMAINS and/or
+	// ALLOWED_EMAIL_DOMAINS_FILE). nil allows every domain.
+	AllowedEmailDomains domainSet
+	// BlockedEmailDomains are rejected for new and updated emails
+	// (BLOCKED_EMAIL_DOMAINS and/or BLOCKED_EMAIL_DOMAINS_FILE). This is the
+	// list loaded at startup; SIGHUP reloads it into blockedEmailDomains.
+	BlockedEmailDomains domainSet
+
+	// EmailChangeTokenTTL is how long an email change token stays valid
+	// (EMAIL_CHANGE_TOKEN_TTL, default 24h).
+	EmailChangeTokenTTL time.Duration
+
+	// BroadcastRate caps how many emails an admin broadcast sends per
+	// second (BROADCAST_RATE, default 10).
+	BroadcastRate int
+
+	// SMTP configures outgoing mail (SMTP_HOST, SMTP_PORT default 587,
+	// SMTP_USERNAME, SMTP_PASSWORD, SMTP_FROM). nil when SMTP_HOST is
+	// unset, in which case mail is only logged.
+	SMTP *smtpConfig
+
+	// Backup configures POST /api/admin/backup (BACKUP_BUCKET,
+	// BACKUP_ENDPOINT default AWS S3, BACKUP_REGION default us-east-1,
+	// BACKUP_ACCESS_KEY_ID, BACKUP_SECRET_ACCESS_KEY, BACKUP_PREFIX default
+	// "backups/", BACKUP_TIMEOUT default 1h). The credentials fall back to
+	// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY. nil when BACKUP_BUCKET
+	// is unset, in which case the endpoint isn't registered.
+	Backup *backupConfig
+
+	// Storage is where uploaded files such as avatars are kept
+	// (STORAGE_BACKEND, "local" or "s3", default local). The local backend
+	// writes under STORAGE_LOCAL_DIR (default ./data/blobs) and serves the
+	// files itself; s3 uses STORAGE_S3_BUCKET and the other STORAGE_S3_*
+	// settings, as for backups. STORAGE_PUBLIC_URL replaces the base of the
+	// URLs handed out, e.g. to point them at a CDN.
+	Storage storageConfig
+
+	// RequestIDHeader is the header request IDs are read from and echoed in
+	// (REQUEST_ID_HEADER, default X-Request-ID).
+	RequestIDHeader string
+
+	// MaxHeaderBytes caps the size of request headers (MAX_HEADER_BYTES,
+	// default 1MB, the net/http default).
+	MaxHeaderBytes int
+	// ReadHeaderTimeout bounds how long a client may take to send its
+	// headers, so slowloris clients can't hold connections open
+	// (READ_HEADER_TIMEOUT, default 5s).
+	ReadHeaderTimeout time.Duration
+
+	// HousekeepingInterval is how often expired state is evicted
+	// (HOUSEKEEPING_INTERVAL, default 1m).
+	HousekeepingInterval time.Duration
+	// BatchKeyTTL is how long a batch item's idempotency key is remembered
+	// (BATCH_KEY_TTL, default 24h, 0 = forever). Retrying an item after
+	// that creates it again.
+	BatchKeyTTL time.Duration
+
+	// DisabledEndpoints are "METHOD /path" routes left unregistered, e.g. to
+	// run a read-only instance (DISABLED_ENDPOINTS).
+	DisabledEndpoints []string
+
+	// AutoMigrate migrates the schema at startup (AUTO_MIGRATE, default
+	// true). Deployments that run the migrate subcommand instead can turn
+	// it off, and turn on CheckSchemaDrift (CHECK_SCHEMA_DRIFT, default
+	// false) to have startup compare the tables with the models and log
+	// any difference, or exit on one if StrictSchema (STRICT_SCHEMA,
+	// default false) is set. The check never changes the schema.
+	AutoMigrate      bool
+	CheckSchemaDrift bool
+	StrictSchema     bool
+
+	// AdminShutdownEnabled registers POST /api/admin/shutdown
+	// (ADMIN_SHUTDOWN_ENABLED, default false).
+	AdminShutdownEnabled bool
+
+	// ReadCacheControl is the Cache-Control header sent on successful reads
+	// of users. CACHE_MAX_AGE sets the max-age in seconds (default 30), or
+	// "no-cache" to make clients revalidate every time.
+	ReadCacheControl string
+
+	// MaxConcurrentRequests caps requests processed at once
+	// (MAX_CONCURRENT_REQUESTS, default 0 = unlimited). Over the cap a
+	// request waits up to ConcurrencyWait (CONCURRENCY_WAIT, default 0 =
+	// reject immediately) before getting a 503.
+	MaxConcurrentRequests int
+	ConcurrencyWait       time.Duration
+
+	// MaxBodyBytes caps request bodies, after decompression for gzip-encoded
+	// ones (MAX_BODY_BYTES, default 10 MiB). MaxImportBytes is the cap for
+	// POST /api/users/import.csv instead (MAX_IMPORT_BYTES, default 1 GiB).
+	MaxBodyBytes   int64
+	MaxImportBytes int64
+
+	// StaleIfError is how old a cached read may be and still be served,
+	// with "Warning: 110", when the database is unreachable
+	// (STALE_IF_ERROR, default 5m, 0 = never serve stale).
+	StaleIfError time.Duration
+
+	// UserIDType is how users are identified in {id} paths (USER_ID_TYPE):
+	// "integer", their numeric ID (the default), or "uuid", their
+	// database-generated UUID, which doesn't reveal how many users exist.
+	UserIDType string
+
+	// TrailingSlash decides how paths like /api/users/ are handled
+	// (TRAILING_SLASH): "match" routes them to the canonical route (the
+	// default), "redirect" sends a 308 to it, "strict" treats them as
+	// distinct paths.
+	TrailingSlash string
+
+	// RequestTimeout is how long a request may run when the client doesn't
+	// send X-Request-Timeout (REQUEST_TIMEOUT, default 0 = no limit).
+	// MaxRequestTimeout caps both it and client-requested budgets
+	// (MAX_REQUEST_TIMEOUT, default 30s, 0 = no cap).
+	RequestTimeout    time.Duration
+	MaxRequestTimeout time.Duration
+
+	// RouteTimeouts overrides both for specific routes, keyed by
+	// "METHOD /path/template" (ROUTE_TIMEOUTS, e.g.
+	// "POST /api/users/batch:2m"). A route's timeout is its default and its
+	// cap; 0 runs the route without a deadline.
+	RouteTimeouts map[string]time.Duration
+
+	// SlowRequestThreshold is how long a request may take before it is
+	// logged as slow (SLOW_REQUEST_THRESHOLD, default 1s, 0 = off).
+	SlowRequestThreshold time.Duration
+
+	// ReadinessCacheTTL is how long /readyz reuses the result of its last
+	// database ping (READINESS_CACHE_TTL, default 2s, 0 = ping every time).
+	ReadinessCacheTTL time.Duration
+
+	// PrettyJSON indents every successful JSON response, as if each request
+	// carried ?pretty=true (PRETTY_JSON, default false). Meant for
+	// development.
+	PrettyJSON bool
+	// ResponseEnvelope picks the shape of user reads for clients that don't
+	// ask with X-Response-Envelope or an Accept profile: true wraps them in
+	// {"data": ...}, false sends them bare (RESPONSE_ENVELOPE, unset = each
+	// endpoint's usual shape: lists enveloped, single users and
+	// /api/users/recent bare).
+	ResponseEnvelope *bool
+
+	// SentryDSN enables reporting panics to Sentry (SENTRY_DSN, unset =
+	// off). SentryReport5xx also reports every 5xx response
+	// (SENTRY_REPORT_5XX, default false).
+	SentryDSN       *sentryDSN
+	SentryReport5xx bool
+}
+
+var config *Config
+
+// LoadConfig reads the configuration from the environment, applying defaults
+// for anything unset, and validates the result.
+func LoadConfig() (*Config, error) {
+	cfg := &Config{
+		Port:         envString("PORT", "8080"),
+		ListenSocket: os.Getenv("LISTEN_SOCKET"),
+	}
+
+	var err error
+	if err = cfg.LogLevel.UnmarshalText([]byte(envString("LOG_LEVEL", "info"))); err != nil {
+		return nil, fmt.Errorf("LOG_LEVEL must be one of debug, info, warn or error: %v", err)
+	}
+	if cfg.DefaultPageSize, err = envInt("DEFAULT_PAGE_SIZE", 20); err != nil {
+		return nil, err
+	}
+	if cfg.MaxPageSize, err = envInt("MAX_PAGE_SIZE", 100); err != nil {
+		return nil, err
+	}
+
+	if cfg.DefaultPageSize <= 0 {
+		return nil, fmt.Errorf("DEFAULT_PAGE_SIZE must be positive, got %d", cfg.DefaultPageSize)
+	}
+	if cfg.MaxPageSize <= 0 {
+		return nil, fmt.Errorf("MAX_PAGE_SIZE must be positive, got %d", cfg.MaxPageSize)
+	}
+	if cfg.MaxPageSize < cfg.DefaultPageSize {
+		return nil, fmt.Errorf("MAX_PAGE_SIZE (%d) must be >= DEFAULT_PAGE_SIZE (%d)", cfg.MaxPageSize, cfg.DefaultPageSize)
+	}
+	cfg.DefaultSort = envString("DEFAULT_SORT", "id")
+	if _, err := parseSort(cfg.DefaultSort, ""); err != nil {
+		return nil, fmt.Errorf("DEFAULT_SORT: %v", err)
+	}
+	if cfg.Timezone, err = time.LoadLocation(envString("DEFAULT_TIMEZONE", "UTC")); err != nil {
+		return nil, fmt.Errorf("DEFAULT_TIMEZONE: %v", err)
+	}
+
+	if users := os.Getenv("BASIC_AUTH_USERS"); users != "" {
+		if cfg.BasicAuthUsers, err = parseBasicAuthUsers(users); err != nil {
+			return nil, err
+		}
+	}
+
+	defaultAuthMode := authModeNone
+	if len(cfg.BasicAuthUsers) > 0 {
+		defaultAuthMode = authModeBasic
+	}
+	cfg.AuthMode = envString("AUTH_MODE", defaultAuthMode)
+	switch cfg.AuthMode {
+	case authModeNone:
+	case authModeBasic:
+		if len(cfg.BasicAuthUsers) == 0 {
+			return nil, fmt.Errorf("AUTH_MODE=basic requires BASIC_AUTH_USERS")
+		}
+	default:
+		return nil, fmt.Errorf("AUTH_MODE must be one of %q or %q, got %q", authModeNone, authModeBasic, cfg.AuthMode)
+	}
+
+	cfg.CORSAllowedOrigins = envList("CORS_ALLOWED_ORIGINS", nil)
+	cfg.CORSExposedHeaders = envList("CORS_EXPOSED_HEADERS", []string{"ETag", "Location", "X-Total-Count", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"})
+	if cfg.CORSAllowCredentials, err = envBool("CORS_ALLOW_CREDENTIALS", false); err != nil {
+		return nil, err
+	}
+	if cfg.CORSMaxAge, err = envInt("CORS_MAX_AGE", 600); err != nil {
+		return nil, err
+	}
+	if cfg.CORSMaxAge < 0 {
+		return nil, fmt.Errorf("CORS_MAX_AGE must not be negative, got %d", cfg.CORSMaxAge)
+	}
+	if cfg.CORSAllowCredentials && slices.Contains(cfg.CORSAllowedOrigins, "*") {
+		return nil, fmt.Errorf("CORS_ALLOW_CREDENTIALS cannot be combined with a wildcard CORS_ALLOWED_ORIGINS")
+	}
+
+	if cfg.TrustedProxies, err = parseTrustedProxies(envList("TRUSTED_PROXIES", nil)); err != nil {
+		return nil, err
+	}
+
+	cfg.AdminUsers = envList("ADMIN_USERS", nil)
+
+	if cfg.DBMaxOpenConns, err = envInt("DB_MAX_OPEN_CONNS", 25); err != nil {
+		return nil, err
+	}
+	if cfg.DBMaxIdleConns, err = envInt("DB_MAX_IDLE_CONNS", 5); err != nil {
+		return nil, err
+	}
+	if cfg.DBConnMaxLifetime, err = envDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.DBConnMaxIdleTime, err = envDuration("DB_CONN_MAX_IDLE_TIME", time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.DBMaxOpenConns <= 0 {
+		return nil, fmt.Errorf("DB_MAX_OPEN_CONNS must be positive, got %d", cfg.DBMaxOpenConns)
+	}
+	if cfg.DBMaxIdleConns < 0 || cfg.DBMaxIdleConns > cfg.DBMaxOpenConns {
+		return nil, fmt.Errorf("DB_MAX_IDLE_CONNS must be between 0 and DB_MAX_OPEN_CONNS (%d), got %d", cfg.DBMaxOpenConns, cfg.DBMaxIdleConns)
+	}
+	if cfg.DBAcquireTimeout, err = envDuration("DB_ACQUIRE_TIMEOUT", 2*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.DBAcquireTimeout < 0 {
+		return nil, fmt.Errorf("DB_ACQUIRE_TIMEOUT must not be negative, got %s", cfg.DBAcquireTimeout)
+	}
+	if cfg.DBWarmupConns, err = envInt("DB_WARMUP_CONNS", 0); err != nil {
+		return nil, err
+	}
+	if cfg.DBWarmupConns < 0 || cfg.DBWarmupConns > cfg.DBMaxIdleConns {
+		return nil, fmt.Errorf("DB_WARMUP_CONNS must be between 0 and DB_MAX_IDLE_CONNS (%d), got %d", cfg.DBMaxIdleConns, cfg.DBWarmupConns)
+	}
+
+	if cfg.StreamMaxLifetime, err = envDuration("STREAM_MAX_LIFETIME", 30*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.StreamMaxLifetime == 0 {
+		return nil, fmt.Errorf("STREAM_MAX_LIFETIME must be positive")
+	}
+
+	if v := os.Getenv("RATE_LIMIT"); v != "" {
+		limit, err := parseRateLimit(v)
+		if err != nil {
+			return nil, fmt.Errorf("RATE_LIMIT: %v", err)
+		}
+		cfg.RateLimitDefault = &limit
+	}
+	if cfg.RateLimits, err = parseRouteRateLimits(os.Getenv("RATE_LIMITS")); err != nil {
+		return nil, err
+	}
+	if cfg.RateLimitMode, err = loadRateLimitMode(); err != nil {
+		return nil, err
+	}
+
+	disposable, ok, err := loadDomainList("DISPOSABLE_EMAIL_DOMAINS", "DISPOSABLE_EMAIL_DOMAINS_FILE")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		disposable = defaultDisposableDomains
+	}
+	cfg.DisposableEmailDomains = newDomainSet(disposable)
+
+	allowed, ok, err := loadDomainList("ALLOWED_EMAIL_DOMAINS", "ALLOWED_EMAIL_DOMAINS_FILE")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if len(allowed) == 0 {
+			return nil, fmt.Errorf("ALLOWED_EMAIL_DOMAINS is set but lists no domains")
+		}
+		cfg.AllowedEmailDomains = newDomainSet(allowed)
+	}
+	if cfg.BlockedEmailDomains, err = loadBlockedDomains(); err != nil {
+		return nil, err
+	}
+	if cfg.EmailChangeTokenTTL, err = envDuration("EMAIL_CHANGE_TOKEN_TTL", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.EmailChangeTokenTTL == 0 {
+		return nil, fmt.Errorf("EMAIL_CHANGE_TOKEN_TTL must be positive")
+	}
+	if cfg.BroadcastRate, err = envInt("BROADCAST_RATE", 10); err != nil {
+		return nil, err
+	}
+	if cfg.BroadcastRate <= 0 || cfg.BroadcastRate > 1000 {
+		return nil, fmt.Errorf("BROADCAST_RATE must be between 1 and 1000, got %d", cfg.BroadcastRate)
+	}
+
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		smtpCfg := smtpConfig{
+			Host:     host,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		}
+		if smtpCfg.Port, err = envInt("SMTP_PORT", 587); err != nil {
+			return nil, err
+		}
+		if smtpCfg.Port <= 0 || smtpCfg.Port > 65535 {
+			return nil, fmt.Errorf("SMTP_PORT must be between 1 and 65535, got %d", smtpCfg.Port)
+		}
+		if smtpCfg.From == "" {
+			return nil, fmt.Errorf("SMTP_HOST requires SMTP_FROM")
+		}
+		cfg.SMTP = &smtpCfg
+	}
+
+	if os.Getenv("BACKUP_BUCKET") != "" {
+		backupCfg := backupConfig{Prefix: envString("BACKUP_PREFIX", "backups/")}
+		if backupCfg.s3Config, err = loadS3Config("BACKUP_"); err != nil {
+			return nil, err
+		}
+		if backupCfg.Timeout, err = envDuration("BACKUP_TIMEOUT", time.Hour); err != nil {
+			return nil, err
+		}
+		if backupCfg.Timeout <= 0 {
+			return nil, fmt.Errorf("BACKUP_TIMEOUT must be positive, got %s", backupCfg.Timeout)
+		}
+		cfg.Backup = &backupCfg
+	}
+
+	cfg.Storage = storageConfig{
+		Backend:   envString("STORAGE_BACKEND", storageLocal),
+		LocalDir:  envString("STORAGE_LOCAL_DIR", "./data/blobs"),
+		PublicURL: os.Getenv("STORAGE_PUBLIC_URL"),
+	}
+	switch cfg.Storage.Backend {
+	case storageLocal:
+	case storageS3:
+		if cfg.Storage.S3, err = loadS3Config("STORAGE_S3_"); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("STORAGE_BACKEND must be %q or %q, got %q", storageLocal, storageS3, cfg.Storage.Backend)
+	}
+
+	cfg.RequestIDHeader = http.CanonicalHeaderKey(strings.TrimSpace(envString("REQUEST_ID_HEADER", "X-Request-ID")))
+	if cfg.RequestIDHeader == "" || strings.ContainsAny(cfg.RequestIDHeader, " \t:") {
+		return nil, fmt.Errorf("REQUEST_ID_HEADER must be a header name, got %q", cfg.RequestIDHeader)
+	}
+
+	if cfg.MaxHeaderBytes, err = envInt("MAX_HEADER_BYTES", 1<<20); err != nil {
+		return nil, err
+	}
+	if cfg.MaxHeaderBytes <= 0 {
+		return nil, fmt.Errorf("MAX_HEADER_BYTES must be positive, got %d", cfg.MaxHeaderBytes)
+	}
+	if cfg.ReadHeaderTimeout, err = envDuration("READ_HEADER_TIMEOUT", 5*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.ReadHeaderTimeout == 0 {
+		return nil, fmt.Errorf("READ_HEADER_TIMEOUT must be positive")
+	}
+
+	if cfg.HousekeepingInterval, err = envDuration("HOUSEKEEPING_INTERVAL", time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.HousekeepingInterval == 0 {
+		return nil, fmt.Errorf("HOUSEKEEPING_INTERVAL must be positive")
+	}
+	if cfg.BatchKeyTTL, err = envDuration("BATCH_KEY_TTL", 24*time.Hour); err != nil {
+		return nil, err
+	}
+
+	if cfg.DisabledEndpoints, err = parseEndpointList("DISABLED_ENDPOINTS"); err != nil {
+		return nil, err
+	}
+
+	if cfg.AdminShutdownEnabled, err = envBool("ADMIN_SHUTDOWN_ENABLED", false); err != nil {
+		return nil, err
+	}
+	if cfg.AutoMigrate, err = envBool("AUTO_MIGRATE", true); err != nil {
+		return nil, err
+	}
+	if cfg.CheckSchemaDrift, err = envBool("CHECK_SCHEMA_DRIFT", false); err != nil {
+		return nil, err
+	}
+	if cfg.StrictSchema, err = envBool("STRICT_SCHEMA", false); err != nil {
+		return nil, err
+	}
+	if cfg.Features, err = loadFeatures(); err != nil {
+		return nil, err
+	}
+
+	switch maxAge := envString("CACHE_MAX_AGE", "30"); maxAge {
+	case "no-cache":
+		cfg.ReadCacheControl = "no-cache"
+	default:
+		seconds, err := strconv.Atoi(maxAge)
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("CACHE_MAX_AGE must be a number of seconds or no-cache, got %q", maxAge)
+		}
+		cfg.ReadCacheControl = fmt.Sprintf("private, max-age=%d", seconds)
+	}
+
+	if cfg.MaxConcurrentRequests, err = envInt("MAX_CONCURRENT_REQUESTS", 0); err != nil {
+		return nil, err
+	}
+	if cfg.MaxConcurrentRequests < 0 {
+		return nil, fmt.Errorf("MAX_CONCURRENT_REQUESTS must not be negative, got %d", cfg.MaxConcurrentRequests)
+	}
+	if cfg.ConcurrencyWait, err = envDuration("CONCURRENCY_WAIT", 0); err != nil {
+		return nil, err
+	}
+	maxBody, err := envInt("MAX_BODY_BYTES", 10<<20)
+	if err != nil {
+		return nil, err
+	}
+	if maxBody <= 0 {
+		return nil, fmt.Errorf("MAX_BODY_BYTES must be positive, got %d", maxBody)
+	}
+	cfg.MaxBodyBytes = int64(maxBody)
+	maxImport, err := envInt("MAX_IMPORT_BYTES", 1<<30)
+	if err != nil {
+		return nil, err
+	}
+	if maxImport <= 0 {
+		return nil, fmt.Errorf("MAX_IMPORT_BYTES must be positive, got %d", maxImport)
+	}
+	cfg.MaxImportBytes = int64(maxImport)
+	if cfg.StaleIfError, err = envDuration("STALE_IF_ERROR", 5*time.Minute); err != nil {
+		return nil, err
+	}
+
+	if cfg.RequestTimeout, err = envDuration("REQUEST_TIMEOUT", 0); err != nil {
+		return nil, err
+	}
+	if cfg.MaxRequestTimeout, err = envDuration("MAX_REQUEST_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.RouteTimeouts, err = parseRouteTimeouts(os.Getenv("ROUTE_TIMEOUTS")); err != nil {
+		return nil, err
+	}
+
+	if cfg.SlowRequestThreshold, err = envDuration("SLOW_REQUEST_THRESHOLD", time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.ReadinessCacheTTL, err = envDuration("READINESS_CACHE_TTL", 2*time.Second); err != nil {
+		return nil, err
+	}
+
+	if cfg.PrettyJSON, err = envBool("PRETTY_JSON", false); err != nil {
+		return nil, err
+	}
+	if os.Getenv("RESPONSE_ENVELOPE") != "" {
+		envelope, err := envBool("RESPONSE_ENVELOPE", false)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ResponseEnvelope = &envelope
+	}
+
+	if dsn := os.Getenv("SENTRY_DSN"); dsn != "" {
+		if cfg.SentryDSN, err = parseSentryDSN(dsn); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.SentryReport5xx, err = envBool("SENTRY_REPORT_5XX", false); err != nil {
+		return nil, err
+	}
+
+	cfg.UserIDType = envString("USER_ID_TYPE", userIDInteger)
+	if cfg.UserIDType != userIDInteger && cfg.UserIDType != userIDUUID {
+		return nil, fmt.Errorf("USER_ID_TYPE must be %q or %q, got %q", userIDInteger, userIDUUID, cfg.UserIDType)
+	}
+
+	cfg.TrailingSlash = envString("TRAILING_SLASH", trailingSlashMatch)
+	switch cfg.TrailingSlash {
+	case trailingSlashMatch, trailingSlashRedirect, trailingSlashStrict:
+	default:
+		return nil, fmt.Errorf("TRAILING_SLASH must be one of %q, %q or %q, got %q", trailingSlashMatch, trailingSlashRedirect, trailingSlashStrict, cfg.TrailingSlash)
+	}
+
+	return cfg, nil
+}
+
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer, got %q", key, v)
+	}
+	return n, nil
+}
+
+func envBool(key string, def bool) (bool, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s must be a boolean, got %q", key, v)
+	}
+	return b, nil
+}
+
+// envList reads a comma-separated list, dropping empty entries.
+func envList(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative duration like 30s or 5m, got %q", key, v)
+	}
+	return d, nil
+}
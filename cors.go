@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const corsAllowedMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+
+// corsAllowedHeaders are the request headers the API reads that browsers
+// only send cross-origin after a preflight. The request ID header is
+// configurable, so corsMiddleware adds it from the config.
+var corsAllowedHeaders = []string{"Authorization", "Content-Encoding", "Content-Type", "If-Match", "If-Unmodified-Since", "Prefer", requestTimeoutHeader, "X-Response-Envelope"}
+
+// corsMiddleware adds CORS headers for allowed origins and answers preflight
+// requests directly. It wraps the whole router rather than being registered
+// with r.Use so that OPTIONS requests, which match no route, still reach it.
+func corsMiddleware(cfg *Config) func(http.Handler) http.Handler {
+	allowAny := false
+	allowed := make(map[string]bool, len(cfg.CORSAllowedOrigins))
+	for _, o := range cfg.CORSAllowedOrigins {
+		if o == "*" {
+			allowAny = true
+		}
+		allowed[o] = true
+	}
+	maxAge := strconv.Itoa(cfg.CORSMaxAge)
+	exposed := strings.Join(cfg.CORSExposedHeaders, ", ")
+	allowedHeaders := strings.Join(append(slices.Clone(corsAllowedHeaders), cfg.RequestIDHeader), ", ")
+
+	return func(next http.Handler) http.Handler {
+		if len(allowed) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || !(allowAny || allowed[origin]) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if allowAny && !cfg.CORSAllowCredentials {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				// Credentialed responses must name the origin exactly.
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.CORSAllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if exposed != "" {
+				h.Set("Access-Control-Expose-Headers", exposed)
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Set("Access-Control-Allow-Methods", corsAllowedMethods)
+				h.Set("Access-Control-Allow-Headers", allowedHeaders)
+				h.Set("Access-Control-Max-Age", maxAge)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
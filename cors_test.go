@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A preflight allows every request header the API reads, including the
+// configured request ID header.
+func TestCORSPreflightAllowedHeaders(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		requestID string
+	}{
+		{"default request ID header", nil, "X-Request-Id"},
+		{"custom request ID header", map[string]string{"REQUEST_ID_HEADER": "x-correlation-id"}, "X-Correlation-Id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{"CORS_ALLOWED_ORIGINS": "https://app.example"}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			cfg := testConfig(t, env)
+			h := corsMiddleware(cfg)(http.NotFoundHandler())
+
+			req := httptest.NewRequest(http.MethodOptions, "/api/users", nil)
+			req.Header.Set("Origin", "https://app.example")
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != http.StatusNoContent {
+				t.Fatalf("status = %d, want 204", rec.Code)
+			}
+
+			allowed := map[string]bool{}
+			for _, name := range strings.Split(rec.Header().Get("Access-Control-Allow-Headers"), ",") {
+				allowed[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+			}
+			for _, want := range []string{tt.requestID, "Prefer", requestTimeoutHeader, "If-Match", "Authorization"} {
+				if !allowed[want] {
+					t.Errorf("Access-Control-Allow-Headers lacks %s: %v", want, rec.Header().Get("Access-Control-Allow-Headers"))
+				}
+			}
+		})
+	}
+}
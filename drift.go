@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// typeAliases folds the names GORM generates for a column type and the
+// names Postgres reports back onto one spelling.
+var typeAliases = map[string]string{
+	"bigint":                   "int8",
+	"bigserial":                "int8",
+	"integer":                  "int4",
+	"serial":                   "int4",
+	"smallint":                 "int2",
+	"smallserial":              "int2",
+	"boolean":                  "bool",
+	"character varying":        "varchar",
+	"timestamp with time zone": "timestamptz",
+	"decimal":                  "numeric",
+}
+
+func normalizeColumnType(t string) string {
+	t = strings.ToLower(strings.TrimSpace(t))
+	if i := strings.IndexByte(t, '('); i >= 0 {
+		t = t[:i]
+	}
+	if alias, ok := typeAliases[t]; ok {
+		return alias
+	}
+	return t
+}
+
+// unmodeledColumns are columns created by SQL outside AutoMigrate, which
+// schemaDrift expects to find in the table but not in the model.
+var unmodeledColumns = map[string]bool{
+	"users." + searchVectorColumn: true,
+}
+
+// schemaDrift compares each of models against its table without changing
+// anything, and describes every difference: missing tables, missing or
+// unexpected columns, and columns whose type or length differs from what
+// AutoMigrate would create.
+func schemaDrift(models []any) ([]string, error) {
+	m := db.Migrator()
+	var drift []string
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		table := stmt.Schema.Table
+		if !m.HasTable(model) {
+			drift = append(drift, fmt.Sprintf("table %s is missing", table))
+			continue
+		}
+		columns, err := m.ColumnTypes(model)
+		if err != nil {
+			return nil, err
+		}
+		actual := make(map[string]gorm.ColumnType, len(columns))
+		for _, c := range columns {
+			actual[c.Name()] = c
+		}
+
+		expected := map[string]bool{}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" || field.IgnoreMigration {
+				continue
+			}
+			expected[field.DBName] = true
+			col, ok := actual[field.DBName]
+			if !ok {
+				drift = append(drift, fmt.Sprintf("%s.%s is missing", table, field.DBName))
+				continue
+			}
+			want := normalizeColumnType(db.Dialector.DataTypeOf(field))
+			if got := normalizeColumnType(col.DatabaseTypeName()); got != want {
+				drift = append(drift, fmt.Sprintf("%s.%s is %s, model expects %s", table, field.DBName, got, want))
+				continue
+			}
+			if length, ok := col.Length(); ok && field.Size > 0 && want == "varchar" && length != int64(field.Size) {
+				drift = append(drift, fmt.Sprintf("%s.%s has length %d, model expects %d", table, field.DBName, length, field.Size))
+			}
+		}
+		for name := range actual {
+			if !expected[name] && !unmodeledColumns[table+"."+name] {
+				drift = append(drift, fmt.Sprintf("%s.%s is not in the model", table, name))
+			}
+		}
+	}
+	return drift, nil
+}
+
+// checkSchemaDrift runs schemaDrift over migratedModels at startup, logging
+// each difference, or exiting if strict is set.
+func checkSchemaDrift(strict bool) {
+	drift, err := schemaDrift(migratedModels)
+	if err != nil {
+		log.Fatalf("❌ Schema drift check failed: %v", err)
+	}
+	if len(drift) == 0 {
+		fmt.Println("✅ Database schema matches the models")
+		return
+	}
+	if strict {
+		log.Fatalf("❌ Database schema has drifted from the models: %s", strings.Join(drift, "; "))
+	}
+	for _, d := range drift {
+		slog.Warn("schema drift", "detail", d)
+	}
+}
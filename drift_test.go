@@ -0,0 +1,85 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalizeColumnType(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"bigint", "int8"},
+		{"bigserial", "int8"},
+		{"INTEGER", "int4"},
+		{"varchar(100)", "varchar"},
+		{"character varying", "varchar"},
+		{"timestamp with time zone", "timestamptz"},
+		{"timestamptz", "timestamptz"},
+		{"decimal(10,2)", "numeric"},
+		{" boolean ", "bool"},
+		{"jsonb", "jsonb"},
+	}
+	for _, tt := range tests {
+		if got := normalizeColumnType(tt.in); got != tt.want {
+			t.Errorf("normalizeColumnType(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// driftProbe is a model whose table the test creates by hand, drifted from
+// what AutoMigrate would make of it.
+type driftProbe struct {
+	ID    uint   `gorm:"primaryKey"`
+	Name  string `gorm:"size:100"`
+	Email string `gorm:"size:254"`
+	Score int
+	Admin bool
+}
+
+func TestSchemaDrift(t *testing.T) {
+	testDB(t)
+
+	drift, err := schemaDrift(migratedModels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift) != 0 {
+		t.Fatalf("freshly migrated schema drifted: %v", drift)
+	}
+
+	drift, err = schemaDrift([]any{&driftProbe{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"table drift_probes is missing"}; !slices.Equal(drift, want) {
+		t.Fatalf("missing table: drift = %v, want %v", drift, want)
+	}
+
+	t.Cleanup(func() { db.Exec("DROP TABLE IF EXISTS drift_probes") })
+	err = db.Exec(`CREATE TABLE drift_probes (
+		id bigserial PRIMARY KEY,
+		name varchar(50),
+		email varchar(254),
+		score text,
+		legacy_flag boolean
+	)`).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drift, err = schemaDrift([]any{&driftProbe{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(drift)
+	want := []string{
+		"drift_probes.admin is missing",
+		"drift_probes.legacy_flag is not in the model",
+		"drift_probes.name has length 50, model expects 100",
+		"drift_probes.score is text, model expects int8",
+	}
+	if !slices.Equal(drift, want) {
+		t.Errorf("drift = %v, want %v", drift, want)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// normalizeEmail is the canonical form emails are stored and compared in.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// normalizedEmail is the response of POST /api/utils/normalize-email.
+type normalizedEmail struct {
+	Normalized string `json:"normalized"`
+	Valid      bool   `json:"valid"`
+	Code       string `json:"code,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// normalizeEmailUtil serves POST /api/utils/normalize-email. It runs an
+// email through the normalization and checks create applies, without
+// touching the database, so clients can validate forms the way the server
+// will. An email that fails the checks is still a 200, with valid false and
+// the first problem found; whether the email is taken is not checked.
+func normalizeEmailUtil(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	result := normalizedEmail{Normalized: normalizeEmail(body.Email), Valid: true}
+	if errs := newEmailErrors(result.Normalized); len(errs) > 0 {
+		result.Valid, result.Code, result.Reason = false, errs[0].Code, errs[0].Message
+	}
+	writeJSON(w, r, http.StatusOK, result)
+}
+
+// getUserByEmail looks a user up by exact email, normalized the same way
+// create does so lookups match regardless of the case the client sends.
+// Stored emails are compared ignoring case too, like the unique index
+// compares them, so rows saved before emails were normalized are found.
+func getUserByEmail(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	email := normalizeEmail(r.URL.Query().Get("email"))
+	if email == "" {
+		writeError(w, r, http.StatusBadRequest, "email query parameter is required")
+		return
+	}
+	if errs := emailErrors(email); len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, errs[0].Message)
+		return
+	}
+	loc, ok := responseZone(w, r)
+	if !ok {
+		return
+	}
+
+	var user User
+	err := db.WithContext(r.Context()).Where("LOWER(email) = LOWER(?)", email).First(&user).Error
+	if requestCanceled(w, r, err) {
+		return
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve user")
+		return
+	}
+
+	w.Header().Set("Cache-Control", config.ReadCacheControl)
+	w.Header().Set("ETag", userETag(user))
+	resp := newUserResponse(user, time.Now().In(loc))
+	writeEnveloped(w, r, http.StatusOK, dataEnvelope{resp}, resp, false)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"alice@example.com", "alice@example.com"},
+		{"  Alice@Example.COM\t", "alice@example.com"},
+		{"\n", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeEmail(tt.in); got != tt.want {
+			t.Errorf("normalizeEmail(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeEmailUtil(t *testing.T) {
+	domainPolicy(t, map[string]string{"BLOCKED_EMAIL_DOMAINS": "trash.example"})
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   normalizedEmail
+	}{
+		{"valid", `{"email": "alice@example.com"}`, http.StatusOK, normalizedEmail{Normalized: "alice@example.com", Valid: true}},
+		{"padded and mixed case", `{"email": "  Alice@Example.COM "}`, http.StatusOK, normalizedEmail{Normalized: "alice@example.com", Valid: true}},
+		{"invalid", `{"email": "Alice@"}`, http.StatusOK, normalizedEmail{Normalized: "alice@", Code: codeEmailInvalidFormat, Reason: "Invalid email format"}},
+		{"blank", `{"email": "  "}`, http.StatusOK, normalizedEmail{Code: codeEmailRequired, Reason: "Email is required"}},
+		{"missing", `{}`, http.StatusOK, normalizedEmail{Code: codeEmailRequired, Reason: "Email is required"}},
+		{"too long", `{"email": "` + strings.Repeat("a", maxEmailLength) + `@example.com"}`, http.StatusOK, normalizedEmail{
+			Normalized: strings.Repeat("a", maxEmailLength) + "@example.com", Code: codeEmailTooLong, Reason: "Email must be at most 254 characters",
+		}},
+		{"blocked domain", `{"email": "bob@Trash.example"}`, http.StatusOK, normalizedEmail{Normalized: "bob@trash.example", Code: codeEmailDomainBlocked}},
+		{"malformed body", `{"email": `, http.StatusBadRequest, normalizedEmail{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(normalizeEmailUtil, http.MethodPost, "/api/utils/normalize-email", nil, tt.body, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got normalizedEmail
+			decodeBody(t, rec, &got)
+			if tt.want.Code == codeEmailDomainBlocked {
+				// The reason names the domain; the code is what clients act on.
+				tt.want.Reason = got.Reason
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetUserByEmail(t *testing.T) {
+	testDB(t)
+	seedUser(t, "alice", "Alice", "alice@example.com")
+	// Saved before emails were normalized.
+	if err := db.Exec(`INSERT INTO users (username, name, email, created_at, updated_at) VALUES ('bob', 'Bob', 'Bob@Example.com', NOW(), NOW())`).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		email    string
+		status   int
+		username string
+	}{
+		{"alice@example.com", http.StatusOK, "alice"},
+		{"ALICE@Example.COM", http.StatusOK, "alice"},
+		{"bob@example.com", http.StatusOK, "bob"},
+		{"Bob@Example.com", http.StatusOK, "bob"},
+		{"carol@example.com", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			rec := serve(getUserByEmail, http.MethodGet, "/api/users/by-email?email="+url.QueryEscape(tt.email), nil, "", nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var user userResponse
+			decodeBody(t, rec, &user)
+			if user.Username != tt.username {
+				t.Errorf("username = %q, want %q", user.Username, tt.username)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// pendingEmailChange is an email change waiting for its token to be
+// confirmed. A user has at most one; requesting another replaces it. Only
+// a hash of the token is stored.
+type pendingEmailChange struct {
+	UserID    uint      `gorm:"primaryKey"`
+	NewEmail  string    `gorm:"size:254;not null"`
+	TokenHash string    `gorm:"size:64;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+// sendEmailChangeToken mails the confirmation token to the new address.
+func sendEmailChangeToken(ctx context.Context, sender EmailSender, to, token string, expires time.Time) error {
+	body := fmt.Sprintf("Use this token to confirm your new email address:\n\n%s\n\nIt expires at %s. If you didn't ask for this, ignore this email.",
+		token, expires.UTC().Format(time.RFC1123))
+	return sender.Send(ctx, to, "Confirm your new email address", body)
+}
+
+func hashEmailChangeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// requestEmailChange serves POST /api/users/{id}/email-change. It records
+// the new address as pending and sends it a single-use token; the user's
+// email is untouched until the token is confirmed.
+func requestEmailChange(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	id, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	email := normalizeEmail(body.Email)
+	if errs := newEmailErrors(email); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	var user User
+	if err := db.WithContext(r.Context()).First(&user, id).Error; err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		writeError(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+	if email == user.Email {
+		writeValidationErrors(w, r, []fieldError{{Field: "email", Code: codeEmailUnchanged, Message: "Email is unchanged"}})
+		return
+	}
+
+	raw := make([]byte, 32)
+	rand.Read(raw)
+	token := hex.EncodeToString(raw)
+	pending := pendingEmailChange{
+		UserID:    user.ID,
+		NewEmail:  email,
+		TokenHash: hashEmailChangeToken(token),
+		ExpiresAt: time.Now().Add(config.EmailChangeTokenTTL),
+	}
+	err := db.WithContext(r.Context()).Clauses(clause.OnConflict{UpdateAll: true}).Create(&pending).Error
+	if err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "Failed to start email change")
+		return
+	}
+
+	if err := sendEmailChangeToken(r.Context(), mailer, email, token, pending.ExpiresAt); err != nil {
+		log.Printf("❌ Failed to send email change token to user %d: %v", user.ID, err)
+		writeError(w, r, http.StatusBadGateway, "Failed to send confirmation email")
+		return
+	}
+
+	writeJSON(w, r, http.StatusAccepted, map[string]any{
+		"status":     "pending",
+		"email":      email,
+		"expires_at": pending.ExpiresAt.UTC(),
+	})
+}
+
+// errInvalidEmailChangeToken covers a wrong, used or expired token alike,
+// so the response doesn't reveal which.
+var errInvalidEmailChangeToken = errors.New("invalid or expired token")
+
+// confirmEmailChange serves POST /api/users/{id}/email-change/confirm. A
+// valid token commits the pending email and is consumed in the same
+// transaction, so it can't be replayed.
+func confirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	id, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	var user User
+	err := auditedWrite(r, auditUpdated, func(tx *gorm.DB) (uint, error) {
+		var pending pendingEmailChange
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&pending, "user_id = ?", id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, errInvalidEmailChangeToken
+		}
+		if err != nil {
+			return 0, err
+		}
+		if subtle.ConstantTimeCompare([]byte(hashEmailChangeToken(body.Token)), []byte(pending.TokenHash)) != 1 {
+			return 0, errInvalidEmailChangeToken
+		}
+		if err := tx.Delete(&pending).Error; err != nil {
+			return 0, err
+		}
+		if time.Now().After(pending.ExpiresAt) {
+			// Deleting the expired change is still worth committing.
+			return 0, nil
+		}
+
+		if err := tx.First(&user, id).Error; err != nil {
+			return 0, err
+		}
+		user.Email = pending.NewEmail
+		return user.ID, tx.Save(&user).Error
+	})
+	if err == nil && user.ID == 0 {
+		err = errInvalidEmailChangeToken
+	}
+	if err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, errInvalidEmailChangeToken):
+			writeError(w, r, http.StatusBadRequest, "Invalid or expired token")
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			writeError(w, r, http.StatusNotFound, "User not found")
+		default:
+			writeSaveError(w, r, err, "Failed to change email")
+		}
+		return
+	}
+	hub.publish(userEvent{Type: eventUserUpdated, User: user})
+
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, r, http.StatusOK, user)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sentEmail is a message captured by mockSender.
+type sentEmail struct {
+	to, subject, body string
+}
+
+// mockSender records what it is asked to send, failing with err if set.
+type mockSender struct {
+	mu   sync.Mutex
+	sent []sentEmail
+	err  error
+}
+
+func (m *mockSender) Send(ctx context.Context, to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, sentEmail{to, subject, body})
+	return nil
+}
+
+// useMailer installs sender as mailer for the rest of the test.
+func useMailer(t *testing.T, sender EmailSender) {
+	t.Helper()
+	prev := mailer
+	mailer = sender
+	t.Cleanup(func() { mailer = prev })
+}
+
+var emailChangeTokenPattern = regexp.MustCompile(`\b[0-9a-f]{64}\b`)
+
+// requestedToken asks for user's email to change to email and returns the
+// token that was mailed for it.
+func requestedToken(t *testing.T, sender *mockSender, vars map[string]string, email string) string {
+	t.Helper()
+	rec := serve(requestEmailChange, http.MethodPost, "/api/users/"+vars["id"]+"/email-change", vars, `{"email": "`+email+`"}`, nil)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("request: status = %d, want 202: %s", rec.Code, rec.Body)
+	}
+	last := sender.sent[len(sender.sent)-1]
+	if last.to != email {
+		t.Fatalf("token sent to %s, want %s", last.to, email)
+	}
+	token := emailChangeTokenPattern.FindString(last.body)
+	if token == "" {
+		t.Fatalf("no token in %q", last.body)
+	}
+	return token
+}
+
+func confirmToken(vars map[string]string, token string) int {
+	return serve(confirmEmailChange, http.MethodPost, "/api/users/"+vars["id"]+"/email-change/confirm", vars, `{"token": "`+token+`"}`, nil).Code
+}
+
+// storedEmail returns the email currently stored for user id.
+func storedEmail(t *testing.T, id uint) string {
+	t.Helper()
+	var user User
+	if err := db.First(&user, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user.Email
+}
+
+func TestRequestEmailChangeValidation(t *testing.T) {
+	testConfig(t, nil)
+	prev := db
+	db = dryRunDB(t)
+	t.Cleanup(func() { db = prev })
+	vars := map[string]string{"id": "1"}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"empty body", "", http.StatusBadRequest, ""},
+		{"no email", `{}`, http.StatusUnprocessableEntity, codeEmailRequired},
+		{"blank email", `{"email": "   "}`, http.StatusUnprocessableEntity, codeEmailRequired},
+		{"malformed email", `{"email": "alice@"}`, http.StatusUnprocessableEntity, codeEmailInvalidFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(requestEmailChange, http.MethodPost, "/api/users/1/email-change", vars, tt.body, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.code != "" {
+				var apiErr apiError
+				decodeBody(t, rec, &apiErr)
+				if codes := errorCodes(apiErr.Errors); len(codes) != 1 || codes[0] != tt.code {
+					t.Errorf("codes = %v, want [%s]", codes, tt.code)
+				}
+			}
+		})
+	}
+}
+
+func TestEmailChange(t *testing.T) {
+	testDB(t)
+	sender := &mockSender{}
+	useMailer(t, sender)
+	user := seedUser(t, "alice", "Alice", "alice@example.com")
+	vars := map[string]string{"id": strconv.FormatUint(uint64(user.ID), 10)}
+
+	token := requestedToken(t, sender, vars, "alice@example.org")
+	if got := storedEmail(t, user.ID); got != "alice@example.com" {
+		t.Fatalf("email changed to %s before confirmation", got)
+	}
+	if code := confirmToken(vars, token); code != http.StatusOK {
+		t.Fatalf("confirm: status = %d, want 200", code)
+	}
+	if got := storedEmail(t, user.ID); got != "alice@example.org" {
+		t.Fatalf("email = %s after confirmation, want alice@example.org", got)
+	}
+	if code := confirmToken(vars, token); code != http.StatusBadRequest {
+		t.Errorf("reused token: status = %d, want 400", code)
+	}
+}
+
+func TestEmailChangeRejectedTokens(t *testing.T) {
+	testDB(t)
+	sender := &mockSender{}
+	useMailer(t, sender)
+	user := seedUser(t, "alice", "Alice", "alice@example.com")
+	vars := map[string]string{"id": strconv.FormatUint(uint64(user.ID), 10)}
+
+	tests := []struct {
+		name    string
+		prepare func(token string) string
+	}{
+		{"wrong token", func(string) string { return "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef" }},
+		{"empty token", func(string) string { return "" }},
+		{"expired token", func(token string) string {
+			if err := db.Model(&pendingEmailChange{}).Where("user_id = ?", user.ID).Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+				t.Fatal(err)
+			}
+			return token
+		}},
+		{"replaced by a newer request", func(token string) string {
+			requestedToken(t, sender, vars, "alice@example.net")
+			return token
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := tt.prepare(requestedToken(t, sender, vars, "alice@example.org"))
+			if code := confirmToken(vars, token); code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", code)
+			}
+			if got := storedEmail(t, user.ID); got != "alice@example.com" {
+				t.Errorf("email changed to %s", got)
+			}
+		})
+	}
+}
+
+func TestEmailChangeSendFailure(t *testing.T) {
+	testDB(t)
+	useMailer(t, &mockSender{err: errors.New("relay down")})
+	user := seedUser(t, "alice", "Alice", "alice@example.com")
+	vars := map[string]string{"id": strconv.FormatUint(uint64(user.ID), 10)}
+
+	rec := serve(requestEmailChange, http.MethodPost, "/api/users/"+vars["id"]+"/email-change", vars, `{"email": "alice@example.org"}`, nil)
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", rec.Code)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// defaultDisposableDomains is used when no disposable domain list is
+// configured.
+var defaultDisposableDomains = []string{
+	"10minutemail.com",
+	"guerrillamail.com",
+	"mailinator.com",
+	"temp-mail.org",
+	"yopmail.com",
+}
+
+// domainSet is a set of lowercased email domains.
+type domainSet map[string]bool
+
+func newDomainSet(domains []string) domainSet {
+	set := make(domainSet, len(domains))
+	for _, d := range domains {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			set[d] = true
+		}
+	}
+	return set
+}
+
+// contains reports whether the domain of email is in the set.
+func (s domainSet) contains(email string) bool {
+	return s[emailDomain(email)]
+}
+
+// emailDomain returns the lowercased part of email after the last @.
+func emailDomain(email string) string {
+	return strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+}
+
+// emailDomainErrors applies the domain policy to a well-formed email. When
+// ALLOWED_EMAIL_DOMAINS is set only those domains are accepted; after that,
+// domains on the blocklist are rejected.
+func emailDomainErrors(email string) []fieldError {
+	if config.AllowedEmailDomains != nil && !config.AllowedEmailDomains.contains(email) {
+		return []fieldError{{Field: "email", Code: codeEmailDomainNotAllowed, Message: fmt.Sprintf("Email domain '%s' is not allowed", emailDomain(email))}}
+	}
+	if blocked := blockedEmailDomains.Load(); blocked != nil && blocked.contains(email) {
+		return []fieldError{{Field: "email", Code: codeEmailDomainBlocked, Message: fmt.Sprintf("Email domain '%s' is blocked", emailDomain(email))}}
+	}
+	return nil
+}
+
+// blockedEmailDomains is the current blocklist. It is swapped atomically on
+// SIGHUP so large lists kept in a file can be updated without a restart.
+var blockedEmailDomains atomic.Pointer[domainSet]
+
+// loadBlockedDomains reads BLOCKED_EMAIL_DOMAINS and
+// BLOCKED_EMAIL_DOMAINS_FILE. Both unset means an empty blocklist.
+func loadBlockedDomains() (domainSet, error) {
+	domains, _, err := loadDomainList("BLOCKED_EMAIL_DOMAINS", "BLOCKED_EMAIL_DOMAINS_FILE")
+	if err != nil {
+		return nil, err
+	}
+	return newDomainSet(domains), nil
+}
+
+// reloadBlockedDomainsOnHangup re-reads the blocklist whenever the process
+// gets SIGHUP. A list that fails to load is logged and the previous one
+// stays in effect.
+func reloadBlockedDomainsOnHangup() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		blocked, err := loadBlockedDomains()
+		if err != nil {
+			log.Printf("❌ Keeping previous email blocklist: %v", err)
+			continue
+		}
+		blockedEmailDomains.Store(&blocked)
+		log.Printf("🔧 Reloaded email blocklist: %d domains", len(blocked))
+	}
+}
+
+// loadDomainList reads domains from the comma-separated listKey variable
+// and, if fileKey names a file, from that file too (one domain per line,
+// # starts a comment). ok is false when neither variable is set.
+func loadDomainList(listKey, fileKey string) (domains []string, ok bool, err error) {
+	domains = envList(listKey, nil)
+	ok = os.Getenv(listKey) != ""
+
+	if path := os.Getenv(fileKey); path != "" {
+		ok = true
+		fromFile, err := readDomainFile(path)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %v", fileKey, err)
+		}
+		domains = append(domains, fromFile...)
+	}
+	return domains, ok, nil
+}
+
+func readDomainFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var domains []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			domains = append(domains, line)
+		}
+	}
+	return domains, scanner.Err()
+}
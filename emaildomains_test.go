@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// domainPolicy loads the configuration with env and installs its blocklist
+// the way main does, for the rest of the test.
+func domainPolicy(t *testing.T, env map[string]string) {
+	t.Helper()
+	cfg := testConfig(t, env)
+	prev := blockedEmailDomains.Load()
+	blockedEmailDomains.Store(&cfg.BlockedEmailDomains)
+	t.Cleanup(func() { blockedEmailDomains.Store(prev) })
+}
+
+// checkEmail runs email through both the create and the update checks,
+// which must agree.
+func checkEmail(t *testing.T, email string) []string {
+	t.Helper()
+	created := errorCodes(validateNewUser(User{Username: "alice", Name: "Alice", Email: email}))
+	updated := errorCodes(validateUserUpdate(User{Email: email}))
+	if !slices.Equal(created, updated) {
+		t.Fatalf("create gave %v but update gave %v", created, updated)
+	}
+	return created
+}
+
+func TestAllowedEmailDomains(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed string
+		email   string
+		want    []string
+	}{
+		{"unset allows any domain", "", "alice@anywhere.io", nil},
+		{"allowed domain", "corp.example, example.org", "alice@corp.example", nil},
+		{"second allowed domain", "corp.example, example.org", "alice@example.org", nil},
+		{"case-insensitive", "Corp.Example", "alice@CORP.example", nil},
+		{"disallowed domain", "corp.example", "alice@gmail.com", []string{codeEmailDomainNotAllowed}},
+		{"subdomains are distinct", "corp.example", "alice@eu.corp.example", []string{codeEmailDomainNotAllowed}},
+		{"format is checked first", "corp.example", "not-an-email", []string{codeEmailInvalidFormat}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domainPolicy(t, map[string]string{"ALLOWED_EMAIL_DOMAINS": tt.allowed})
+			if got := checkEmail(t, tt.email); !slices.Equal(got, tt.want) {
+				t.Errorf("codes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockedEmailDomains(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "blocked.txt")
+	if err := os.WriteFile(file, []byte("# disposable providers\nTrash.example\n\nspam.example  # added after the 2024 wave\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		env   map[string]string
+		email string
+		want  []string
+	}{
+		{"unset blocks nothing", nil, "alice@trash.example", nil},
+		{"listed domain", map[string]string{"BLOCKED_EMAIL_DOMAINS": "trash.example"}, "alice@Trash.Example", []string{codeEmailDomainBlocked}},
+		{"unlisted domain", map[string]string{"BLOCKED_EMAIL_DOMAINS": "trash.example"}, "alice@example.org", nil},
+		{"domain from the file", map[string]string{"BLOCKED_EMAIL_DOMAINS_FILE": file}, "alice@trash.example", []string{codeEmailDomainBlocked}},
+		{"file line with a comment", map[string]string{"BLOCKED_EMAIL_DOMAINS_FILE": file}, "alice@spam.example", []string{codeEmailDomainBlocked}},
+		{"list and file combine", map[string]string{"BLOCKED_EMAIL_DOMAINS": "junk.example", "BLOCKED_EMAIL_DOMAINS_FILE": file}, "alice@junk.example", []string{codeEmailDomainBlocked}},
+		{"allowlist runs first", map[string]string{"ALLOWED_EMAIL_DOMAINS": "corp.example", "BLOCKED_EMAIL_DOMAINS": "trash.example"}, "alice@trash.example", []string{codeEmailDomainNotAllowed}},
+		{"format is checked first", map[string]string{"BLOCKED_EMAIL_DOMAINS": "trash.example"}, "alice@trash", []string{codeEmailInvalidFormat}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domainPolicy(t, tt.env)
+			if got := checkEmail(t, tt.email); !slices.Equal(got, tt.want) {
+				t.Errorf("codes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockedEmailDomainsFileMissing(t *testing.T) {
+	t.Setenv("BLOCKED_EMAIL_DOMAINS_FILE", filepath.Join(t.TempDir(), "missing.txt"))
+	if _, err := LoadConfig(); err == nil {
+		t.Error("LoadConfig accepted a blocklist file that doesn't exist")
+	}
+}
+
+// Editing the file and sending SIGHUP swaps in the new list; a file that
+// can no longer be read keeps the old one.
+func TestBlockedEmailDomainsReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "blocked.txt")
+	if err := os.WriteFile(file, []byte("trash.example\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	domainPolicy(t, map[string]string{"BLOCKED_EMAIL_DOMAINS_FILE": file})
+	go reloadBlockedDomainsOnHangup()
+	// Give the goroutine time to register for SIGHUP, which would otherwise
+	// kill the test binary.
+	time.Sleep(50 * time.Millisecond)
+
+	hangupUntil := func(wait time.Duration, blocked func(domainSet) bool) bool {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+		for deadline := time.Now().Add(wait); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if blocked(*blockedEmailDomains.Load()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if err := os.WriteFile(file, []byte("spam.example\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if !hangupUntil(2*time.Second, func(s domainSet) bool { return s["spam.example"] && !s["trash.example"] }) {
+		t.Fatalf("blocklist not reloaded: %v", *blockedEmailDomains.Load())
+	}
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	hangupUntil(100*time.Millisecond, func(domainSet) bool { return false })
+	if s := *blockedEmailDomains.Load(); !s["spam.example"] {
+		t.Errorf("a failed reload replaced the blocklist: %v", s)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// errorReport is what gets forwarded to the error tracker about a failed
+// request.
+type errorReport struct {
+	Message   string
+	Stack     string
+	Method    string
+	Path      string
+	RequestID string
+	Status    int
+}
+
+// ErrorReporter forwards panics and server errors to an external tracker.
+// Report must not block: it is called on the request path.
+type ErrorReporter interface {
+	Report(report errorReport)
+}
+
+// noopReporter is used when no tracker is configured.
+type noopReporter struct{}
+
+func (noopReporter) Report(errorReport) {}
+
+// reporter is the ErrorReporter chosen from the configuration at startup.
+var reporter ErrorReporter = noopReporter{}
+
+// newErrorReporter builds the reporter for cfg, falling back to a no-op
+// when SENTRY_DSN is unset.
+func newErrorReporter(cfg *Config) ErrorReporter {
+	if cfg.SentryDSN == nil {
+		return noopReporter{}
+	}
+	return newSentryReporter(cfg.SentryDSN)
+}
+
+// sentryDSN is a parsed SENTRY_DSN of the form
+// https://<key>@<host>/<project>.
+type sentryDSN struct {
+	storeURL string
+	key      string
+}
+
+func parseSentryDSN(dsn string) (*sentryDSN, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("SENTRY_DSN must look like https://<key>@<host>/<project>")
+	}
+	path, project, _ := strings.Cut(strings.Trim(u.Path, "/"), "/")
+	if project == "" {
+		path, project = "", path
+	}
+	if project == "" {
+		return nil, fmt.Errorf("SENTRY_DSN is missing the project ID")
+	}
+	if path != "" {
+		path = "/" + path
+	}
+	return &sentryDSN{
+		storeURL: fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, path, project),
+		key:      u.User.Username(),
+	}, nil
+}
+
+// sentryQueueSize bounds how many reports may wait to be sent. Beyond it
+// reports are dropped rather than holding up requests.
+const sentryQueueSize = 100
+
+// sentryReporter posts reports to Sentry's store endpoint from a background
+// goroutine, so a slow or unreachable tracker never delays a response.
+type sentryReporter struct {
+	dsn    *sentryDSN
+	client *http.Client
+	queue  chan errorReport
+}
+
+func newSentryReporter(dsn *sentryDSN) *sentryReporter {
+	s := &sentryReporter{
+		dsn:    dsn,
+		client: &http.Client{Timeout: 5 * time.Second},
+		queue:  make(chan errorReport, sentryQueueSize),
+	}
+	go s.run()
+	return s
+}
+
+func (s *sentryReporter) Report(report errorReport) {
+	select {
+	case s.queue <- report:
+	default:
+		slog.Warn("error report dropped, queue full", "request_id", report.RequestID)
+	}
+}
+
+func (s *sentryReporter) run() {
+	for report := range s.queue {
+		if err := s.send(report); err != nil {
+			slog.Warn("failed to send error report", "request_id", report.RequestID, "error", err)
+		}
+	}
+}
+
+func (s *sentryReporter) send(report errorReport) error {
+	id := make([]byte, 16)
+	rand.Read(id)
+	event := map[string]any{
+		"event_id":  hex.EncodeToString(id),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"level":     "error",
+		"platform":  "go",
+		"release":   version,
+		"message":   report.Message,
+		"tags": map[string]string{
+			"method":     report.Method,
+			"path":       report.Path,
+			"request_id": report.RequestID,
+			"status":     fmt.Sprint(report.Status),
+		},
+	}
+	if report.Stack != "" {
+		event["extra"] = map[string]string{"stack": report.Stack}
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.dsn.storeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=go-http-server/%s", s.dsn.key, version))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sentry responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// fieldError is a validation failure on a single field. Code is one of the
+// constants below and, unlike Message, never changes wording, so clients
+// can branch on it.
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Field error codes, as <field>.<reason>. They are part of the API: add new
+// ones freely, but never rename or reuse one.
+const (
+	codeUsernameRequired      = "username.required"
+	codeUsernameInvalidFormat = "username.invalid_format"
+	codeUsernameDuplicate     = "username.duplicate"
+	codeUsernameRepeated      = "username.repeated_in_batch"
+
+	codeNameRequired = "name.required"
+	codeNameTooShort = "name.too_short"
+	codeNameTooLong  = "name.too_long"
+
+	codeEmailRequired         = "email.required"
+	codeEmailInvalidFormat    = "email.invalid_format"
+	codeEmailTooLong          = "email.too_long"
+	codeEmailDuplicate        = "email.duplicate"
+	codeEmailRepeated         = "email.repeated_in_batch"
+	codeEmailDomainNotAllowed = "email.domain_not_allowed"
+	codeEmailDomainBlocked    = "email.domain_blocked"
+	codeEmailUnchanged        = "email.unchanged"
+
+	codeMetadataTooLarge = "metadata.too_large"
+	codeRoleInvalid      = "role.invalid"
+	codeKeyTooLong       = "key.too_long"
+	codeKeyConflict      = "key.conflict"
+	codeSubjectRequired  = "subject.required"
+	codeSubjectInvalid   = "subject.invalid"
+	codeBodyRequired     = "body.required"
+
+	// Errors not tied to one field of the input.
+	codeUserDuplicate    = "user.duplicate"
+	codeUserCreateFailed = "user.create_failed"
+	codeRowFieldCount    = "row.field_count"
+)
+
+// apiError is an error response before it is rendered in the format the
+// client asked for.
+type apiError struct {
+	Status  int
+	Message string
+	// Errors lists per-field problems for validation failures.
+	Errors []fieldError
+	// Extra holds additional members, such as where a decode failed.
+	Extra map[string]any
+}
+
+// problemJSON is the media type of RFC 7807 problem details.
+const problemJSON = "application/problem+json"
+
+// writeError responds with a plain error message.
+func writeError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	writeAPIError(w, r, apiError{Status: status, Message: msg})
+}
+
+// writeAPIError renders e as RFC 7807 problem details when the client
+// accepts application/problem+json, and as {"error": ...} otherwise. Every
+// error response goes through here so the two formats stay consistent.
+func writeAPIError(w http.ResponseWriter, r *http.Request, e apiError) {
+	body := make(map[string]any, len(e.Extra)+5)
+	for k, v := range e.Extra {
+		body[k] = v
+	}
+
+	contentType := jsonContentType
+	if wantsProblemJSON(r) {
+		contentType = problemJSON + "; charset=utf-8"
+		body["type"] = "about:blank"
+		body["title"] = http.StatusText(e.Status)
+		body["status"] = e.Status
+		body["detail"] = e.Message
+	} else {
+		body["error"] = e.Message
+	}
+	if len(e.Errors) > 0 {
+		body["errors"] = e.Errors
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// Errors are never cacheable, whatever the handler set before failing.
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// wantsProblemJSON reports whether the Accept header lists
+// application/problem+json with a non-zero quality.
+func wantsProblemJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == problemJSON && params["q"] != "0" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// userETag is a strong validator for a user's current state. updated_at
+// moves on every save, so together with the ID it identifies a version. It
+// is truncated to the microsecond Postgres stores, so the tag returned by a
+// write matches the one a later read computes from the stored row.
+func userETag(user User) string {
+	updated := user.UpdatedAt.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano)
+	sum := sha256.Sum256([]byte(strconv.FormatUint(uint64(user.ID), 10) + "@" + updated))
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// ifMatch evaluates an If-Match precondition against user, which is nil
+// when the resource doesn't exist. It responds 412 and returns false when
+// the precondition fails. Per RFC 9110 the comparison is strong, so weak
+// tags never match, and "*" only requires the resource to exist.
+func ifMatch(w http.ResponseWriter, r *http.Request, user *User) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	if user != nil && etagMatches(header, *user) {
+		return true
+	}
+	writeError(w, r, http.StatusPreconditionFailed, "User has been modified since it was fetched")
+	return false
+}
+
+// etagMatches reports whether any tag in an If-Match header matches user.
+func etagMatches(header string, user User) bool {
+	current := userETag(user)
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// errPreconditionFailed is returned from a write whose If-Match
+// precondition failed once the row was locked.
+var errPreconditionFailed = errors.New("user modified since it was fetched")
+
+// lockForUpdate locks user id's row for the rest of tx and evaluates If-Match
+// again against it. ifMatch runs on a read made before the transaction, so
+// two clients holding the same tag can both pass it; only the first to take
+// the lock still matches once the other has saved. A user deleted since it
+// was read is gorm.ErrRecordNotFound, or a failed precondition when one was
+// given.
+func lockForUpdate(tx *gorm.DB, r *http.Request, id uint) error {
+	header := r.Header.Get("If-Match")
+	var current User
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "updated_at").First(&current, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) && header != "" {
+		return errPreconditionFailed
+	}
+	if err != nil {
+		return err
+	}
+	if header != "" && !etagMatches(header, current) {
+		return errPreconditionFailed
+	}
+	return nil
+}
+
+// writeUpdateError responds to a failed update of an existing user.
+func writeUpdateError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case requestCanceled(w, r, err):
+	case errors.Is(err, errPreconditionFailed):
+		writeError(w, r, http.StatusPreconditionFailed, "User has been modified since it was fetched")
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		writeError(w, r, http.StatusNotFound, "User not found")
+	default:
+		writeSaveError(w, r, err, "Failed to update user")
+	}
+}
+
+// errModifiedSince is returned from a write whose If-Unmodified-Since
+// precondition failed once the row was locked.
+var errModifiedSince = errors.New("user modified since the given date")
+
+// ifUnmodifiedSince parses the If-Unmodified-Since header. ok is false
+// after a 400 for a malformed date; since is zero when there is no header.
+func ifUnmodifiedSince(w http.ResponseWriter, r *http.Request) (since time.Time, ok bool) {
+	header := r.Header.Get("If-Unmodified-Since")
+	if header == "" {
+		return time.Time{}, true
+	}
+	since, err := http.ParseTime(header)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "If-Unmodified-Since must be an HTTP date")
+		return time.Time{}, false
+	}
+	return since, true
+}
+
+// modifiedSince reports whether user changed after since. HTTP dates only
+// have one-second resolution, so updated_at is compared at that resolution
+// too, or a date formatted from the user's own updated_at would fail.
+func modifiedSince(user User, since time.Time) bool {
+	return user.UpdatedAt.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIfMatch(t *testing.T) {
+	testConfig(t, nil)
+	user := User{ID: 7, UpdatedAt: time.Date(2025, 3, 1, 12, 0, 0, 123456789, time.UTC)}
+	current := userETag(user)
+
+	tests := []struct {
+		name   string
+		header string
+		user   *User
+		want   bool
+	}{
+		{"no header", "", &user, true},
+		{"no header, missing resource", "", nil, true},
+		{"matching tag", current, &user, true},
+		{"matching tag in a list", `"0000", ` + current, &user, true},
+		{"star", "*", &user, true},
+		{"mismatching tag", `"0000"`, &user, false},
+		{"weak tag never matches", "W/" + current, &user, false},
+		{"missing resource", current, nil, false},
+		{"star on a missing resource", "*", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/api/users/7", nil)
+			if tt.header != "" {
+				req.Header.Set("If-Match", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			if got := ifMatch(rec, req, tt.user); got != tt.want {
+				t.Fatalf("ifMatch = %v, want %v", got, tt.want)
+			}
+			if !tt.want && rec.Code != http.StatusPreconditionFailed {
+				t.Errorf("status = %d, want 412", rec.Code)
+			}
+		})
+	}
+}
+
+func TestUserETagIgnoresSubMicrosecond(t *testing.T) {
+	at := time.Date(2025, 3, 1, 12, 0, 0, 123456000, time.UTC)
+	a := userETag(User{ID: 1, UpdatedAt: at.Add(789)})
+	b := userETag(User{ID: 1, UpdatedAt: at})
+	if a != b {
+		t.Errorf("tags differ below the microsecond: %s vs %s", a, b)
+	}
+	if c := userETag(User{ID: 2, UpdatedAt: at}); c == b {
+		t.Error("different users share a tag")
+	}
+}
+
+func TestUpdateUserIfMatch(t *testing.T) {
+	testDB(t)
+	user := seedUser(t, "alice", "Alice", "alice@example.com")
+	id := strconv.FormatUint(uint64(user.ID), 10)
+	vars := map[string]string{"id": id}
+	tag := userETag(user)
+
+	rec := serve(updateUser, http.MethodPut, "/api/users/"+id, vars, `{"name": "Alice A"}`, http.Header{"If-Match": {`"0000"`}})
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("mismatching tag: status = %d, want 412", rec.Code)
+	}
+
+	rec = serve(updateUser, http.MethodPut, "/api/users/"+id, vars, `{"name": "Alice A"}`, http.Header{"If-Match": {tag}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("matching tag: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(updateUser, http.MethodPut, "/api/users/999999", map[string]string{"id": "999999"}, `{"name": "Nobody"}`, http.Header{"If-Match": {tag}})
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("missing resource: status = %d, want 412", rec.Code)
+	}
+}
+
+// Two clients holding the same tag race to update the user. The early
+// If-Match check passes for both, so only the locked re-check can stop the
+// second write from silently overwriting the first.
+func TestUpdateUserIfMatchRace(t *testing.T) {
+	testDB(t)
+	user := seedUser(t, "alice", "Alice", "alice@example.com")
+	id := strconv.FormatUint(uint64(user.ID), 10)
+	tag := userETag(user)
+
+	const clients = 5
+	codes := make([]int, clients)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := `{"name": "Writer ` + strconv.Itoa(i) + `"}`
+			rec := serve(updateUser, http.MethodPut, "/api/users/"+id, map[string]string{"id": id}, body, http.Header{"If-Match": {tag}})
+			codes[i] = rec.Code
+		}()
+	}
+	wg.Wait()
+
+	ok := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			ok++
+		case http.StatusPreconditionFailed:
+		default:
+			t.Fatalf("unexpected status %d", code)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("%d updates succeeded with the same tag, want exactly 1 (statuses %v)", ok, codes)
+	}
+}
+
+func TestIfUnmodifiedSince(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   time.Time
+		ok     bool
+	}{
+		{"no header", "", time.Time{}, true},
+		{"IMF-fixdate", "Sat, 01 Mar 2025 12:00:00 GMT", time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC), true},
+		{"RFC 850", "Saturday, 01-Mar-25 12:00:00 GMT", time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC), true},
+		{"asctime", "Sat Mar  1 12:00:00 2025", time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC), true},
+		{"RFC 3339", "2025-03-01T12:00:00Z", time.Time{}, false},
+		{"garbage", "yesterday", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/api/users/7", nil)
+			if tt.header != "" {
+				req.Header.Set("If-Unmodified-Since", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			since, ok := ifUnmodifiedSince(rec, req)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok && rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", rec.Code)
+			}
+			if !since.Equal(tt.want) {
+				t.Errorf("since = %v, want %v", since, tt.want)
+			}
+		})
+	}
+}
+
+func TestModifiedSince(t *testing.T) {
+	since := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		updatedAt time.Time
+		want      bool
+	}{
+		{"before", since.Add(-time.Second), false},
+		{"at the same second", since, false},
+		{"later within the same second", since.Add(999 * time.Millisecond), false},
+		{"a second later", since.Add(time.Second), true},
+		{"in another zone", since.Add(time.Hour).In(time.FixedZone("CET", 3600)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := modifiedSince(User{UpdatedAt: tt.updatedAt}, since); got != tt.want {
+				t.Errorf("modifiedSince = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteUserIfUnmodifiedSince(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  func(updatedAt time.Time) string
+		status  int
+		deleted bool
+	}{
+		{"no header", func(time.Time) string { return "" }, http.StatusNoContent, true},
+		{"unmodified since", func(at time.Time) string { return at.Add(time.Second).UTC().Format(http.TimeFormat) }, http.StatusNoContent, true},
+		{"modified since", func(at time.Time) string { return at.Add(-time.Hour).UTC().Format(http.TimeFormat) }, http.StatusPreconditionFailed, false},
+		{"malformed", func(time.Time) string { return "yesterday" }, http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testDB(t)
+			user := seedUser(t, "alice", "Alice", "alice@example.com")
+			id := strconv.FormatUint(uint64(user.ID), 10)
+
+			header := http.Header{}
+			if h := tt.header(user.UpdatedAt); h != "" {
+				header.Set("If-Unmodified-Since", h)
+			}
+			rec := serve(deleteUser, http.MethodDelete, "/api/users/"+id, map[string]string{"id": id}, "", header)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			var n int64
+			if err := db.Model(&User{}).Where("id = ?", user.ID).Count(&n).Error; err != nil {
+				t.Fatal(err)
+			}
+			if deleted := n == 0; deleted != tt.deleted {
+				t.Errorf("deleted = %v, want %v", deleted, tt.deleted)
+			}
+		})
+	}
+}
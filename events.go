@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event types published on the hub.
+const (
+	eventUserCreated = "user.created"
+	eventUserUpdated = "user.updated"
+	eventUserDeleted = "user.deleted"
+)
+
+// userEvent is a change to a user, fanned out to live subscribers.
+type userEvent struct {
+	Type string `json:"type"`
+	User User   `json:"user"`
+}
+
+// eventHub is an in-process pub/sub for user events.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan userEvent]struct{}
+}
+
+var hub = &eventHub{subs: map[chan userEvent]struct{}{}}
+
+func (h *eventHub) subscribe() chan userEvent {
+	ch := make(chan userEvent, 16)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan userEvent) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+// publish delivers ev to every subscriber. A subscriber whose buffer is full
+// misses the event rather than stalling the handler that published it.
+func (h *eventHub) publish(ev userEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// streamUserEvents pushes user events to the client as Server-Sent Events
+// until the client disconnects, the stream reaches its maximum lifetime or
+// the server shuts down. In the last two cases a final close event tells the
+// client why, so it can decide whether to reconnect.
+func streamUserEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, "Streaming unsupported")
+		return
+	}
+
+	events := hub.subscribe()
+	defer hub.unsubscribe(events)
+
+	lifetime := time.NewTimer(config.StreamMaxLifetime)
+	defer lifetime.Stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-streamShutdown:
+			writeSSE(w, "close", `{"reason": "server_shutdown"}`)
+			flusher.Flush()
+			return
+		case <-lifetime.C:
+			writeSSE(w, "close", `{"reason": "max_lifetime"}`)
+			flusher.Flush()
+			return
+		case ev := <-events:
+			data, _ := json.Marshal(ev)
+			writeSSE(w, ev.Type, string(data))
+			flusher.Flush()
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, event, data string) {
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
@@ -0,0 +1,55 @@
+package main
+
+import "strings"
+
+// Features gates optional groups of endpoints. Each flag is read from
+// FEATURE_<NAME> and is consulted once, when routes are registered, so a
+// disabled feature's routes don't exist and answer 404. To add a flag, add
+// a field and a row to featureFlags.
+type Features struct {
+	Events      bool // GET /api/users/events (SSE)
+	WebSocket   bool // GET /api/users/ws
+	Stream      bool // GET /api/users/stream (NDJSON)
+	Batch       bool // POST /api/users/batch and /batch/validate
+	EmailChange bool // POST /api/users/{id}/email-change and /confirm
+	AuditDiff   bool // GET /api/users/{id}/audit/diff
+}
+
+// featureFlags maps each flag's name to its field. Every existing feature
+// defaults to on, so enabling the mechanism changes nothing until a flag is
+// turned off; a new feature can ship dark by defaulting to false.
+var featureFlags = []struct {
+	name  string
+	def   bool
+	field func(*Features) *bool
+}{
+	{"events", true, func(f *Features) *bool { return &f.Events }},
+	{"websocket", true, func(f *Features) *bool { return &f.WebSocket }},
+	{"stream", true, func(f *Features) *bool { return &f.Stream }},
+	{"batch", true, func(f *Features) *bool { return &f.Batch }},
+	{"email_change", true, func(f *Features) *bool { return &f.EmailChange }},
+	{"audit_diff", true, func(f *Features) *bool { return &f.AuditDiff }},
+}
+
+func loadFeatures() (Features, error) {
+	var f Features
+	for _, flag := range featureFlags {
+		v, err := envBool("FEATURE_"+strings.ToUpper(flag.name), flag.def)
+		if err != nil {
+			return f, err
+		}
+		*flag.field(&f) = v
+	}
+	return f, nil
+}
+
+// enabled returns the names of the flags that are on, in featureFlags order.
+func (f Features) enabled() []string {
+	var names []string
+	for _, flag := range featureFlags {
+		if *flag.field(&f) {
+			names = append(names, flag.name)
+		}
+	}
+	return names
+}
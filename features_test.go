@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestLoadFeatures(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		enabled []string
+		wantErr bool
+	}{
+		{"defaults", nil, []string{"events", "websocket", "stream", "batch", "email_change", "audit_diff"}, false},
+		{"one off", map[string]string{"FEATURE_WEBSOCKET": "false"}, []string{"events", "stream", "batch", "email_change", "audit_diff"}, false},
+		{"all off", map[string]string{
+			"FEATURE_EVENTS": "0", "FEATURE_WEBSOCKET": "0", "FEATURE_STREAM": "0",
+			"FEATURE_BATCH": "0", "FEATURE_EMAIL_CHANGE": "0", "FEATURE_AUDIT_DIFF": "0",
+		}, nil, false},
+		{"not a boolean", map[string]string{"FEATURE_BATCH": "maybe"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			f, err := loadFeatures()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(f.enabled(), tt.enabled) {
+				t.Errorf("enabled = %v, want %v", f.enabled(), tt.enabled)
+			}
+		})
+	}
+}
+
+// A disabled feature's routes are never registered, so they answer 404
+// while the rest of the API is unaffected.
+func TestDisabledFeatureRoutes(t *testing.T) {
+	tests := []struct {
+		flag   string
+		method string
+		path   string
+	}{
+		{"FEATURE_EVENTS", http.MethodGet, "/api/users/events"},
+		{"FEATURE_WEBSOCKET", http.MethodGet, "/api/users/ws"},
+		{"FEATURE_STREAM", http.MethodGet, "/api/users/stream"},
+		{"FEATURE_BATCH", http.MethodPost, "/api/users/batch/validate"},
+		{"FEATURE_BATCH", http.MethodPost, importCSVPath},
+		{"FEATURE_EMAIL_CHANGE", http.MethodPost, "/api/users/1/email-change"},
+		{"FEATURE_EMAIL_CHANGE", http.MethodPost, "/api/users/1/email-change/confirm"},
+		{"FEATURE_AUDIT_DIFF", http.MethodGet, "/api/users/1/audit/diff"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.flag+" "+tt.path, func(t *testing.T) {
+			routeMatched := func(env map[string]string) (*mux.Router, bool) {
+				cfg := testConfig(t, env)
+				router, _ := newRouter(cfg, newRateLimiter(nil, nil))
+				var match mux.RouteMatch
+				ok := router.Match(httptest.NewRequest(tt.method, tt.path, nil), &match) && match.MatchErr == nil
+				return router, ok && !isFeatureOff(match.Route)
+			}
+
+			if _, ok := routeMatched(nil); !ok {
+				t.Fatalf("%s %s is not routed with the feature on", tt.method, tt.path)
+			}
+			router, ok := routeMatched(map[string]string{tt.flag: "false"})
+			if ok {
+				t.Fatalf("%s %s is still routed with %s=false", tt.method, tt.path, tt.flag)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("status = %d, want 404", rec.Code)
+			}
+			rec = httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("/healthz: status = %d with %s=false", rec.Code, tt.flag)
+			}
+			for _, e := range listEndpoints(router) {
+				if e.Path == strings.Replace(tt.path, "/1/", "/{id}/", 1) {
+					t.Errorf("%s is still listed at the API root", tt.path)
+				}
+			}
+		})
+	}
+}
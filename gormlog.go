@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// slowQueryThreshold is how long a query may take before it is logged as
+// slow, matching GORM's own default.
+const slowQueryThreshold = 200 * time.Millisecond
+
+// gormLogger sends GORM's logs through slog so queries share the app's
+// format and carry the request ID of the HTTP request that issued them.
+type gormLogger struct {
+	level logger.LogLevel
+}
+
+// newGormLogger maps the app's log level onto GORM's: debug traces every
+// query, info and warn report slow queries and errors, and error reports
+// only errors.
+func newGormLogger(level slog.Level) *gormLogger {
+	switch {
+	case level <= slog.LevelDebug:
+		return &gormLogger{level: logger.Info}
+	case level <= slog.LevelWarn:
+		return &gormLogger{level: logger.Warn}
+	default:
+		return &gormLogger{level: logger.Error}
+	}
+}
+
+func (l *gormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &gormLogger{level: level}
+}
+
+func (l *gormLogger) Info(ctx context.Context, msg string, args ...any) {
+	if l.level >= logger.Info {
+		slog.InfoContext(ctx, fmt.Sprintf(msg, args...), l.attrs(ctx)...)
+	}
+}
+
+func (l *gormLogger) Warn(ctx context.Context, msg string, args ...any) {
+	if l.level >= logger.Warn {
+		slog.WarnContext(ctx, fmt.Sprintf(msg, args...), l.attrs(ctx)...)
+	}
+}
+
+func (l *gormLogger) Error(ctx context.Context, msg string, args ...any) {
+	if l.level >= logger.Error {
+		slog.ErrorContext(ctx, fmt.Sprintf(msg, args...), l.attrs(ctx)...)
+	}
+}
+
+func (l *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && l.level >= logger.Error && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		slog.ErrorContext(ctx, "query failed", l.queryAttrs(ctx, sql, rows, elapsed, "error", err)...)
+	case elapsed > slowQueryThreshold && l.level >= logger.Warn:
+		sql, rows := fc()
+		slog.WarnContext(ctx, "slow query", l.queryAttrs(ctx, sql, rows, elapsed)...)
+	case l.level >= logger.Info:
+		sql, rows := fc()
+		slog.DebugContext(ctx, "query", l.queryAttrs(ctx, sql, rows, elapsed)...)
+	}
+}
+
+func (l *gormLogger) attrs(ctx context.Context) []any {
+	if id := requestID(ctx); id != "" {
+		return []any{"request_id", id}
+	}
+	return nil
+}
+
+func (l *gormLogger) queryAttrs(ctx context.Context, sql string, rows int64, elapsed time.Duration, extra ...any) []any {
+	attrs := append(l.attrs(ctx), "sql", sql, "rows", rows, "elapsed", elapsed)
+	return append(attrs, extra...)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// ready reports whether the server should receive traffic. It is set once
+// the listener is up and cleared as soon as shutdown begins, so load
+// balancers stop routing new requests while in-flight ones drain.
+var ready atomic.Bool
+
+// healthz is the liveness probe: the process is up and serving HTTP.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz is the readiness probe: the server is accepting traffic and the
+// database answers a ping.
+func readyz(w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() {
+		writeError(w, r, http.StatusServiceUnavailable, "Not ready")
+		return
+	}
+	if db == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "Database not initialized")
+		return
+	}
+	if err := dbHealth.check(r.Context(), config.ReadinessCacheTTL, pingDB); err != nil {
+		writeError(w, r, http.StatusServiceUnavailable, "Database unavailable")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// readyPingTimeout bounds a readiness ping. The ping is shared by every
+// probe waiting on it, so it can't use any one probe's deadline.
+const readyPingTimeout = 5 * time.Second
+
+func pingDB(ctx context.Context) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, readyPingTimeout)
+	defer cancel()
+	return sqlDB.PingContext(ctx)
+}
+
+// dbHealth caches the outcome of the readiness ping, so a burst of probes
+// costs the database one ping per TTL rather than one each.
+var dbHealth healthCache
+
+type healthCache struct {
+	group   singleflight.Group
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// check returns the last result if it is younger than ttl, and otherwise
+// runs ping, with concurrent callers sharing a single run. Failures are
+// cached for the same ttl, which is short enough that an outage shows up
+// within one probe period.
+func (h *healthCache) check(ctx context.Context, ttl time.Duration, ping func(context.Context) error) error {
+	h.mu.Lock()
+	if !h.checked.IsZero() && time.Since(h.checked) < ttl {
+		err := h.err
+		h.mu.Unlock()
+		return err
+	}
+	h.mu.Unlock()
+
+	pingCtx := context.WithoutCancel(ctx)
+	ch := h.group.DoChan("ping", func() (any, error) {
+		err := ping(pingCtx)
+		h.mu.Lock()
+		h.checked, h.err = time.Now(), err
+		h.mu.Unlock()
+		return nil, err
+	})
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case res := <-ch:
+		return res.Err
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Rapid checks within the TTL share one ping, concurrent or not, and a
+// failure is cached like a success.
+func TestHealthCacheSinglePing(t *testing.T) {
+	down := errors.New("connection refused")
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"healthy", nil},
+		{"unhealthy", down},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h healthCache
+			var pings atomic.Int32
+			ping := func(context.Context) error {
+				pings.Add(1)
+				time.Sleep(20 * time.Millisecond)
+				return tt.err
+			}
+
+			var wg sync.WaitGroup
+			for range 20 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := h.check(context.Background(), time.Minute, ping); !errors.Is(err, tt.err) {
+						t.Errorf("err = %v, want %v", err, tt.err)
+					}
+				}()
+			}
+			wg.Wait()
+			for range 20 {
+				if err := h.check(context.Background(), time.Minute, ping); !errors.Is(err, tt.err) {
+					t.Errorf("err = %v, want %v", err, tt.err)
+				}
+			}
+			if n := pings.Load(); n != 1 {
+				t.Errorf("%d pings, want 1", n)
+			}
+		})
+	}
+}
+
+// Once the TTL has passed the next check pings again, so an outage shows
+// up quickly.
+func TestHealthCacheExpires(t *testing.T) {
+	var h healthCache
+	var pings atomic.Int32
+	results := []error{nil, errors.New("connection refused")}
+	ping := func(context.Context) error {
+		return results[pings.Add(1)-1]
+	}
+
+	const ttl = 20 * time.Millisecond
+	if err := h.check(context.Background(), ttl, ping); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * ttl)
+	if err := h.check(context.Background(), ttl, ping); err == nil {
+		t.Error("outage hidden by an expired result")
+	}
+	if n := pings.Load(); n != 2 {
+		t.Errorf("%d pings, want 2", n)
+	}
+}
+
+// A probe that gives up doesn't cancel the ping others are waiting on.
+func TestHealthCacheCallerCancel(t *testing.T) {
+	var h healthCache
+	release := make(chan struct{})
+	pingCtx := make(chan context.Context, 1)
+	ping := func(ctx context.Context) error {
+		pingCtx <- ctx
+		<-release
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- h.check(ctx, time.Minute, ping) }()
+	pctx := <-pingCtx
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if pctx.Err() != nil {
+		t.Error("the shared ping was cancelled with its caller")
+	}
+	close(release)
+}
+
+func TestReadyz(t *testing.T) {
+	testConfig(t, nil)
+	tests := []struct {
+		name   string
+		ready  bool
+		status int
+	}{
+		{"not ready yet", false, http.StatusServiceUnavailable},
+		{"ready without a database", true, http.StatusServiceUnavailable},
+	}
+	prevDB := db
+	db = nil
+	t.Cleanup(func() { db = prevDB; ready.Store(false) })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready.Store(tt.ready)
+			if rec := serve(readyz, http.MethodGet, "/readyz", nil, "", nil); rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
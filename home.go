@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const apiName = "Go HTTP Server"
+
+// version is stamped at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+// endpoint describes one registered path and the methods it accepts.
+type endpoint struct {
+	Path    string   `json:"path"`
+	Methods []string `json:"methods"`
+}
+
+// apiIndex is the document served at the API root.
+type apiIndex struct {
+	Name      string     `json:"name"`
+	Version   string     `json:"version"`
+	Endpoints []endpoint `json:"endpoints"`
+}
+
+// listEndpoints walks the router and groups the registered methods by path,
+// in registration order.
+func listEndpoints(r *mux.Router) []endpoint {
+	var endpoints []endpoint
+	index := map[string]int{}
+	r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || isFeatureOff(route) {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		i, ok := index[path]
+		if !ok {
+			i = len(endpoints)
+			index[path] = i
+			endpoints = append(endpoints, endpoint{Path: path})
+		}
+		endpoints[i].Methods = append(endpoints[i].Methods, methods...)
+		return nil
+	})
+	return endpoints
+}
+
+// homeHandler serves a machine-readable index of the API, listing whatever
+// routes are registered on router.
+func homeHandler(router *mux.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		index := apiIndex{Name: apiName, Version: version, Endpoints: listEndpoints(router)}
+
+		if prefersPlainText(r) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprintf(w, "✅ Welcome to %s %s! Available endpoints:\n", index.Name, index.Version)
+			for _, e := range index.Endpoints {
+				fmt.Fprintf(w, "  %s %s\n", strings.Join(e.Methods, "/"), e.Path)
+			}
+			return
+		}
+
+		writeJSON(w, r, http.StatusOK, index)
+	}
+}
+
+// prefersPlainText reports whether the Accept header ranks text/plain above
+// JSON. Ties and missing headers go to JSON.
+func prefersPlainText(r *http.Request) bool {
+	var textQ, jsonQ float64
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "text/plain":
+			textQ = max(textQ, q)
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return textQ > jsonQ
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestPrefersPlainText(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"text/plain", true},
+		{"application/json", false},
+		{"text/plain, application/json", false},
+		{"application/json;q=0.5, text/plain", true},
+		{"text/plain;q=0.9, application/json;q=0.9", false},
+		{"text/plain;q=bogus", false},
+		{"text/html, text/plain;q=0.8", true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		if got := prefersPlainText(req); got != tt.want {
+			t.Errorf("Accept %q: prefersPlainText = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestHomeHandler(t *testing.T) {
+	testConfig(t, nil)
+	ok := func(http.ResponseWriter, *http.Request) {}
+	r := mux.NewRouter()
+	r.HandleFunc("/api/users", ok).Methods(http.MethodGet)
+	r.HandleFunc("/api/users", ok).Methods(http.MethodPost)
+	r.HandleFunc("/api/users/{id}", ok).Methods(http.MethodGet, http.MethodDelete)
+	r.Handle("/api/users/ws", featureOff{}).Methods(http.MethodGet)
+
+	rec := httptest.NewRecorder()
+	homeHandler(r)(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	var index apiIndex
+	if err := json.Unmarshal(rec.Body.Bytes(), &index); err != nil {
+		t.Fatal(err)
+	}
+	want := []endpoint{
+		{Path: "/api/users", Methods: []string{http.MethodGet, http.MethodPost}},
+		{Path: "/api/users/{id}", Methods: []string{http.MethodGet, http.MethodDelete}},
+	}
+	if index.Name != apiName || index.Version != version || !reflect.DeepEqual(index.Endpoints, want) {
+		t.Errorf("index = %+v", index)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/plain")
+	homeHandler(r)(rec, req)
+	if got := rec.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "  GET/POST /api/users\n") || strings.Contains(body, "/ws") {
+		t.Errorf("text index = %q", body)
+	}
+}
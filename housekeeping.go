@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// evictor is state, in memory or in the database, that accumulates entries
+// needing periodic cleanup.
+type evictor interface {
+	// evictExpired drops entries that are no longer needed as of now and
+	// returns how many were removed.
+	evictExpired(now time.Time) int
+	// name identifies the store in logs.
+	name() string
+}
+
+// runHousekeeping evicts expired entries from every store on each tick of
+// interval until done is closed.
+func runHousekeeping(interval time.Duration, done <-chan struct{}, stores ...evictor) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			for _, s := range stores {
+				if n := s.evictExpired(now); n > 0 {
+					slog.Debug("housekeeping evicted entries", "store", s.name(), "evicted", n)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// The ID list matches the IDs of the full list for the same query.
+func TestGetUserIDsMatchesList(t *testing.T) {
+	testDB(t)
+	for i, name := range []string{"Alice", "Bob", "Alina", "Carol", "Alfred"} {
+		user := seedUser(t, fmt.Sprintf("user%d", i), name, fmt.Sprintf("user%d@example.com", i))
+		if name == "Carol" {
+			if err := db.Model(&user).Update("metadata", userMetadata{"plan": "pro"}).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"everything", ""},
+		{"filtered", "?q=al"},
+		{"sorted", "?sort=-name"},
+		{"paginated", "?sort=name&limit=2&offset=1"},
+		{"metadata filter", "?metadata.plan=pro"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(getUsers, http.MethodGet, "/api/users"+tt.query, nil, "", nil)
+			var page userPageResponse
+			decodeBody(t, rec, &page)
+			var want []uint
+			for _, u := range page.Data {
+				want = append(want, u.ID)
+			}
+
+			rec = serve(getUserIDs, http.MethodGet, "/api/users/ids"+tt.query, nil, "", nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var got []uint
+			decodeBody(t, rec, &got)
+			if !slices.Equal(got, want) || len(got) == 0 {
+				t.Errorf("ids = %v, list has %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// importCSVPath is the CSV import endpoint. Its body may be far larger than
+// MAX_BODY_BYTES, so requestBody applies MAX_IMPORT_BYTES to it instead.
+const importCSVPath = "/api/users/import.csv"
+
+// importBatchSize is how many valid rows are inserted per transaction, and
+// so how often progress is reported.
+const importBatchSize = 500
+
+// importColumns are the columns an import must have. Their order in the
+// header row doesn't matter.
+var importColumns = []string{"username", "name", "email"}
+
+// importEvent is one line of the NDJSON import response. Type is "error"
+// for a rejected row, "progress" after each batch, and "summary" last. A
+// summary with Error set means the import stopped early; rows committed
+// before that stay committed.
+type importEvent struct {
+	Type    string       `json:"type"`
+	Line    int          `json:"line,omitempty"`
+	Errors  []fieldError `json:"errors,omitempty"`
+	Rows    int          `json:"rows,omitempty"`
+	Created int          `json:"created,omitempty"`
+	Failed  int          `json:"failed,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// importRow is a valid row waiting to be inserted.
+type importRow struct {
+	line int
+	user User
+}
+
+// importUsersCSV serves POST /api/users/import.csv. The body is a CSV file
+// with a header row naming at least username, name and email. It is read
+// row by row and inserted importBatchSize rows per transaction, so memory
+// use doesn't grow with the file. Results stream back as NDJSON while the
+// upload is still being read: an error line for each rejected row, with its
+// line number, a progress line after each batch, and a final summary.
+func importUsersCSV(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil || mediaType != "text/csv" {
+			writeError(w, r, http.StatusUnsupportedMediaType, "Content-Type must be text/csv")
+			return
+		}
+	}
+
+	reader := csv.NewReader(r.Body)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.Is(err, io.EOF) || errors.As(err, &tooLarge) || malformedGzip(err) {
+			writeDecodeError(w, r, err)
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, "Malformed CSV header: "+err.Error())
+		return
+	}
+	columns, err := importColumnIndex(header)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Results are written while the body is still being read, which
+	// HTTP/1.1 only allows once full duplex is enabled. HTTP/2 always is.
+	http.NewResponseController(w).EnableFullDuplex()
+
+	ctx, cancel := streamContext(r)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	summary := importEvent{Type: "summary"}
+	var pending []importRow
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		created, rowErrors := insertImportBatch(ctx, pending)
+		summary.Created += created
+		summary.Failed += len(rowErrors)
+		for _, e := range rowErrors {
+			enc.Encode(e)
+		}
+		enc.Encode(importEvent{Type: "progress", Rows: summary.Rows, Created: summary.Created, Failed: summary.Failed})
+		if flusher != nil {
+			flusher.Flush()
+		}
+		pending = pending[:0]
+	}
+
+	for ctx.Err() == nil {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) && errors.Is(err, csv.ErrFieldCount) {
+			summary.Rows++
+			summary.Failed++
+			enc.Encode(importEvent{Type: "error", Line: parseErr.StartLine, Errors: []fieldError{{Code: codeRowFieldCount, Message: fmt.Sprintf("Row has %d fields, header has %d", len(record), len(header))}}})
+			continue
+		}
+		if err != nil {
+			summary.Error = importReadError(err)
+			break
+		}
+
+		summary.Rows++
+		user := User{
+			Username: normalizeUsername(record[columns["username"]]),
+			Name:     strings.TrimSpace(record[columns["name"]]),
+			Email:    normalizeEmail(record[columns["email"]]),
+			Active:   true,
+		}
+		if errs := validateNewUser(user); len(errs) > 0 {
+			summary.Failed++
+			enc.Encode(importEvent{Type: "error", Line: line, Errors: errs})
+			continue
+		}
+		pending = append(pending, importRow{line: line, user: user})
+		if len(pending) == importBatchSize {
+			flush()
+		}
+	}
+	if ctx.Err() != nil && summary.Error == "" {
+		summary.Error = "Import interrupted before the end of the file"
+	} else {
+		flush()
+	}
+	enc.Encode(summary)
+}
+
+// importColumnIndex maps each column the import uses to its position in
+// header. Unknown and repeated columns are rejected.
+func importColumnIndex(header []string) (map[string]int, error) {
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		known := false
+		for _, c := range importColumns {
+			known = known || c == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown column '%s'; expected %s", name, strings.Join(importColumns, ", "))
+		}
+		if _, dup := columns[name]; dup {
+			return nil, fmt.Errorf("column '%s' appears twice", name)
+		}
+		columns[name] = i
+	}
+	for _, c := range importColumns {
+		if _, ok := columns[c]; !ok {
+			return nil, fmt.Errorf("missing column '%s'", c)
+		}
+	}
+	return columns, nil
+}
+
+// importReadError describes an error that stops an import.
+func importReadError(err error) string {
+	var tooLarge *http.MaxBytesError
+	var parseErr *csv.ParseError
+	switch {
+	case errors.As(err, &tooLarge):
+		return fmt.Sprintf("Import exceeds %d bytes", tooLarge.Limit)
+	case malformedGzip(err):
+		return "Malformed gzip body"
+	case errors.As(err, &parseErr):
+		return fmt.Sprintf("Malformed CSV at line %d: %v", parseErr.Line, parseErr.Err)
+	default:
+		return "Failed to read upload"
+	}
+}
+
+// insertImportBatch creates rows in one transaction, with their audit
+// entries. If the batch fails, typically on a username that is already
+// taken, each row is retried on its own so the errors can be pinned to
+// lines and the good rows still go in.
+func insertImportBatch(ctx context.Context, rows []importRow) (created int, rowErrors []importEvent) {
+	users := make([]User, len(rows))
+	for i, row := range rows {
+		users[i] = row.user
+	}
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(&users, len(users)).Error; err != nil {
+			return err
+		}
+		entries := make([]*auditEntry, len(users))
+		for i, u := range users {
+			entries[i] = newAuditEntry(ctx, auditCreated, u)
+		}
+		return tx.CreateInBatches(entries, len(entries)).Error
+	})
+	if err == nil {
+		for _, u := range users {
+			hub.publish(userEvent{Type: eventUserCreated, User: u})
+		}
+		return len(users), nil
+	}
+
+	for _, row := range rows {
+		user := row.user
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return insertBatchItem(ctx, tx, &user, "")
+		})
+		if err != nil {
+			conflict, ok := uniqueViolation(err)
+			if !ok {
+				conflict = fieldError{Code: codeUserCreateFailed, Message: "Failed to create user"}
+			}
+			rowErrors = append(rowErrors, importEvent{Type: "error", Line: row.line, Errors: []fieldError{conflict}})
+			continue
+		}
+		hub.publish(userEvent{Type: eventUserCreated, User: user})
+		created++
+	}
+	return created, rowErrors
+}
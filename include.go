@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// includableRelations maps the names accepted by ?include= to the GORM
+// association they preload. Relations are added here as their models land;
+// the response field is whatever json tag the association carries on User.
+var includableRelations = map[string]string{}
+
+// parseIncludes reads the comma-separated ?include= (or its ?embed= alias)
+// list, rejecting anything not in includableRelations. The result is sorted
+// so it can be used in cache keys.
+func parseIncludes(r *http.Request) ([]string, error) {
+	q := r.URL.Query()
+	raw := q.Get("include")
+	if raw == "" {
+		raw = q.Get("embed")
+	}
+	if raw == "" {
+		return nil, nil
+	}
+
+	var includes []string
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := includableRelations[name]; !ok {
+			return nil, fmt.Errorf("Unknown include %q", name)
+		}
+		if !slices.Contains(includes, name) {
+			includes = append(includes, name)
+		}
+	}
+	slices.Sort(includes)
+	return includes, nil
+}
+
+// preloadIncludes adds a Preload for every requested relation.
+func preloadIncludes(tx *gorm.DB, includes []string) *gorm.DB {
+	for _, name := range includes {
+		tx = tx.Preload(includableRelations[name])
+	}
+	return tx
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// jsonContentType is the Content-Type of every JSON response. JSON is
+// always UTF-8, but some strict clients want the charset spelled out.
+const jsonContentType = "application/json; charset=utf-8"
+
+// dataEnvelope wraps a single resource for clients that want every response
+// enveloped.
+type dataEnvelope struct {
+	Data any `json:"data"`
+}
+
+// wantsEnvelope reports whether r should get an enveloped response. An
+// X-Response-Envelope header of true or false decides first, then an Accept
+// profile of "envelope" or "bare" (application/json; profile="bare"), then
+// RESPONSE_ENVELOPE, and byDefault, the endpoint's usual shape, last.
+// Values that don't parse are ignored.
+func wantsEnvelope(r *http.Request, byDefault bool) bool {
+	if b, err := strconv.ParseBool(r.Header.Get("X-Response-Envelope")); err == nil {
+		return b
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch params["profile"] {
+		case "envelope":
+			return true
+		case "bare":
+			return false
+		}
+	}
+	if config.ResponseEnvelope != nil {
+		return *config.ResponseEnvelope
+	}
+	return byDefault
+}
+
+// writeEnveloped sends envelope or just data, as wantsEnvelope decides.
+// envelope must carry data under "data", so clients can migrate between
+// the two shapes without the payload changing. Metadata such as totals only
+// survives in the bare form where the handler also sets it as a header.
+func writeEnveloped(w http.ResponseWriter, r *http.Request, status int, envelope, data any, byDefault bool) {
+	w.Header().Add("Vary", "Accept, X-Response-Envelope")
+	if wantsEnvelope(r, byDefault) {
+		writeJSON(w, r, status, envelope)
+		return
+	}
+	writeJSON(w, r, status, data)
+}
+
+// writeJSON sends v as a successful JSON response with the given status.
+// Output is indented when the client asks with ?pretty=true or PRETTY_JSON
+// is on. Error responses go through writeAPIError instead and always stay
+// compact, so client error parsing never depends on the flag.
+//
+// v is encoded before anything is sent (json.Encoder buffers the whole
+// value anyway), so a value that can't be encoded still gets a proper 500.
+// Once the status is out the response can only be cut short: a failed write
+// means the client has gone away and is logged at debug level, not as a
+// server error.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if config.PrettyJSON || r.URL.Query().Get("pretty") == "true" {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(v); err != nil {
+		log.Printf("❌ Failed to encode %s %s response: %v", r.Method, r.URL.Path, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to encode response")
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(status)
+	if n, err := w.Write(buf.Bytes()); err != nil {
+		slog.Debug("response write failed", "method", r.Method, "path", r.URL.Path, "written", n, "size", buf.Len(), "error", err)
+	}
+}
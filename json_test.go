@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWantsEnvelope(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		header    string
+		accept    string
+		byDefault bool
+		want      bool
+	}{
+		{"endpoint default enveloped", nil, "", "", true, true},
+		{"endpoint default bare", nil, "", "", false, false},
+		{"header asks for envelope", nil, "true", "", false, true},
+		{"header asks for bare", nil, "false", "", true, false},
+		{"unparseable header is ignored", nil, "maybe", "", true, true},
+		{"accept profile envelope", nil, "", `application/json; profile="envelope"`, false, true},
+		{"accept profile bare", nil, "", `application/json; profile=bare`, true, false},
+		{"profile in a later accept entry", nil, "", `text/html, application/json; profile="bare"`, true, false},
+		{"unknown profile", nil, "", `application/json; profile="other"`, true, true},
+		{"header beats accept", nil, "false", `application/json; profile="envelope"`, true, false},
+		{"config beats endpoint default", map[string]string{"RESPONSE_ENVELOPE": "false"}, "", "", true, false},
+		{"config on", map[string]string{"RESPONSE_ENVELOPE": "true"}, "", "", false, true},
+		{"accept beats config", map[string]string{"RESPONSE_ENVELOPE": "true"}, "", `application/json; profile="bare"`, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testConfig(t, tt.env)
+			req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Response-Envelope", tt.header)
+			}
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			if got := wantsEnvelope(req, tt.byDefault); got != tt.want {
+				t.Errorf("wantsEnvelope = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteEnveloped(t *testing.T) {
+	testConfig(t, nil)
+	data := []string{"a", "b"}
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"true", `{"data":["a","b"]}`},
+		{"false", `["a","b"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+			req.Header.Set("X-Response-Envelope", tt.header)
+			rec := httptest.NewRecorder()
+			writeEnveloped(rec, req, http.StatusOK, dataEnvelope{data}, data, true)
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept, X-Response-Envelope" {
+				t.Errorf("Vary = %q", got)
+			}
+			if got := rec.Header().Get("Content-Type"); got != jsonContentType {
+				t.Errorf("Content-Type = %q", got)
+			}
+		})
+	}
+}
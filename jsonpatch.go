@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// jsonPatchOp is one operation of an RFC 6902 JSON Patch.
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// applyJSONPatch applies ops to user in order. Only the fields in
+// mergePatchFields can be targeted, plus single keys under /metadata; id,
+// the timestamps and anything else are rejected with a 422, as are move and
+// copy. A failed test op aborts the whole patch with a 409. It returns the
+// patched copy and the top-level fields it touched, sorted.
+func applyJSONPatch(user User, ops []jsonPatchOp) (User, []string, *apiError) {
+	// Work on a copy of the metadata so a rejected patch leaves user as is.
+	user.Metadata = mergeMetadata(user.Metadata, nil)
+	if len(user.Metadata) == 0 {
+		user.Metadata = nil
+	}
+
+	var touched []string
+	for i, op := range ops {
+		field, key, err := parseJSONPatchPath(op.Path)
+		if err != nil {
+			return user, nil, err
+		}
+		if op.Op == "add" || op.Op == "replace" || op.Op == "test" {
+			if op.Value == nil {
+				return user, nil, &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Operation %d is missing a value", i)}
+			}
+		}
+
+		switch op.Op {
+		case "test":
+			if !jsonPatchEqual(jsonPatchCurrent(user, field, key), op.Value) {
+				return user, nil, &apiError{Status: http.StatusConflict, Message: fmt.Sprintf("Test failed at path '%s'", op.Path)}
+			}
+			continue
+		case "add", "replace":
+			if err := jsonPatchSet(&user, op, field, key); err != nil {
+				return user, nil, err
+			}
+		case "remove":
+			if err := jsonPatchRemove(&user, op, field, key); err != nil {
+				return user, nil, err
+			}
+		case "move", "copy":
+			return user, nil, &apiError{Status: http.StatusUnprocessableEntity, Message: fmt.Sprintf("Operation '%s' is not supported", op.Op)}
+		default:
+			return user, nil, &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Unknown operation '%s'", op.Op)}
+		}
+		if !slices.Contains(touched, field) {
+			touched = append(touched, field)
+		}
+	}
+	slices.Sort(touched)
+	return user, touched, nil
+}
+
+// parseJSONPatchPath splits a JSON Pointer into a patchable field and, for
+// /metadata/<key>, the metadata key. key is "" for top-level paths.
+func parseJSONPatchPath(path string) (field, key string, apiErr *apiError) {
+	if !strings.HasPrefix(path, "/") {
+		return "", "", &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid path '%s'", path)}
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+
+	field = tokens[0]
+	if _, ok := mergePatchFields[field]; !ok {
+		return "", "", &apiError{Status: http.StatusUnprocessableEntity, Message: fmt.Sprintf("Path '%s' cannot be patched", path)}
+	}
+	switch {
+	case len(tokens) == 1:
+		return field, "", nil
+	case field == "metadata" && len(tokens) == 2 && tokens[1] != "":
+		return field, tokens[1], nil
+	}
+	return "", "", &apiError{Status: http.StatusUnprocessableEntity, Message: fmt.Sprintf("Path '%s' cannot be patched", path)}
+}
+
+// jsonPatchCurrent returns the current value at field (and metadata key),
+// or nil if there is none.
+func jsonPatchCurrent(user User, field, key string) any {
+	if key != "" {
+		return user.Metadata[key]
+	}
+	switch field {
+	case "username":
+		return user.Username
+	case "name":
+		return user.Name
+	case "email":
+		return user.Email
+	case "active":
+		return user.Active
+	case "metadata":
+		if user.Metadata == nil {
+			return nil
+		}
+		return map[string]any(user.Metadata)
+	}
+	return nil
+}
+
+// jsonPatchEqual compares a Go value with raw JSON the way RFC 6902 test
+// does: by JSON value rather than by encoding.
+func jsonPatchEqual(current any, raw json.RawMessage) bool {
+	var want any
+	if err := json.Unmarshal(raw, &want); err != nil {
+		return false
+	}
+	b, err := json.Marshal(current)
+	if err != nil {
+		return false
+	}
+	var got any
+	if err := json.Unmarshal(b, &got); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(got, want)
+}
+
+func jsonPatchSet(user *User, op jsonPatchOp, field, key string) *apiError {
+	isNull := string(bytes.TrimSpace(op.Value)) == "null"
+
+	if key != "" {
+		if _, exists := user.Metadata[key]; op.Op == "replace" && !exists {
+			return &apiError{Status: http.StatusUnprocessableEntity, Message: fmt.Sprintf("Path '%s' does not exist", op.Path)}
+		}
+		var v any
+		if err := json.Unmarshal(op.Value, &v); err != nil {
+			return &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for path '%s'", op.Path)}
+		}
+		if user.Metadata == nil {
+			user.Metadata = userMetadata{}
+		}
+		user.Metadata[key] = v
+		return nil
+	}
+
+	if isNull {
+		if mergePatchFields[field].clear == nil {
+			return &apiError{Status: http.StatusUnprocessableEntity, Message: fmt.Sprintf("Field '%s' cannot be null", field)}
+		}
+		mergePatchFields[field].clear(user)
+		return nil
+	}
+	// Unlike a merge patch, replacing /metadata swaps the whole object.
+	if field == "metadata" {
+		var m userMetadata
+		if err := json.Unmarshal(op.Value, &m); err != nil {
+			return &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for field '%s'", field)}
+		}
+		user.Metadata = m
+		return nil
+	}
+	if err := mergePatchFields[field].set(user, op.Value); err != nil {
+		return &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for field '%s'", field)}
+	}
+	return nil
+}
+
+// jsonPatchRemove handles remove, which only makes sense for metadata:
+// every other patchable field is required.
+func jsonPatchRemove(user *User, op jsonPatchOp, field, key string) *apiError {
+	if key != "" {
+		if _, exists := user.Metadata[key]; !exists {
+			return &apiError{Status: http.StatusUnprocessableEntity, Message: fmt.Sprintf("Path '%s' does not exist", op.Path)}
+		}
+		delete(user.Metadata, key)
+		return nil
+	}
+	if mergePatchFields[field].clear == nil {
+		return &apiError{Status: http.StatusUnprocessableEntity, Message: fmt.Sprintf("Field '%s' cannot be removed", field)}
+	}
+	mergePatchFields[field].clear(user)
+	return nil
+}
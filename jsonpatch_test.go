@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func jsonPatch(t *testing.T, body string) []jsonPatchOp {
+	t.Helper()
+	var ops []jsonPatchOp
+	if err := json.Unmarshal([]byte(body), &ops); err != nil {
+		t.Fatal(err)
+	}
+	return ops
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		want    func(*User)
+		touched []string
+	}{
+		{"replace name", `[{"op": "replace", "path": "/name", "value": "Alice B"}]`, func(u *User) { u.Name = "Alice B" }, []string{"name"}},
+		{"replace email normalizes", `[{"op": "replace", "path": "/email", "value": " ALICE@Example.org "}]`, func(u *User) { u.Email = "alice@example.org" }, []string{"email"}},
+		{"replace active", `[{"op": "replace", "path": "/active", "value": false}]`, func(u *User) { u.Active = false }, []string{"active"}},
+		{"replace metadata swaps the object", `[{"op": "replace", "path": "/metadata", "value": {"tier": 2}}]`, func(u *User) {
+			u.Metadata = userMetadata{"tier": float64(2)}
+		}, []string{"metadata"}},
+		{"add metadata key", `[{"op": "add", "path": "/metadata/tier", "value": 2}]`, func(u *User) { u.Metadata["tier"] = float64(2) }, []string{"metadata"}},
+		{"replace metadata key", `[{"op": "replace", "path": "/metadata/team", "value": "web"}]`, func(u *User) { u.Metadata["team"] = "web" }, []string{"metadata"}},
+		{"escaped metadata key", `[{"op": "add", "path": "/metadata/a~1b~0c", "value": 1}]`, func(u *User) { u.Metadata["a/b~c"] = float64(1) }, []string{"metadata"}},
+		{"remove metadata key", `[{"op": "remove", "path": "/metadata/team"}]`, func(u *User) { delete(u.Metadata, "team") }, []string{"metadata"}},
+		{"remove metadata", `[{"op": "remove", "path": "/metadata"}]`, func(u *User) { u.Metadata = nil }, []string{"metadata"}},
+		{"passing test then replace", `[
+			{"op": "test", "path": "/name", "value": "Alice"},
+			{"op": "replace", "path": "/name", "value": "Al"},
+			{"op": "replace", "path": "/active", "value": false}
+		]`, func(u *User) { u.Name, u.Active = "Al", false }, []string{"active", "name"}},
+		{"test compares JSON values", `[{"op": "test", "path": "/metadata/prefs", "value": {"lang": "en", "theme": "dark"}}]`, func(*User) {}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := patchFixture()
+			tt.want(&want)
+			got, touched, apiErr := applyJSONPatch(patchFixture(), jsonPatch(t, tt.patch))
+			if apiErr != nil {
+				t.Fatalf("error: %+v", apiErr)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("patched user = %+v, want %+v", got, want)
+			}
+			if !slices.Equal(touched, tt.touched) {
+				t.Errorf("touched = %v, want %v", touched, tt.touched)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatchRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		patch  string
+		status int
+	}{
+		{"protected path", `[{"op": "replace", "path": "/id", "value": 2}]`, http.StatusUnprocessableEntity},
+		{"timestamp", `[{"op": "replace", "path": "/created_at", "value": "2020-01-01T00:00:00Z"}]`, http.StatusUnprocessableEntity},
+		{"unknown path", `[{"op": "add", "path": "/role", "value": "admin"}]`, http.StatusUnprocessableEntity},
+		{"nested non-metadata path", `[{"op": "replace", "path": "/name/first", "value": "A"}]`, http.StatusUnprocessableEntity},
+		{"deep metadata path", `[{"op": "add", "path": "/metadata/prefs/theme", "value": "light"}]`, http.StatusUnprocessableEntity},
+		{"path without slash", `[{"op": "replace", "path": "name", "value": "A"}]`, http.StatusBadRequest},
+		{"missing value", `[{"op": "replace", "path": "/name"}]`, http.StatusBadRequest},
+		{"wrong type", `[{"op": "replace", "path": "/active", "value": "yes"}]`, http.StatusBadRequest},
+		{"replacing a missing metadata key", `[{"op": "replace", "path": "/metadata/tier", "value": 1}]`, http.StatusUnprocessableEntity},
+		{"removing a missing metadata key", `[{"op": "remove", "path": "/metadata/tier"}]`, http.StatusUnprocessableEntity},
+		{"nulling a required field", `[{"op": "replace", "path": "/name", "value": null}]`, http.StatusUnprocessableEntity},
+		{"removing a required field", `[{"op": "remove", "path": "/email"}]`, http.StatusUnprocessableEntity},
+		{"move", `[{"op": "move", "from": "/name", "path": "/username"}]`, http.StatusUnprocessableEntity},
+		{"copy", `[{"op": "copy", "from": "/name", "path": "/username"}]`, http.StatusUnprocessableEntity},
+		{"unknown op", `[{"op": "merge", "path": "/name", "value": "A"}]`, http.StatusBadRequest},
+		{"failed test aborts earlier ops", `[
+			{"op": "replace", "path": "/name", "value": "Al"},
+			{"op": "test", "path": "/name", "value": "Alice"}
+		]`, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, apiErr := applyJSONPatch(patchFixture(), jsonPatch(t, tt.patch))
+			if apiErr == nil {
+				t.Fatalf("patch accepted: %+v", got)
+			}
+			if apiErr.Status != tt.status {
+				t.Errorf("status = %d, want %d: %s", apiErr.Status, tt.status, apiErr.Message)
+			}
+		})
+	}
+}
+
+// A rejected patch must not leak changes into the caller's metadata.
+func TestApplyJSONPatchLeavesInput(t *testing.T) {
+	user := patchFixture()
+	_, _, apiErr := applyJSONPatch(user, jsonPatch(t, `[
+		{"op": "remove", "path": "/metadata/team"},
+		{"op": "replace", "path": "/id", "value": 2}
+	]`))
+	if apiErr == nil {
+		t.Fatal("patch accepted")
+	}
+	if !reflect.DeepEqual(user, patchFixture()) {
+		t.Errorf("input changed to %+v", user)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// listen opens the server's listener: a Unix domain socket when
+// LISTEN_SOCKET is set, otherwise TCP on PORT. It also returns a description
+// of the address for the startup log.
+func listen(cfg *Config) (net.Listener, string, error) {
+	if cfg.ListenSocket == "" {
+		ln, err := net.Listen("tcp", ":"+cfg.Port)
+		return ln, "http://localhost:" + cfg.Port, err
+	}
+
+	if err := removeStaleSocket(cfg.ListenSocket); err != nil {
+		return nil, "", err
+	}
+	ln, err := net.Listen("unix", cfg.ListenSocket)
+	return ln, "unix:" + cfg.ListenSocket, err
+}
+
+// removeStaleSocket deletes a socket file left behind by a previous process
+// that didn't shut down cleanly. It refuses to touch a socket something is
+// still listening on, or a path that isn't a socket at all.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is already in use by another process", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("checking %s: %v", path, err)
+	}
+	return os.Remove(path)
+}
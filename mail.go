@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EmailSender delivers email on behalf of features that need to reach a
+// user, such as confirming an email change.
+type EmailSender interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// mailer is the EmailSender chosen from the configuration at startup.
+var mailer EmailSender = logSender{}
+
+// newEmailSender builds the sender for cfg: SMTP when SMTP_HOST is set,
+// otherwise one that only logs, which is what development needs.
+func newEmailSender(cfg *Config) EmailSender {
+	if cfg.SMTP == nil {
+		return logSender{}
+	}
+	return &smtpSender{cfg: *cfg.SMTP}
+}
+
+// logSender writes messages to the log instead of sending them.
+type logSender struct{}
+
+func (logSender) Send(ctx context.Context, to, subject, body string) error {
+	log.Printf("📧 Email to %s (request %s): %s\n%s", to, requestID(ctx), subject, body)
+	return nil
+}
+
+// smtpConfig is the SMTP relay configuration. Username and Password are
+// optional; without them no AUTH is attempted.
+type smtpConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// errHeaderLineBreak rejects a recipient or subject that would end its
+// header line early and start another, such as a Bcc.
+var errHeaderLineBreak = errors.New("email recipient and subject must not contain line breaks")
+
+// smtpTimeout bounds a delivery when the caller's context has no deadline.
+const smtpTimeout = 10 * time.Second
+
+// smtpSender delivers through an SMTP relay, upgrading to TLS with
+// STARTTLS whenever the server offers it.
+type smtpSender struct {
+	cfg smtpConfig
+}
+
+func (s *smtpSender) Send(ctx context.Context, to, subject, body string) error {
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return errHeaderLineBreak
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, smtpTimeout)
+		defer cancel()
+	}
+
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if s.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.cfg.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		s.cfg.From, to, mime.QEncoding.Encode("utf-8", subject), strings.ReplaceAll(body, "\n", "\r\n"))
+	if _, err := wc.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNewEmailSender(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		smtp bool
+	}{
+		{"unconfigured logs", nil, false},
+		{"SMTP_HOST set", map[string]string{"SMTP_HOST": "mail.example", "SMTP_FROM": "noreply@example.com"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := newEmailSender(testConfig(t, tt.env))
+			if _, ok := sender.(*smtpSender); ok != tt.smtp {
+				t.Errorf("sender = %T", sender)
+			}
+		})
+	}
+}
+
+// fakeSMTP accepts one delivery on a local port and returns the port and
+// a channel yielding the commands and message it received.
+func fakeSMTP(t *testing.T, rcptReply string) (int, <-chan []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan []string, 1)
+	go func() {
+		var lines []string
+		defer func() { received <- lines }()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		reply("220 localhost ESMTP")
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); {
+			case inData:
+				if line == "." {
+					inData = false
+					reply("250 queued")
+				}
+			case cmd == "EHLO" || cmd == "HELO":
+				reply("250-localhost\r\n250 8BITMIME")
+			case cmd == "MAIL":
+				reply("250 ok")
+			case cmd == "RCPT":
+				reply(rcptReply)
+			case cmd == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 unknown")
+			}
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, received
+}
+
+func TestSMTPSender(t *testing.T) {
+	port, received := fakeSMTP(t, "250 ok")
+	sender := &smtpSender{cfg: smtpConfig{Host: "127.0.0.1", Port: port, From: "noreply@example.com"}}
+	if err := sender.Send(context.Background(), "alice@example.com", "Hello", "line one\nline two"); err != nil {
+		t.Fatal(err)
+	}
+
+	transcript := strings.Join(<-received, "\n")
+	for _, want := range []string{
+		"MAIL FROM:<noreply@example.com>",
+		"RCPT TO:<alice@example.com>",
+		"From: noreply@example.com",
+		"To: alice@example.com",
+		"Subject: Hello",
+		"line one\nline two",
+		"QUIT",
+	} {
+		if !strings.Contains(transcript, want) {
+			t.Errorf("transcript lacks %q:\n%s", want, transcript)
+		}
+	}
+}
+
+func TestSMTPSenderRejected(t *testing.T) {
+	port, _ := fakeSMTP(t, "550 no such user")
+	sender := &smtpSender{cfg: smtpConfig{Host: "127.0.0.1", Port: port, From: "noreply@example.com"}}
+	err := sender.Send(context.Background(), "nobody@example.com", "Hello", "body")
+	if err == nil || !strings.Contains(err.Error(), "550") {
+		t.Errorf("err = %v, want the 550 rejection", err)
+	}
+}
+
+func TestSMTPSenderUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	sender := &smtpSender{cfg: smtpConfig{Host: "127.0.0.1", Port: port, From: "noreply@example.com"}}
+	if err := sender.Send(context.Background(), "alice@example.com", "Hello", "body"); err == nil {
+		t.Error("Send succeeded with nothing listening on port " + strconv.Itoa(port))
+	}
+}
+
+func TestSMTPSenderRejectsLineBreaks(t *testing.T) {
+	// Nothing listens on the port: a rejected message never gets as far as
+	// dialing.
+	sender := &smtpSender{cfg: smtpConfig{Host: "127.0.0.1", Port: 1, From: "noreply@example.com"}}
+	tests := []struct {
+		name    string
+		to      string
+		subject string
+	}{
+		{"recipient", "alice@example.com\r\nBcc: mallory@example.com", "Hello"},
+		{"subject", "alice@example.com", "Hello\r\nBcc: mallory@example.com"},
+		{"bare line feed", "alice@example.com", "Hello\nBcc: mallory@example.com"},
+		{"bare carriage return", "alice@example.com", "Hello\rBcc: mallory@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := sender.Send(context.Background(), tt.to, tt.subject, "body"); !errors.Is(err, errHeaderLineBreak) {
+				t.Errorf("err = %v, want errHeaderLineBreak", err)
+			}
+		})
+	}
+}
+
+func TestSMTPSenderEncodesSubject(t *testing.T) {
+	port, received := fakeSMTP(t, "250 ok")
+	sender := &smtpSender{cfg: smtpConfig{Host: "127.0.0.1", Port: port, From: "noreply@example.com"}}
+	if err := sender.Send(context.Background(), "alice@example.com", "Grüße", "body"); err != nil {
+		t.Fatal(err)
+	}
+	transcript := strings.Join(<-received, "\n")
+	if want := "Subject: =?utf-8?q?Gr=C3=BC=C3=9Fe?="; !strings.Contains(transcript, want) {
+		t.Errorf("transcript lacks %q:\n%s", want, transcript)
+	}
+}
@@ -1,188 +1,957 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
-	"regexp"
+	"reflect"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"golang.org/x/sync/singleflight"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var db *gorm.DB
 
+// Field length limits. The email limit is the RFC 5321 maximum path length.
+const (
+	maxNameLength  = 100
+	maxEmailLength = 254
+)
+
+// shutdownTimeout bounds how long in-flight requests get to finish on exit.
+const shutdownTimeout = 15 * time.Second
+
 type User struct {
-	ID    uint   `json:"id" gorm:"primaryKey"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	UUID      string         `json:"uuid,omitempty" gorm:"type:uuid;uniqueIndex;default:gen_random_uuid()"`
+	Username  string         `json:"username" gorm:"size:30;uniqueIndex"`
+	Name      string         `json:"name" gorm:"size:100"`
+	Email     string         `json:"email" gorm:"size:254;index"`
+	Role      string         `json:"role" gorm:"size:20;not null;default:user"`
+	Active    bool           `json:"active" gorm:"not null;default:true;index"`
+	Metadata  userMetadata   `json:"metadata,omitempty" gorm:"type:jsonb"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"deleted_at,omitzero" gorm:"index"`
 }
 
 func connectDB() {
-	dsn := os.Getenv("DATABASE_URL")
-	if dsn == "" {
-		log.Fatal("❌ DATABASE_URL environment variable is not set")
+	dsn, err := databaseDSN()
+	if err != nil {
+		log.Fatalf("❌ %v", err)
 	}
 
-	fmt.Println("🔍 Connecting to DB...")
-	var err error
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	fmt.Printf("🔍 Connecting to DB (%s)...\n", describeDSN(dsn))
+	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: newGormLogger(config.LogLevel)})
 	if err != nil {
 		log.Fatalf("❌ Database connection failed: %v", err)
 	}
 
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatalf("❌ Error getting DB connection: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(config.DBMaxOpenConns)
+	sqlDB.SetMaxIdleConns(config.DBMaxIdleConns)
+	sqlDB.SetConnMaxLifetime(config.DBConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(config.DBConnMaxIdleTime)
+	if config.DBAcquireTimeout > 0 {
+		pool := &acquirePool{db: sqlDB, timeout: config.DBAcquireTimeout}
+		db.ConnPool, db.Statement.ConnPool = pool, pool
+	}
+	fmt.Printf("🔧 Connection pool: max_open=%d max_idle=%d max_lifetime=%s max_idle_time=%s\n",
+		config.DBMaxOpenConns, config.DBMaxIdleConns, config.DBConnMaxLifetime, config.DBConnMaxIdleTime)
+
 	fmt.Println("✅ Connected to PostgreSQL!")
-	db.AutoMigrate(&User{})
+
+	if config.DBWarmupConns > 0 {
+		start := time.Now()
+		opened, err := warmPool(sqlDB, config.DBWarmupConns)
+		if err != nil {
+			log.Printf("⚠️  Pool warmup opened %d of %d connections: %v", opened, config.DBWarmupConns, err)
+		} else {
+			fmt.Printf("🔥 Pool warmed up: %d connections in %s\n", opened, time.Since(start).Round(time.Millisecond))
+		}
+	}
 }
 
-func getUsers(w http.ResponseWriter, r *http.Request) {
+// warmPoolTimeout bounds the whole pool warmup.
+const warmPoolTimeout = 30 * time.Second
+
+// warmPool opens n connections at once and pings each, then hands them all
+// back to the pool as idle connections. It returns how many were opened.
+func warmPool(sqlDB *sql.DB, n int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), warmPoolTimeout)
+	defer cancel()
+
+	conns := make([]*sql.Conn, 0, n)
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	for range n {
+		c, err := sqlDB.Conn(ctx)
+		if err != nil {
+			return len(conns), err
+		}
+		if err := c.PingContext(ctx); err != nil {
+			c.Close()
+			return len(conns), err
+		}
+		conns = append(conns, c)
+	}
+	return len(conns), nil
+}
+
+// migrateSchema brings the tables of migratedModels up to date.
+func migrateSchema() {
+	fmt.Printf("🔧 Migrating models: %s\n", strings.Join(modelNames(migratedModels), ", "))
+	if err := db.AutoMigrate(migratedModels...); err != nil {
+		log.Fatalf("❌ Database migration failed: %v", err)
+	}
+	if err := migrateSearchVector(); err != nil {
+		log.Fatalf("❌ Search vector migration failed: %v", err)
+	}
+	if err := backfillUsernames(); err != nil {
+		log.Fatalf("❌ Username backfill failed: %v", err)
+	}
+	createEmailIndex()
+}
+
+// migratedModels are the models whose tables AutoMigrate keeps up to date.
+var migratedModels = []any{&User{}, &batchItemKey{}, &auditEntry{}, &pendingEmailChange{}, &userAvatar{}}
+
+func modelNames(models []any) []string {
+	names := make([]string, len(models))
+	for i, m := range models {
+		names[i] = reflect.Indirect(reflect.ValueOf(m)).Type().Name()
+	}
+	return names
+}
+
+// userPage is the envelope returned by the paginated user list. Total is
+// only filled in when it was asked for; HasMore tells either way whether
+// another page follows.
+type userPage struct {
+	Data    []User `json:"data"`
+	Total   *int64 `json:"total,omitempty"`
+	HasMore bool   `json:"has_more"`
+	Limit   int    `json:"limit"`
+	Offset  int    `json:"offset"`
+}
+
+// parsePagination reads limit and offset from the query string. A missing
+// limit falls back to the configured default and an oversized one is clamped
+// to the configured maximum.
+func parsePagination(r *http.Request) (limit, offset int, err error) {
+	limit = config.DefaultPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return 0, 0, errors.New("limit must be a positive integer")
+		}
+		if limit > config.MaxPageSize {
+			limit = config.MaxPageSize
+		}
+	}
+
+	if v := r.URL.Query().Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+	}
+
+	return limit, offset, nil
+}
+
+// applyUserFilters narrows a user query by the filters shared between the
+// list endpoints. q matches a case-insensitive substring of name or email.
+// Soft-deleted users are only included for admins asking for them; handlers
+// reject the non-admin case up front with includeDeleted.
+func applyUserFilters(query *gorm.DB, r *http.Request) *gorm.DB {
+	if r.URL.Query().Get("include_deleted") == "true" && isAdmin(r) {
+		query = query.Unscoped()
+	}
+	query = applyMetadataFilters(query, r)
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		pattern := "%" + escapeLike(q) + "%"
+		query = query.Where("name ILIKE ? OR email ILIKE ?", pattern, pattern)
+	}
+	return query
+}
+
+// escapeLike escapes the LIKE wildcards so user input is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// wantsTotal reports whether a list request asked for the total count,
+// with ?include_total=true or "Prefer: count=exact". Counting is off by
+// default because it costs a COUNT over every matching row, which on a
+// large table is far slower than fetching one page.
+func wantsTotal(r *http.Request) bool {
+	if v := r.URL.Query().Get("include_total"); v != "" {
+		return v == "true"
+	}
+	return prefersExactCount(r)
+}
+
+func prefersExactCount(r *http.Request) bool {
+	for _, pref := range strings.Split(r.Header.Get("Prefer"), ",") {
+		if strings.EqualFold(strings.TrimSpace(pref), "count=exact") {
+			return true
+		}
+	}
+	return false
+}
+
+// countUsers counts the users matching the list filters on r.
+func countUsers(ctx context.Context, r *http.Request) (int64, error) {
+	var total int64
+	err := applyUserFilters(db.WithContext(ctx).Model(&User{}), r).Count(&total).Error
+	return total, err
+}
+
+// dbReady reports whether the database has been initialized, responding
+// with a 503 if it hasn't so a misconfigured server fails cleanly instead of
+// panicking on the nil handle.
+func dbReady(w http.ResponseWriter, r *http.Request) bool {
+	if db != nil {
+		return true
+	}
+	log.Printf("❌ %s %s: database not initialized", r.Method, r.URL.Path)
+	writeError(w, r, http.StatusServiceUnavailable, "Database not initialized")
+	return false
+}
+
+// loadGroup collapses concurrent identical reads into a single DB query, so
+// a burst of the same request doesn't stampede the database.
+var loadGroup singleflight.Group
+
+// sharedLoad runs fn through loadGroup under key. The query is detached from
+// the caller's cancellation because other requests may be waiting on it;
+// instead each caller stops waiting as soon as its own request is cancelled.
+// Successful results are remembered in readCache, and served with a Warning
+// header instead of an error if the database is down on a later load.
+func sharedLoad(w http.ResponseWriter, r *http.Request, key string, fn func(ctx context.Context) (any, error)) (any, error) {
+	ctx := context.WithoutCancel(r.Context())
+	ch := loadGroup.DoChan(key, func() (any, error) {
+		v, err := fn(ctx)
+		if err == nil && readCache != nil {
+			readCache.put(key, v, time.Now())
+		}
+		return v, err
+	})
+	select {
+	case <-r.Context().Done():
+		return nil, r.Context().Err()
+	case res := <-ch:
+		if v, ok := serveStale(w, key, res.Err); ok {
+			return v, nil
+		}
+		return res.Val, res.Err
+	}
+}
+
+// requestCanceled reports whether err came from the request's context
+// ending. If the client went away there is nobody left to respond to, so it
+// is logged at debug level rather than treated as a server error; if the
+// request ran out of its time budget, or couldn't get a pooled connection
+// in DB_ACQUIRE_TIMEOUT, it gets a 503.
+func requestCanceled(w http.ResponseWriter, r *http.Request, err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, errPoolExhausted) {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, r, http.StatusServiceUnavailable, "Database is busy; try again shortly")
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		writeError(w, r, http.StatusServiceUnavailable, "Request exceeded its time budget")
+		return true
+	}
+	if !errors.Is(err, context.Canceled) {
+		return false
+	}
+	slog.Debug("request canceled by client", "method", r.Method, "path", r.URL.Path)
+	return true
+}
+
+// loadUserPage fetches one page of the user list for r, and the total
+// number of matching users if withTotal is set.
+func loadUserPage(ctx context.Context, r *http.Request, order clause.OrderBy, limit, offset int, withTotal bool) (userPage, error) {
+	page := userPage{Limit: limit, Offset: offset}
+	if withTotal {
+		total, err := countUsers(ctx, r)
+		if err != nil {
+			return page, err
+		}
+		page.Total = &total
+	}
+	// Fetch one extra row to learn whether another page follows.
+	err := applyUserFilters(db.WithContext(ctx), r).Order(order).Limit(limit + 1).Offset(offset).Find(&page.Data).Error
+	if len(page.Data) > limit {
+		page.Data, page.HasMore = page.Data[:limit], true
+	}
+	return page, err
+}
+
+// maxIDsPerRequest caps how many users can be fetched by ID in one call.
+const maxIDsPerRequest = 100
+
+// usersByID is the response for a list request that names specific IDs.
+// NotFound lists the requested IDs that didn't match a user (or were
+// excluded by the other filters).
+type usersByID struct {
+	Data     []userResponse `json:"data"`
+	NotFound []uint         `json:"not_found"`
+}
+
+// parseIDs parses the comma-separated ids parameter, dropping duplicates.
+func parseIDs(param string) ([]uint, error) {
+	parts := strings.Split(param, ",")
+	if len(parts) > maxIDsPerRequest {
+		return nil, fmt.Errorf("at most %d ids may be requested at once", maxIDsPerRequest)
+	}
+	ids := make([]uint, 0, len(parts))
+	seen := map[uint]bool{}
+	for _, p := range parts {
+		n, err := strconv.ParseUint(strings.TrimSpace(p), 10, 0)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid id %q", p)
+		}
+		if id := uint(n); !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// getUsersByIDs serves GET /api/users?ids=... in one query instead of a
+// round-trip per user.
+func getUsersByIDs(w http.ResponseWriter, r *http.Request, param string, loc *time.Location) {
+	ids, err := parseIDs(param)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	var users []User
-	if result := db.Find(&users); result.Error != nil {
-		http.Error(w, `{"error": "Failed to retrieve users"}`, http.StatusInternalServerError)
+	if err := applyUserFilters(db.WithContext(r.Context()), r).Order("id").Find(&users, ids).Error; err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve users")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
-}
+	resp := usersByID{Data: newUserResponses(users, time.Now().In(loc)), NotFound: []uint{}}
+	found := make(map[uint]bool, len(users))
+	for _, u := range users {
+		found[u.ID] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			resp.NotFound = append(resp.NotFound, id)
+		}
+	}
 
-func isValidEmail(email string) bool {
-	re := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-	return re.MatchString(email)
+	w.Header().Set("Cache-Control", config.ReadCacheControl)
+	writeEnveloped(w, r, http.StatusOK, resp, resp.Data, true)
 }
 
-func createUser(w http.ResponseWriter, r *http.Request) {
-	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+func getUsers(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	if _, ok := includeDeleted(w, r); !ok {
+		return
+	}
+	loc, ok := responseZone(w, r)
+	if !ok {
+		return
+	}
+
+	if ids := r.URL.Query().Get("ids"); ids != "" {
+		getUsersByIDs(w, r, ids, loc)
+		return
+	}
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Validation
-	if user.Name == "" {
-		http.Error(w, `{"error": "Name is required"}`, http.StatusBadRequest)
+	// HEAD only wants the count, so skip loading any rows.
+	if r.Method == http.MethodHead {
+		total, err := countUsers(r.Context(), r)
+		if requestCanceled(w, r, err) {
+			return
+		}
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "Failed to retrieve users")
+			return
+		}
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+		w.Header().Set("Content-Type", jsonContentType)
 		return
 	}
 
-	if user.Email == "" || !isValidEmail(user.Email) {
-		http.Error(w, `{"error": "Invalid email format"}`, http.StatusBadRequest)
+	order, err := parseSort(r.URL.Query().Get("sort"), config.DefaultSort)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	if result := db.Create(&user); result.Error != nil {
-		http.Error(w, `{"error": "Failed to create user"}`, http.StatusInternalServerError)
+	withTotal := wantsTotal(r)
+	key := fmt.Sprintf("users?%s&limit=%d&offset=%d&total=%t", r.URL.Query().Encode(), limit, offset, withTotal)
+	v, err := sharedLoad(w, r, key, func(ctx context.Context) (any, error) {
+		return loadUserPage(ctx, r, order, limit, offset, withTotal)
+	})
+	if requestCanceled(w, r, err) {
 		return
 	}
+	if dbUnavailable(err) {
+		writeError(w, r, http.StatusServiceUnavailable, "Database unavailable")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve users")
+		return
+	}
+	page := v.(userPage)
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(user)
+	if page.Total != nil {
+		w.Header().Set("X-Total-Count", strconv.FormatInt(*page.Total, 10))
+	}
+	if withTotal && prefersExactCount(r) {
+		w.Header().Set("Preference-Applied", "count=exact")
+	}
+	w.Header().Add("Vary", "Prefer")
+	w.Header().Set("Cache-Control", config.ReadCacheControl)
+	resp := page.response(time.Now().In(loc))
+	writeEnveloped(w, r, http.StatusOK, resp, resp.Data, true)
 }
 
-func updateUser(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	id, err := strconv.Atoi(params["id"])
+func getUser(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	id, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	includes, err := parseIncludes(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	deleted, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+	loc, ok := responseZone(w, r)
+	if !ok {
+		return
+	}
+
+	key := "user:" + strconv.FormatUint(uint64(id), 10)
+	if len(includes) > 0 {
+		key += "?include=" + strings.Join(includes, ",")
+	}
+	if deleted {
+		key += "#deleted"
+	}
+	v, err := sharedLoad(w, r, key, func(ctx context.Context) (any, error) {
+		var user User
+		tx := db.WithContext(ctx)
+		if deleted {
+			tx = tx.Unscoped()
+		}
+		err := preloadIncludes(tx, includes).First(&user, id).Error
+		return user, err
+	})
+	if requestCanceled(w, r, err) {
+		return
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+	if dbUnavailable(err) {
+		writeError(w, r, http.StatusServiceUnavailable, "Database unavailable")
+		return
+	}
 	if err != nil {
-		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve user")
+		return
+	}
+
+	w.Header().Set("Cache-Control", config.ReadCacheControl)
+	w.Header().Set("ETag", userETag(v.(User)))
+	resp := newUserResponse(v.(User), time.Now().In(loc))
+	writeEnveloped(w, r, http.StatusOK, dataEnvelope{resp}, resp, false)
+}
+
+// writeDecodeError responds with a 400 that points at the malformed part of
+// the payload when the decoder tells us which one it was. A body with no
+// JSON at all decodes to io.EOF and is reported as empty rather than
+// malformed.
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	writeAPIError(w, r, decodeAPIError(err))
+}
+
+// decodeAPIError is the error writeDecodeError sends for err, for callers
+// that add to it first.
+func decodeAPIError(err error) apiError {
+	e := apiError{Status: http.StatusBadRequest, Message: "Invalid request payload"}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		e.Status = http.StatusRequestEntityTooLarge
+		e.Message = fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit)
+	case malformedGzip(err):
+		e.Message = "Malformed gzip body"
+	case errors.Is(err, io.EOF):
+		e.Message = "Request body is empty"
+	case errors.As(err, &syntaxErr):
+		e.Message = "Malformed JSON"
+		e.Extra = map[string]any{"offset": syntaxErr.Offset}
+	case errors.As(err, &typeErr):
+		e.Message = fmt.Sprintf("Invalid value for field '%s'", typeErr.Field)
+		e.Extra = map[string]any{
+			"field":    typeErr.Field,
+			"expected": typeErr.Type.String(),
+			"offset":   typeErr.Offset,
+		}
+	}
+	return e
+}
+
+// createdUser is the response to a successful create. Warnings flag input
+// that was accepted but looks suspicious, such as a throwaway email domain.
+type createdUser struct {
+	User
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// userWarnings returns the non-blocking issues with a valid user.
+func userWarnings(user User) []string {
+	var warnings []string
+	if config.DisposableEmailDomains.contains(user.Email) {
+		warnings = append(warnings, fmt.Sprintf("Email domain '%s' is a known disposable email provider", emailDomain(user.Email)))
+	}
+	return warnings
+}
+
+func createUser(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
 		return
 	}
 
 	var user User
-	if result := db.First(&user, id); result.Error != nil {
-		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	user.Username = normalizeUsername(user.Username)
+	user.Email = normalizeEmail(user.Email)
+	// deleted_at is output-only; a client can't create a soft-deleted user.
+	// The UUID is always generated by the database, and the role starts at
+	// the default and only changes through assign-role. New users are
+	// active; PATCH deactivates them.
+	user.DeletedAt = gorm.DeletedAt{}
+	user.UUID = ""
+	user.Role = ""
+	user.Active = true
+
+	if errs := validateNewUser(user); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
 		return
 	}
 
-	var updateData User
-	if err := json.NewDecoder(r.Body).Decode(&updateData); err != nil {
-		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+	err := auditedWrite(r, auditCreated, func(tx *gorm.DB) (uint, error) {
+		err := tx.Create(&user).Error
+		return user.ID, err
+	})
+	if err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		writeSaveError(w, r, err, "Failed to create user")
 		return
 	}
+	hub.publish(userEvent{Type: eventUserCreated, User: user})
+
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, r, http.StatusCreated, createdUser{User: user, Warnings: userWarnings(user)})
+}
 
-	// Validation
-	if updateData.Name != "" && len(updateData.Name) < 3 {
-		http.Error(w, `{"error": "Name must be at least 3 characters"}`, http.StatusBadRequest)
+func updateUser(w http.ResponseWriter, r *http.Request) {
+	user, updateData, ok := decodeUserUpdate(w, r)
+	if !ok {
+		return
+	}
+	if errs := applyUserUpdate(&user, updateData); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
 		return
 	}
 
-	if updateData.Email != "" && !isValidEmail(updateData.Email) {
-		http.Error(w, `{"error": "Invalid email format"}`, http.StatusBadRequest)
+	err := auditedWrite(r, auditUpdated, func(tx *gorm.DB) (uint, error) {
+		if err := lockForUpdate(tx, r, user.ID); err != nil {
+			return 0, err
+		}
+		return user.ID, tx.Save(&user).Error
+	})
+	if err != nil {
+		writeUpdateError(w, r, err)
 		return
 	}
+	hub.publish(userEvent{Type: eventUserUpdated, User: user})
+
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, r, http.StatusOK, user)
+}
+
+// previewUserUpdate runs a PUT body through the same normalization,
+// validation and merging as updateUser and returns the result without
+// saving it, along with any non-blocking warnings. Validation failures get
+// the same 422 the update would.
+func previewUserUpdate(w http.ResponseWriter, r *http.Request) {
+	user, updateData, ok := decodeUserUpdate(w, r)
+	if !ok {
+		return
+	}
+	if errs := applyUserUpdate(&user, updateData); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, createdUser{User: user, Warnings: userWarnings(user)})
+}
+
+// decodeUserUpdate loads the user named in the path, checks If-Match and
+// decodes the update body. It has already responded when ok is false. The
+// save checks If-Match again under a row lock; see lockForUpdate.
+func decodeUserUpdate(w http.ResponseWriter, r *http.Request) (user, updateData User, ok bool) {
+	if !dbReady(w, r) {
+		return user, updateData, false
+	}
+
+	id, ok := userIDParam(w, r)
+	if !ok {
+		return user, updateData, false
+	}
+
+	if result := db.WithContext(r.Context()).First(&user, id); result.Error != nil {
+		if requestCanceled(w, r, result.Error) {
+			return user, updateData, false
+		}
+		if !ifMatch(w, r, nil) {
+			return user, updateData, false
+		}
+		writeError(w, r, http.StatusNotFound, "User not found")
+		return user, updateData, false
+	}
+	if !ifMatch(w, r, &user) {
+		return user, updateData, false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&updateData); err != nil {
+		writeDecodeError(w, r, err)
+		return user, updateData, false
+	}
+	return user, updateData, true
+}
+
+// applyUserUpdate normalizes and validates updateData, then copies the
+// fields it provides onto user. user is left unchanged when there are
+// validation errors.
+func applyUserUpdate(user *User, updateData User) []fieldError {
+	updateData.Username = normalizeUsername(updateData.Username)
+	updateData.Email = normalizeEmail(updateData.Email)
+
+	if errs := validateUserUpdate(updateData); len(errs) > 0 {
+		return errs
+	}
 
 	// Only update fields that are provided
+	if updateData.Username != "" {
+		user.Username = updateData.Username
+	}
 	if updateData.Name != "" {
 		user.Name = updateData.Name
 	}
 	if updateData.Email != "" {
 		user.Email = updateData.Email
 	}
-
-	db.Save(&user)
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+	if updateData.Metadata != nil {
+		user.Metadata = updateData.Metadata
+	}
+	return nil
 }
 
 func deleteUser(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	id, err := strconv.Atoi(params["id"])
-	if err != nil {
-		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+	if !dbReady(w, r) {
+		return
+	}
+
+	id, ok := userIDParam(w, r)
+	if !ok {
 		return
 	}
 
-	if result := db.Delete(&User{}, id); result.Error != nil {
-		http.Error(w, `{"error": "Failed to delete user"}`, http.StatusInternalServerError)
+	cascade := false
+	if v := r.URL.Query().Get("cascade"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "cascade must be true or false")
+			return
+		}
+		cascade = b
+	}
+	since, ok := ifUnmodifiedSince(w, r)
+	if !ok {
 		return
 	}
 
+	var deleted map[string]int64
+	err := auditedWrite(r, auditDeleted, func(tx *gorm.DB) (uint, error) {
+		// Lock the row so it can't change between the checks and the
+		// delete, and so a missing user is a 404 before any of its records
+		// are cascaded or counted.
+		var current User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "updated_at").First(&current, id).Error; err != nil {
+			return 0, err
+		}
+		if !since.IsZero() && modifiedSince(current, since) {
+			return 0, errModifiedSince
+		}
+
+		var err error
+		if deleted, err = deleteDependents(tx, id, cascade); err != nil {
+			return 0, err
+		}
+		result := tx.Delete(&User{}, id)
+		if result.Error != nil {
+			return 0, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return 0, gorm.ErrRecordNotFound
+		}
+		return id, nil
+	})
+	if err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, r, http.StatusNotFound, "User not found")
+			return
+		}
+		if errors.Is(err, errHasDependents) {
+			writeError(w, r, http.StatusConflict, "User has related records")
+			return
+		}
+		if errors.Is(err, errModifiedSince) {
+			writeError(w, r, http.StatusPreconditionFailed, "User has been modified since the given date")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "Failed to delete user")
+		return
+	}
+	hub.publish(userEvent{Type: eventUserDeleted, User: User{ID: id}})
+
+	if cascade {
+		writeJSON(w, r, http.StatusOK, map[string]any{"user_id": id, "deleted": deleted})
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func homeHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintln(w, "✅ Welcome to my Go API! Available endpoints: GET/POST/PUT/DELETE /api/users")
+// newRouter registers every enabled route on a new router, with the
+// middleware that needs to know the matched route. The returned table
+// records what was registered, for checking DISABLED_ENDPOINTS against.
+func newRouter(cfg *Config, limiter *rateLimiter) (*mux.Router, *routeTable) {
+	r := mux.NewRouter()
+	r.MethodNotAllowedHandler = methodNotAllowed(r)
+	r.Use(rateLimitMiddleware(limiter))
+	r.Use(authMiddleware(cfg))
+	r.Use(noStoreWrites)
+	r.Use(requestBody(cfg))
+
+	routes := newRouteTable(cfg.DisabledEndpoints)
+	routes.handle(r, "", "/", homeHandler(r), "GET")
+	routes.handle(r, "", "/healthz", healthz, "GET")
+	routes.handle(r, "", "/readyz", readyz, "GET")
+	routes.handle(r, "", "/metrics", metricsHandler, "GET")
+	routes.handle(r, "", "/api/users", getUsers, "GET", "HEAD")
+	routes.handle(r, "", "/api/users", createUser, "POST")
+	routes.handleFeature(cfg.Features.Stream, r, "", "/api/users/stream", streamUsers, "GET")
+	routes.handleFeature(cfg.Features.Events, r, "", "/api/users/events", streamUserEvents, "GET")
+	routes.handleFeature(cfg.Features.WebSocket, r, "", "/api/users/ws", subscribeUserEvents, "GET")
+	routes.handle(r, "", "/api/users/changes", getUserChanges, "GET")
+	routes.handle(r, "", "/api/users/search", searchUsers, "GET")
+	routes.handle(r, "", "/api/users/recent", getRecentUsers, "GET")
+	routes.handle(r, "", "/api/users/ids", getUserIDs, "GET")
+	routes.handle(r, "", "/api/users/stats/daily", getDailySignups, "GET")
+	routes.handle(r, "", "/api/users/stats/status", getStatusCounts, "GET")
+	routes.handle(r, "", "/api/users/by-username/{username}", getUserByUsername, "GET")
+	routes.handle(r, "", "/api/users/by-email", getUserByEmail, "GET")
+	routes.handle(r, "", "/api/users/schema", getUserSchema, "GET")
+	routes.handleFeature(cfg.Features.Batch, r, "", "/api/users/batch", createUserBatch, "POST")
+	routes.handleFeature(cfg.Features.Batch, r, "", "/api/users/batch/validate", validateUserBatch, "POST")
+	routes.handleFeature(cfg.Features.Batch, r, "", importCSVPath, importUsersCSV, "POST")
+	routes.handle(r, "", "/api/users/{id}", getUser, "GET")
+	routes.handle(r, "", "/api/users/{id}", updateUser, "PUT")
+	routes.handle(r, "", "/api/users/{id}", patchUser, "PATCH")
+	routes.handle(r, "", "/api/users/{id}", deleteUser, "DELETE")
+	routes.handle(r, "", "/api/users/{id}/preview-update", previewUserUpdate, "POST")
+	routes.handle(r, "", "/api/users/{id}/related", getRelatedCounts, "GET")
+	routes.handle(r, "", "/api/users/{id}/avatar", getAvatar, "GET")
+	routes.handle(r, "", "/api/users/{id}/avatar", putAvatar, "PUT")
+	routes.handle(r, "", "/api/users/{id}/avatar", deleteAvatar, "DELETE")
+	if local, ok := blobs.(*localStorage); ok {
+		routes.handle(r, "", blobsPath+"{key:.+}", local.serveBlob, "GET")
+	}
+	routes.handleFeature(cfg.Features.EmailChange, r, "", "/api/users/{id}/email-change", requestEmailChange, "POST")
+	routes.handleFeature(cfg.Features.EmailChange, r, "", "/api/users/{id}/email-change/confirm", confirmEmailChange, "POST")
+	routes.handleFeature(cfg.Features.AuditDiff, r, "", "/api/users/{id}/audit/diff", requireAdmin(http.HandlerFunc(getAuditDiff)).ServeHTTP, "GET")
+	routes.handle(r, "", "/api/utils/normalize-email", normalizeEmailUtil, "POST")
+
+	admin := r.PathPrefix("/api/admin").Subrouter()
+	admin.Use(requireAdmin)
+	routes.handle(admin, "/api/admin", "/db-stats", getDBStats, "GET")
+	routes.handle(admin, "/api/admin", "/audit", getAuditLog, "GET")
+	routes.handle(admin, "/api/admin", "/users/assign-role", assignRole, "POST")
+	routes.handle(admin, "/api/admin", "/broadcast", startBroadcast, "POST")
+	routes.handle(admin, "/api/admin", "/reindex", reindexSearch, "POST")
+	if cfg.Backup != nil {
+		routes.handle(admin, "/api/admin", "/backup", startBackup, "POST")
+	}
+	if cfg.AdminShutdownEnabled {
+		routes.handle(admin, "/api/admin", "/shutdown", adminShutdown, "POST")
+	}
+	return r, routes
 }
 
 func main() {
+	var err error
+	config, err = LoadConfig()
+	if err != nil {
+		log.Fatalf("❌ Invalid configuration: %v", err)
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: config.LogLevel})))
+	reporter = newErrorReporter(config)
+	mailer = newEmailSender(config)
+	blobs = newBlobStorage(config.Storage)
+	blockedEmailDomains.Store(&config.BlockedEmailDomains)
+	go reloadBlockedDomainsOnHangup()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
 	connectDB()
+	if config.AutoMigrate {
+		migrateSchema()
+	}
+	if config.CheckSchemaDrift {
+		checkSchemaDrift(config.StrictSchema)
+	}
+	detectSearchVector()
 
-	r := mux.NewRouter()
-	r.HandleFunc("/", homeHandler).Methods("GET")
-	r.HandleFunc("/api/users", getUsers).Methods("GET")
-	r.HandleFunc("/api/users", createUser).Methods("POST")
-	r.HandleFunc("/api/users/{id}", updateUser).Methods("PUT")
-	r.HandleFunc("/api/users/{id}", deleteUser).Methods("DELETE")
+	limiter := newRateLimiter(config.RateLimitDefault, config.RateLimits)
+	limiter.setMode(config.RateLimitMode)
+	go reloadRateLimitModeOnHangup(limiter)
+
+	r, routes := newRouter(config, limiter)
+
+	dups, err := duplicateRoutes(r)
+	if err != nil {
+		log.Fatalf("❌ Failed to inspect routes: %v", err)
+	}
+	if len(dups) > 0 {
+		log.Fatalf("❌ Routes registered more than once: %s", strings.Join(dups, ", "))
+	}
+	if unknown := routes.unknownDisabled(); len(unknown) > 0 {
+		log.Fatalf("❌ DISABLED_ENDPOINTS names unknown routes: %s", strings.Join(unknown, ", "))
+	}
+	if enabled := config.Features.enabled(); len(enabled) > 0 {
+		fmt.Printf("🚩 Features enabled: %s\n", strings.Join(enabled, ", "))
+	} else {
+		fmt.Println("🚩 Features enabled: none")
+	}
+	if len(config.DisabledEndpoints) > 0 {
+		fmt.Printf("🚫 Disabled endpoints: %s\n", strings.Join(config.DisabledEndpoints, ", "))
+	}
 
-	port := "8080"
 	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: r,
+		Handler:           requestIDMiddleware(config)(loggingMiddleware(recoverPanics(trailingSlash(config)(requestTimeout(config, r)(concurrencyLimiter(config)(corsMiddleware(config)(optionsAllow(r)(r)))))))),
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+	}
+	srv.RegisterOnShutdown(func() { close(streamShutdown) })
+
+	housekeepingDone := make(chan struct{})
+	stores := []evictor{limiter}
+	if config.StaleIfError > 0 {
+		readCache = newStaleCache(config.StaleIfError)
+		stores = append(stores, readCache)
+	}
+	if config.BatchKeyTTL > 0 {
+		stores = append(stores, batchKeyEvictor{ttl: config.BatchKeyTTL})
+	}
+	go runHousekeeping(config.HousekeepingInterval, housekeepingDone, stores...)
+
+	ln, addr, err := listen(config)
+	if err != nil {
+		log.Fatalf("❌ Failed to listen: %v", err)
 	}
 
 	go func() {
-		fmt.Println("🚀 Server is running on http://localhost:" + port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Println("🚀 Server is running on " + addr)
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("❌ Server failed: %v", err)
 		}
 	}()
 
+	ready.Store(true)
+
 	// Handle shutdown signals
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
-	<-stop
-	fmt.Println("\n🛑 Shutting down server gracefully...")
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt)
+		<-stop
+		requestShutdown("interrupt")
+	}()
 
-	// Close database connection
-	sqlDB, err := db.DB()
-	if err != nil {
-		log.Fatalf("❌ Error getting DB connection: %v", err)
-	}
-	sqlDB.Close()
-	fmt.Println("✅ Database connection closed")
+	gracefulShutdown(srv, housekeepingDone, <-shutdownRequested)
 }
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testConfig loads the configuration with env set on top of the process
+// environment and installs it as config for the rest of the test.
+func testConfig(t *testing.T, env map[string]string) *Config {
+	t.Helper()
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	prev := config
+	config = cfg
+	t.Cleanup(func() { config = prev })
+	return cfg
+}
+
+// testDB connects to the Postgres database named by TEST_DATABASE_URL,
+// migrates it and empties every table, then installs it as db for the rest
+// of the test. Tests that need it are skipped when the variable is unset.
+func testDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	if config == nil {
+		testConfig(t, nil)
+	}
+
+	conn, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("connecting to TEST_DATABASE_URL: %v", err)
+	}
+	sqlDB, err := conn.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev, prevFullText := db, fullTextSearch
+	db = conn
+	t.Cleanup(func() {
+		sqlDB.Close()
+		db, fullTextSearch = prev, prevFullText
+	})
+
+	if err := db.AutoMigrate(migratedModels...); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	if err := migrateSearchVector(); err != nil {
+		t.Fatalf("migrating search vector: %v", err)
+	}
+	fullTextSearch = true
+
+	var tables []string
+	for _, model := range migratedModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, stmt.Schema.Table)
+	}
+	if err := db.Exec("TRUNCATE " + strings.Join(tables, ", ") + " RESTART IDENTITY CASCADE").Error; err != nil {
+		t.Fatalf("emptying tables: %v", err)
+	}
+	// After emptying, so a test that left colliding emails behind can't
+	// keep the index from being rebuilt.
+	if err := migrateEmailIndex(); err != nil {
+		t.Fatalf("migrating email index: %v", err)
+	}
+}
+
+// dryRunDB returns a Postgres session that builds statements without
+// running them, for checking generated SQL without a database.
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	conn, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=unused.invalid"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// seedUser inserts an active user with the given username, name and email.
+func seedUser(t *testing.T, username, name, email string) User {
+	t.Helper()
+	user := User{Username: username, Name: name, Email: email, Active: true}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("seeding %s: %v", username, err)
+	}
+	return user
+}
+
+// serve runs h on a request for target with the given mux path variables
+// and body, returning the recorded response.
+func serve(h http.HandlerFunc, method, target string, vars map[string]string, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return serveRequest(h, req, vars)
+}
+
+// serveRequest runs h on req with the given mux path variables.
+func serveRequest(h http.HandlerFunc, req *http.Request, vars map[string]string) *httptest.ResponseRecorder {
+	if vars != nil {
+		req = mux.SetURLVars(req, vars)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+// decodeBody unmarshals the JSON body of rec into v.
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+}
+
+func TestDeleteUserMissing(t *testing.T) {
+	testDB(t)
+	events := hub.subscribe()
+	defer hub.unsubscribe(events)
+
+	rec := serve(deleteUser, http.MethodDelete, "/api/users/999999", map[string]string{"id": "999999"}, "", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("published %s for a user that never existed", ev.Type)
+	default:
+	}
+}
+
+func TestDeleteUserPublishes(t *testing.T) {
+	testDB(t)
+	user := seedUser(t, "alice", "Alice", "alice@example.com")
+	events := hub.subscribe()
+	defer hub.unsubscribe(events)
+
+	id := strconv.FormatUint(uint64(user.ID), 10)
+	rec := serve(deleteUser, http.MethodDelete, "/api/users/"+id, map[string]string{"id": id}, "", nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204: %s", rec.Code, rec.Body)
+	}
+	select {
+	case ev := <-events:
+		if ev.Type != eventUserDeleted || ev.User.ID != user.ID {
+			t.Errorf("published %s for user %d", ev.Type, ev.User.ID)
+		}
+	default:
+		t.Fatal("no event published")
+	}
+
+	rec = serve(deleteUser, http.MethodDelete, "/api/users/"+id, map[string]string{"id": id}, "", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("second delete: status = %d, want 404", rec.Code)
+	}
+}
+
+// Concurrent identical loads should share one run of the loader and all get
+// its result.
+func TestSharedLoadCoalesces(t *testing.T) {
+	const callers = 50
+	var calls atomic.Int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (any, error) {
+		calls.Add(1)
+		<-release
+		return "page", nil
+	}
+
+	var ready, done sync.WaitGroup
+	results := make([]any, callers)
+	for i := range callers {
+		ready.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			ready.Done()
+			req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+			v, err := sharedLoad(httptest.NewRecorder(), req, "users?coalesce-test", loader)
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = v
+		}()
+	}
+	ready.Wait()
+	// Give every caller time to join the in-flight load before it returns.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	done.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("loader ran %d times, want 1", n)
+	}
+	for i, v := range results {
+		if v != "page" {
+			t.Fatalf("caller %d got %v", i, v)
+		}
+	}
+}
+
+// A caller whose request ends stops waiting, without cancelling the load
+// the others share.
+func TestSharedLoadCallerCancel(t *testing.T) {
+	release := make(chan struct{})
+	loaderCtx := make(chan context.Context, 1)
+	loader := func(ctx context.Context) (any, error) {
+		loaderCtx <- ctx
+		<-release
+		return "page", nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/api/users", nil).WithContext(ctx)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := sharedLoad(httptest.NewRecorder(), req, "users?cancel-test", loader)
+		errc <- err
+	}()
+	lctx := <-loaderCtx
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if lctx.Err() != nil {
+		t.Error("the shared load was cancelled with its caller")
+	}
+	close(release)
+}
+
+// Every handler that touches the database should answer 503 rather than
+// panic when it has not been connected.
+func TestHandlersWithoutDB(t *testing.T) {
+	testConfig(t, nil)
+	prev := db
+	db = nil
+	t.Cleanup(func() { db = prev })
+
+	vars := map[string]string{"id": "1", "email": "a@example.com", "username": "alice"}
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		body    string
+	}{
+		{"getUsers", getUsers, http.MethodGet, ""},
+		{"getUser", getUser, http.MethodGet, ""},
+		{"createUser", createUser, http.MethodPost, `{"username": "alice", "name": "Alice", "email": "a@example.com"}`},
+		{"updateUser", updateUser, http.MethodPut, `{"name": "Alice"}`},
+		{"patchUser", patchUser, http.MethodPatch, `{"name": "Alice"}`},
+		{"deleteUser", deleteUser, http.MethodDelete, ""},
+		{"searchUsers", searchUsers, http.MethodGet, ""},
+		{"getUserByEmail", getUserByEmail, http.MethodGet, ""},
+		{"getUserByUsername", getUserByUsername, http.MethodGet, ""},
+		{"getRecentUsers", getRecentUsers, http.MethodGet, ""},
+		{"getUserIDs", getUserIDs, http.MethodGet, ""},
+		{"getStatusCounts", getStatusCounts, http.MethodGet, ""},
+		{"getAvatar", getAvatar, http.MethodGet, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.handler, tt.method, "/api/users/1?q=alice", vars, tt.body, http.Header{"Content-Type": {"application/json"}})
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Fatalf("status = %d, want 503: %s", rec.Code, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), "Database not initialized") {
+				t.Errorf("body = %s", rec.Body)
+			}
+		})
+	}
+}
+
+// Paging through users that all share the sort key should visit each one
+// exactly once, with the same boundaries on every pass.
+func TestGetUsersStablePages(t *testing.T) {
+	testDB(t)
+	const users, limit = 7, 3
+	for i := range users {
+		seedUser(t, fmt.Sprintf("user%d", i), "Same Name", fmt.Sprintf("user%d@example.com", i))
+	}
+
+	pages := func() [][]uint {
+		var ids [][]uint
+		for offset := 0; offset < users; offset += limit {
+			target := fmt.Sprintf("/api/users?sort=-name&limit=%d&offset=%d", limit, offset)
+			rec := serve(getUsers, http.MethodGet, target, nil, "", nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s: status = %d: %s", target, rec.Code, rec.Body)
+			}
+			var page userPageResponse
+			decodeBody(t, rec, &page)
+			var pageIDs []uint
+			for _, u := range page.Data {
+				pageIDs = append(pageIDs, u.ID)
+			}
+			ids = append(ids, pageIDs)
+		}
+		return ids
+	}
+
+	first := pages()
+	seen := map[uint]bool{}
+	for _, page := range first {
+		for _, id := range page {
+			if seen[id] {
+				t.Fatalf("user %d appears on more than one page: %v", id, first)
+			}
+			seen[id] = true
+		}
+	}
+	if len(seen) != users {
+		t.Fatalf("pages covered %d of %d users: %v", len(seen), users, first)
+	}
+	for range 3 {
+		if again := pages(); fmt.Sprint(again) != fmt.Sprint(first) {
+			t.Fatalf("page boundaries moved: %v then %v", first, again)
+		}
+	}
+}
+
+func TestDecodeAPIError(t *testing.T) {
+	decode := func(body string) error {
+		var user User
+		return json.NewDecoder(strings.NewReader(body)).Decode(&user)
+	}
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		message string
+	}{
+		{"empty body", decode(""), http.StatusBadRequest, "Request body is empty"},
+		{"only whitespace", decode(" \n\t"), http.StatusBadRequest, "Request body is empty"},
+		{"truncated JSON", decode(`{"name": "Al`), http.StatusBadRequest, "Invalid request payload"},
+		{"malformed JSON", decode(`{"name" "Al"}`), http.StatusBadRequest, "Malformed JSON"},
+		{"wrong type", decode(`{"name": 5}`), http.StatusBadRequest, "Invalid value for field 'name'"},
+		{"too large", &http.MaxBytesError{Limit: 1024}, http.StatusRequestEntityTooLarge, "Request body exceeds 1024 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := decodeAPIError(tt.err)
+			if e.Status != tt.status || e.Message != tt.message {
+				t.Errorf("decodeAPIError = %d %q, want %d %q", e.Status, e.Message, tt.status, tt.message)
+			}
+		})
+	}
+}
+
+// An empty body is reported as such, not as malformed JSON, by every
+// endpoint that reads a user from it.
+func TestEmptyBodies(t *testing.T) {
+	testDB(t)
+	user := seedUser(t, "alice", "Alice", "alice@example.com")
+	vars := map[string]string{"id": strconv.FormatUint(uint64(user.ID), 10)}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+	}{
+		{"createUser", createUser, http.MethodPost},
+		{"updateUser", updateUser, http.MethodPut},
+		{"patchUser", patchUser, http.MethodPatch},
+		{"createUserBatch", createUserBatch, http.MethodPost},
+		{"validateUserBatch", validateUserBatch, http.MethodPost},
+	}
+	for _, tt := range tests {
+		for _, body := range []string{"", "  \n"} {
+			t.Run(tt.name+" "+strconv.Quote(body), func(t *testing.T) {
+				rec := serve(tt.handler, tt.method, "/api/users", vars, body, http.Header{"Content-Type": {"application/json"}})
+				if rec.Code != http.StatusBadRequest {
+					t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body)
+				}
+				if !strings.Contains(rec.Body.String(), "Request body is empty") {
+					t.Errorf("body = %s", rec.Body)
+				}
+			})
+		}
+	}
+}
+
+func TestApplyUserUpdate(t *testing.T) {
+	testConfig(t, nil)
+	tests := []struct {
+		name   string
+		update User
+		want   func(*User)
+		codes  []string
+	}{
+		{"nothing provided", User{}, func(*User) {}, nil},
+		{"name only", User{Name: "Alice B"}, func(u *User) { u.Name = "Alice B" }, nil},
+		{"email and username normalized", User{Username: " Alice_B ", Email: " ALICE@Example.org "}, func(u *User) {
+			u.Username, u.Email = normalizeUsername(" Alice_B "), "alice@example.org"
+		}, nil},
+		{"metadata replaced", User{Metadata: userMetadata{"tier": 2}}, func(u *User) { u.Metadata = userMetadata{"tier": 2} }, nil},
+		{"invalid fields leave the user", User{Name: "Al", Email: "not-an-email"}, func(*User) {}, []string{codeNameTooShort, codeEmailInvalidFormat}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, want := patchFixture(), patchFixture()
+			tt.want(&want)
+			errs := applyUserUpdate(&user, tt.update)
+			if got := errorCodes(errs); !slices.Equal(got, tt.codes) {
+				t.Errorf("codes = %v, want %v", got, tt.codes)
+			}
+			if !reflect.DeepEqual(user, want) {
+				t.Errorf("user = %+v, want %+v", user, want)
+			}
+		})
+	}
+}
+
+// A preview answers like the update would but leaves the stored user
+// untouched.
+func TestPreviewUserUpdate(t *testing.T) {
+	testDB(t)
+	user := seedUser(t, "alice", "Alice", "alice@example.com")
+	id := strconv.FormatUint(uint64(user.ID), 10)
+	vars := map[string]string{"id": id}
+
+	rec := serve(previewUserUpdate, http.MethodPost, "/api/users/"+id+"/preview-update", vars, `{"name": "Alice Preview", "email": "ALICE@new.example"}`, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var preview createdUser
+	decodeBody(t, rec, &preview)
+	if preview.Name != "Alice Preview" || preview.Email != "alice@new.example" {
+		t.Errorf("preview = %+v", preview.User)
+	}
+
+	rec = serve(previewUserUpdate, http.MethodPost, "/api/users/"+id+"/preview-update", vars, `{"name": "Al"}`, nil)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("invalid preview: status = %d, want 422", rec.Code)
+	}
+
+	var stored User
+	if err := db.First(&stored, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Name != "Alice" || stored.Email != "alice@example.com" || !stored.UpdatedAt.Equal(user.UpdatedAt) {
+		t.Errorf("preview wrote to the database: %+v", stored)
+	}
+}
+
+// The router's middleware follows the config it was built from, not the
+// global one.
+func TestNewRouterUsesItsConfig(t *testing.T) {
+	testConfig(t, nil)
+	prev := db
+	db = dryRunDB(t)
+	t.Cleanup(func() { db = prev })
+
+	tests := []struct {
+		name   string
+		apply  func(cfg *Config)
+		body   string
+		status int
+	}{
+		{"auth", func(cfg *Config) {
+			cfg.AuthMode, cfg.BasicAuthUsers = authModeBasic, []basicCredential{{Username: "alice"}}
+		}, `{}`, http.StatusUnauthorized},
+		{"body limit", func(cfg *Config) { cfg.MaxBodyBytes = 16 }, `{"name": "` + strings.Repeat("a", 64) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := *config
+			tt.apply(&cfg)
+			router, _ := newRouter(&cfg, newRateLimiter(nil, nil))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(tt.body)))
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// maxMetadataBytes caps the encoded size of a user's metadata.
+const maxMetadataBytes = 4096
+
+// metadataFilterPrefix marks list query parameters that filter on a
+// metadata key, as in ?metadata.plan=pro.
+const metadataFilterPrefix = "metadata."
+
+// userMetadata is free-form key-value data on a user, stored as JSONB so
+// new attributes don't need a migration. It is always a JSON object.
+type userMetadata map[string]any
+
+// Value implements driver.Valuer; nil metadata is stored as NULL.
+func (m userMetadata) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(m)
+	return string(b), err
+}
+
+// Scan implements sql.Scanner.
+func (m *userMetadata) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	default:
+		return fmt.Errorf("cannot scan %T into metadata", src)
+	}
+}
+
+func metadataErrors(m userMetadata) []fieldError {
+	if m == nil {
+		return nil
+	}
+	if b, _ := json.Marshal(m); len(b) > maxMetadataBytes {
+		return []fieldError{{Field: "metadata", Code: codeMetadataTooLarge, Message: fmt.Sprintf("Metadata must be at most %d bytes when encoded", maxMetadataBytes)}}
+	}
+	return nil
+}
+
+// mergeMetadata applies patch to m following RFC 7396: null removes a key,
+// nested objects merge recursively, and anything else replaces the value.
+// m is left untouched.
+func mergeMetadata(m userMetadata, patch map[string]any) userMetadata {
+	merged := make(userMetadata, len(m)+len(patch))
+	for k, v := range m {
+		merged[k] = v
+	}
+	for k, v := range patch {
+		switch pv := v.(type) {
+		case nil:
+			delete(merged, k)
+		case map[string]any:
+			existing, _ := merged[k].(map[string]any)
+			merged[k] = map[string]any(mergeMetadata(existing, pv))
+		default:
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// applyMetadataFilters adds a condition for every metadata.<key>=<value>
+// parameter on r, matching the key's value as text.
+func applyMetadataFilters(query *gorm.DB, r *http.Request) *gorm.DB {
+	for param, values := range r.URL.Query() {
+		key, ok := strings.CutPrefix(param, metadataFilterPrefix)
+		if !ok || key == "" {
+			continue
+		}
+		query = query.Where("metadata ->> ? = ?", key, values[0])
+	}
+	return query
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUserMetadataJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    userMetadata
+		wantErr bool
+	}{
+		{"object", `{"metadata": {"plan": "pro", "seats": 5}}`, userMetadata{"plan": "pro", "seats": float64(5)}, false},
+		{"nested object", `{"metadata": {"prefs": {"theme": "dark"}}}`, userMetadata{"prefs": map[string]any{"theme": "dark"}}, false},
+		{"null", `{"metadata": null}`, nil, false},
+		{"absent", `{}`, nil, false},
+		{"array", `{"metadata": ["pro"]}`, nil, true},
+		{"string", `{"metadata": "pro"}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var user User
+			err := json.Unmarshal([]byte(tt.body), &user)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(user.Metadata, tt.want) {
+				t.Errorf("metadata = %#v, want %#v", user.Metadata, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserMetadataValueScan(t *testing.T) {
+	for _, m := range []userMetadata{nil, {}, {"plan": "pro", "tags": []any{"a", "b"}}} {
+		v, err := m.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back userMetadata
+		if err := back.Scan(v); err != nil {
+			t.Fatalf("scanning %v: %v", v, err)
+		}
+		if !reflect.DeepEqual(back, m) {
+			t.Errorf("round trip of %#v gave %#v", m, back)
+		}
+	}
+	var m userMetadata
+	if err := m.Scan(42); err == nil {
+		t.Error("scanned an int into metadata")
+	}
+}
+
+func TestMetadataErrors(t *testing.T) {
+	// {"k":"..."} encodes to the value's length plus 8 bytes.
+	sized := func(n int) userMetadata { return userMetadata{"k": strings.Repeat("x", n-8)} }
+	tests := []struct {
+		name string
+		m    userMetadata
+		want []string
+	}{
+		{"none", nil, nil},
+		{"small", userMetadata{"plan": "pro"}, nil},
+		{"at the cap", sized(maxMetadataBytes), nil},
+		{"over the cap", sized(maxMetadataBytes + 1), []string{codeMetadataTooLarge}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorCodes(metadataErrors(tt.m)); !slices.Equal(got, tt.want) {
+				t.Errorf("codes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeMetadata(t *testing.T) {
+	base := userMetadata{"plan": "free", "prefs": map[string]any{"theme": "dark", "lang": "en"}}
+	tests := []struct {
+		name  string
+		patch map[string]any
+		want  userMetadata
+	}{
+		{"empty patch", map[string]any{}, base},
+		{"add a key", map[string]any{"seats": 5}, userMetadata{"plan": "free", "seats": 5, "prefs": map[string]any{"theme": "dark", "lang": "en"}}},
+		{"replace a key", map[string]any{"plan": "pro"}, userMetadata{"plan": "pro", "prefs": map[string]any{"theme": "dark", "lang": "en"}}},
+		{"remove a key", map[string]any{"plan": nil}, userMetadata{"prefs": map[string]any{"theme": "dark", "lang": "en"}}},
+		{"merge a nested object", map[string]any{"prefs": map[string]any{"lang": nil, "tz": "UTC"}}, userMetadata{"plan": "free", "prefs": map[string]any{"theme": "dark", "tz": "UTC"}}},
+		{"replace an object with a scalar", map[string]any{"prefs": "none"}, userMetadata{"plan": "free", "prefs": "none"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeMetadata(base, tt.patch); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("merged = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+	if base["plan"] != "free" || len(base["prefs"].(map[string]any)) != 2 {
+		t.Errorf("mergeMetadata modified its input: %#v", base)
+	}
+}
+
+func TestApplyMetadataFilters(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		where string
+		vars  []any
+	}{
+		{"no filters", "?limit=5", "", nil},
+		{"one filter", "?metadata.plan=pro", "metadata ->> $1 = $2", []any{"plan", "pro"}},
+		{"empty key ignored", "?metadata.=pro", "", nil},
+		{"only the first value", "?metadata.plan=pro&metadata.plan=free", "metadata ->> $1 = $2", []any{"plan", "pro"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/users"+tt.query, nil)
+			var users []User
+			stmt := applyMetadataFilters(dryRunDB(t).Model(&User{}), r).Find(&users).Statement
+			sql := stmt.SQL.String()
+			if tt.where == "" {
+				if strings.Contains(sql, "metadata") {
+					t.Errorf("unexpected filter: %s", sql)
+				}
+				return
+			}
+			if !strings.Contains(sql, tt.where) || !reflect.DeepEqual(stmt.Vars, tt.vars) {
+				t.Errorf("SQL = %s %v, want %s %v", sql, stmt.Vars, tt.where, tt.vars)
+			}
+		})
+	}
+}
+
+// Metadata set on create comes back when listing by one of its keys.
+func TestMetadataFilter(t *testing.T) {
+	testDB(t)
+	for i, plan := range []string{"pro", "free", "pro"} {
+		body := fmt.Sprintf(`{"username": "user%d", "name": "User %d", "email": "user%d@example.com", "metadata": {"plan": %q}}`, i, i, i, plan)
+		if rec := serve(createUser, http.MethodPost, "/api/users", nil, body, nil); rec.Code != http.StatusCreated {
+			t.Fatalf("create: status = %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	rec := serve(getUsers, http.MethodGet, "/api/users?metadata.plan=pro", nil, "", nil)
+	var page userPageResponse
+	decodeBody(t, rec, &page)
+	if len(page.Data) != 2 {
+		t.Fatalf("filter matched %d users, want 2", len(page.Data))
+	}
+	for _, u := range page.Data {
+		if u.Metadata["plan"] != "pro" {
+			t.Errorf("user %s has metadata %v", u.Username, u.Metadata)
+		}
+	}
+}
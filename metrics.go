@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// counterVec is a Prometheus-style counter partitioned by label values. The
+// server only exports a handful of counters, which doesn't justify pulling
+// in the full client library; metricsHandler renders them in the text
+// exposition format Prometheus scrapes.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// metrics lists every counter served on /metrics.
+var metrics []*counterVec
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+	metrics = append(metrics, c)
+	return c
+}
+
+// inc increments the series identified by values, given in label order.
+func (c *counterVec) inc(values ...string) {
+	pairs := make([]string, len(c.labels))
+	for i, label := range c.labels {
+		pairs[i] = fmt.Sprintf("%s=%q", label, values[i])
+	}
+	key := strings.Join(pairs, ",")
+
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s} %g\n", c.name, k, c.values[k])
+	}
+}
+
+// metricsHandler serves every registered counter for Prometheus to scrape.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	for _, c := range metrics {
+		c.write(&b)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Flush lets streaming handlers keep flushing through the recorder.
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the WebSocket endpoint take over the connection through the
+// recorder. The upgrade is logged as a 101.
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(rec.ResponseWriter).Hijack()
+	if err == nil {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// noStoreWrites marks responses to anything but GET and HEAD as
+// uncacheable. Read handlers set their own Cache-Control on success.
+func noStoreWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// slowRequests counts requests that took longer than SLOW_REQUEST_THRESHOLD.
+var slowRequests = newCounterVec("http_slow_requests_total",
+	"Requests that took longer than SLOW_REQUEST_THRESHOLD.", "method", "status")
+
+// loggingMiddleware logs one line per request with its outcome and timing,
+// plus a warning for requests slower than SLOW_REQUEST_THRESHOLD. Streaming
+// endpoints are expected to run long and are left out of the slow check.
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		dur := time.Since(start)
+		log.Printf("%s %s %d %s %s %s", r.Method, r.URL.Path, rec.status, dur, ClientIP(r), requestID(r.Context()))
+
+		if threshold := config.SlowRequestThreshold; threshold > 0 && dur > threshold && !streamingPaths[strings.TrimRight(r.URL.Path, "/")] {
+			slog.Warn("slow request", "method", r.Method, "path", r.URL.Path, "status", rec.status, "duration", dur, "request_id", requestID(r.Context()))
+			slowRequests.inc(r.Method, strconv.Itoa(rec.status))
+		}
+	})
+}
+
+// Trailing-slash policies for TRAILING_SLASH.
+const (
+	trailingSlashMatch    = "match"
+	trailingSlashRedirect = "redirect"
+	trailingSlashStrict   = "strict"
+)
+
+// trailingSlash makes /api/users/ behave like /api/users. By default the
+// slash is stripped and the request routed directly, which keeps the method
+// and body intact. "redirect" answers with a 308 to the canonical path
+// instead (308 rather than 301 so clients repeat POSTs and PUTs as-is), and
+// "strict" leaves paths alone so the slashed form 404s.
+func trailingSlash(cfg *Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if cfg.TrailingSlash == trailingSlashStrict {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(r.URL.Path) <= 1 || !strings.HasSuffix(r.URL.Path, "/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			u := *r.URL
+			u.Path = strings.TrimRight(u.Path, "/")
+			u.RawPath = strings.TrimRight(u.RawPath, "/")
+			if u.Path == "" {
+				u.Path = "/"
+			}
+
+			if cfg.TrailingSlash == trailingSlashRedirect {
+				http.Redirect(w, r, u.RequestURI(), http.StatusPermanentRedirect)
+				return
+			}
+			r2 := r.Clone(r.Context())
+			r2.URL = &u
+			next.ServeHTTP(w, r2)
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTrailingSlash(t *testing.T) {
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		io.WriteString(w, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/api/users", echo).Methods(http.MethodGet, http.MethodPost)
+	router.HandleFunc("/", echo).Methods(http.MethodGet)
+
+	tests := []struct {
+		name     string
+		mode     string
+		method   string
+		target   string
+		status   int
+		body     string
+		location string
+	}{
+		{"match routes the slashed path", trailingSlashMatch, http.MethodGet, "/api/users/?limit=5", http.StatusOK, "GET /api/users?limit=5 ", ""},
+		{"match keeps the method and body", trailingSlashMatch, http.MethodPost, "/api/users/", http.StatusOK, "POST /api/users {}", ""},
+		{"match strips repeated slashes", trailingSlashMatch, http.MethodGet, "/api/users//", http.StatusOK, "GET /api/users ", ""},
+		{"match leaves the root alone", trailingSlashMatch, http.MethodGet, "/", http.StatusOK, "GET / ", ""},
+		{"match leaves canonical paths alone", trailingSlashMatch, http.MethodGet, "/api/users", http.StatusOK, "GET /api/users ", ""},
+		{"redirect uses 308", trailingSlashRedirect, http.MethodPost, "/api/users/?limit=5", http.StatusPermanentRedirect, "", "/api/users?limit=5"},
+		{"strict 404s", trailingSlashStrict, http.MethodGet, "/api/users/", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, map[string]string{"TRAILING_SLASH": tt.mode})
+			rec := httptest.NewRecorder()
+			body := ""
+			if tt.method == http.MethodPost {
+				body = "{}"
+			}
+			trailingSlash(cfg)(router).ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, strings.NewReader(body)))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body, tt.body)
+			}
+			if got := rec.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
+
+// slowCount returns how many slow requests have been counted for method
+// and status.
+func slowCount(method, status string) float64 {
+	slowRequests.mu.Lock()
+	defer slowRequests.mu.Unlock()
+	return slowRequests.values[`method="`+method+`",status="`+status+`"`]
+}
+
+func TestSlowRequestWarning(t *testing.T) {
+	var logs bytes.Buffer
+	prevLogger, prevOutput := slog.Default(), log.Writer()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		slog.SetDefault(prevLogger)
+		log.SetOutput(prevOutput)
+	})
+
+	tests := []struct {
+		name      string
+		threshold string
+		path      string
+		delay     time.Duration
+		slow      bool
+	}{
+		{"slow handler", "10ms", "/api/users", 30 * time.Millisecond, true},
+		{"fast handler", "1s", "/api/users", 0, false},
+		{"check off", "0", "/api/users", 30 * time.Millisecond, false},
+		{"streaming endpoint", "10ms", "/api/users/stream", 30 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testConfig(t, map[string]string{"SLOW_REQUEST_THRESHOLD": tt.threshold})
+			logs.Reset()
+			before := slowCount(http.MethodGet, "418")
+
+			h := loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.delay)
+				w.WriteHeader(http.StatusTeapot)
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			warned := strings.Contains(logs.String(), `level=WARN msg="slow request"`)
+			if warned != tt.slow {
+				t.Errorf("warned = %v, want %v: %s", warned, tt.slow, logs.String())
+			}
+			if tt.slow && !strings.Contains(logs.String(), "path="+tt.path+" status=418") {
+				t.Errorf("warning lacks the path and status: %s", logs.String())
+			}
+			counted := slowCount(http.MethodGet, "418") - before
+			if want := map[bool]float64{true: 1}[tt.slow]; counted != want {
+				t.Errorf("counter rose by %g, want %g", counted, want)
+			}
+		})
+	}
+}
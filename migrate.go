@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// runMigrate implements the migrate subcommand. It always brings the schema
+// up to date, whatever AUTO_MIGRATE says; -normalize-emails additionally
+// canonicalizes stored emails and then retries the email index, which
+// can't be built while two rows differ only by case.
+func runMigrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	normalizeEmails := fs.Bool("normalize-emails", false, "lowercase and trim existing emails")
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	fs.Parse(args)
+
+	connectDB()
+	migrateSchema()
+
+	if *normalizeEmails {
+		if err := normalizeExistingEmails(*dryRun); err != nil {
+			log.Fatalf("❌ Email normalization failed: %v", err)
+		}
+		if !*dryRun {
+			createEmailIndex()
+		}
+	}
+}
+
+// emailIndex enforces one user per email, ignoring case. Soft-deleted rows
+// keep their email, like they keep their username.
+const emailIndex = "idx_users_email_lower"
+
+// migrateEmailIndex adds the unique index on LOWER(email) on Postgres. The
+// insert or update itself fails on a taken email, so two requests saving
+// the same email at once can't both succeed; writeSaveError turns the
+// violation into a 409.
+func migrateEmailIndex() error {
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+	return db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS ` + emailIndex + ` ON users (LOWER(email))`).Error
+}
+
+// createEmailIndex runs migrateEmailIndex. Rows stored before emails were
+// normalized may still collide, which only postpones the index: the server
+// runs without it and says how to resolve the collisions.
+func createEmailIndex() {
+	err := migrateEmailIndex()
+	if _, ok := uniqueViolation(err); ok {
+		log.Printf("⚠️  Unique email index not created, existing emails collide ignoring case; run migrate -normalize-emails and resolve the collisions it reports: %v", err)
+		return
+	}
+	if err != nil {
+		log.Fatalf("❌ Email index migration failed: %v", err)
+	}
+}
+
+// emailCollision is a set of rows whose emails become identical once
+// normalized.
+type emailCollision struct {
+	Normalized string
+	IDs        string
+}
+
+// normalizeExistingEmails rewrites emails into their normalized form.
+// Emails that would collide with another row's after normalization are
+// left untouched and reported for manual resolution, since picking a winner
+// automatically could silently merge two people's accounts.
+func normalizeExistingEmails(dryRun bool) error {
+	var collisions []emailCollision
+	err := db.Unscoped().Model(&User{}).
+		Select("LOWER(TRIM(email)) AS normalized, STRING_AGG(id::text, ',' ORDER BY id) AS ids").
+		Group("LOWER(TRIM(email))").
+		Having("COUNT(*) > 1").
+		Scan(&collisions).Error
+	if err != nil {
+		return err
+	}
+
+	quarantined := make([]string, len(collisions))
+	for i, c := range collisions {
+		quarantined[i] = c.Normalized
+		log.Printf("⚠️  Email collision on %q between user IDs %s; left for manual resolution", c.Normalized, c.IDs)
+	}
+
+	pending := db.Unscoped().Model(&User{}).Where("email <> LOWER(TRIM(email))")
+	if len(quarantined) > 0 {
+		pending = pending.Where("LOWER(TRIM(email)) NOT IN ?", quarantined)
+	}
+
+	if dryRun {
+		var count int64
+		if err := pending.Count(&count).Error; err != nil {
+			return err
+		}
+		fmt.Printf("🔍 Dry run: %d email(s) would be normalized, %d collision(s) need manual resolution\n", count, len(collisions))
+		return nil
+	}
+
+	result := pending.Session(&gorm.Session{}).UpdateColumns(map[string]any{
+		"email":      gorm.Expr("LOWER(TRIM(email))"),
+		"updated_at": gorm.Expr("NOW()"),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	fmt.Printf("✅ Normalized %d email(s), %d collision(s) need manual resolution\n", result.RowsAffected, len(collisions))
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestMigrateEmailIndex(t *testing.T) {
+	testDB(t)
+	seedUser(t, "alice", "Alice", "alice@example.com")
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"same email", `{"username": "alice2", "name": "Alice", "email": "alice@example.com"}`, http.StatusConflict},
+		{"different case", `{"username": "alice3", "name": "Alice", "email": "ALICE@Example.com"}`, http.StatusConflict},
+		{"another email", `{"username": "bob", "name": "Bob", "email": "bob@example.com"}`, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(createUser, http.MethodPost, "/api/users", nil, tt.body, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusConflict {
+				return
+			}
+			var apiErr apiError
+			decodeBody(t, rec, &apiErr)
+			if codes := errorCodes(apiErr.Errors); len(codes) != 1 || codes[0] != codeEmailDuplicate {
+				t.Errorf("codes = %v, want [%s]", codes, codeEmailDuplicate)
+			}
+		})
+	}
+}
+
+// Clients racing to register the same email all pass validation, so only
+// the index can stop more than one of them from being created.
+func TestMigrateEmailIndexRace(t *testing.T) {
+	testDB(t)
+
+	const clients = 5
+	codes := make([]int, clients)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := `{"username": "racer_` + strconv.Itoa(i) + `", "name": "Racer", "email": "racer@example.com"}`
+			codes[i] = serve(createUser, http.MethodPost, "/api/users", nil, body, nil).Code
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Fatalf("unexpected status %d", code)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d users created with the same email, want exactly 1 (statuses %v)", created, codes)
+	}
+}
+
+// An index that can't be built yet because stored emails collide is
+// reported as a unique violation, which createEmailIndex logs instead of
+// refusing to start.
+func TestMigrateEmailIndexCollisions(t *testing.T) {
+	testDB(t)
+	if err := db.Exec("DROP INDEX " + emailIndex).Error; err != nil {
+		t.Fatal(err)
+	}
+	seedUser(t, "alice", "Alice", "alice@example.com")
+	seedUser(t, "alice2", "Alice", "Alice@example.com")
+
+	err := migrateEmailIndex()
+	if _, ok := uniqueViolation(err); !ok {
+		t.Fatalf("migrateEmailIndex = %v, want a unique violation", err)
+	}
+	if db.Migrator().HasIndex(&User{}, emailIndex) {
+		t.Fatal("index created despite colliding emails")
+	}
+
+	if err := normalizeExistingEmails(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Unscoped().Where("username = ?", "alice2").Delete(&User{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateEmailIndex(); err != nil {
+		t.Fatalf("migrateEmailIndex after resolving the collision: %v", err)
+	}
+}
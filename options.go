@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// allowCandidates are the methods checked when building an Allow header.
+var allowCandidates = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// allowedMethods lists the methods router has a route for at r's path, in
+// allowCandidates order. Disabled endpoints are never registered and the
+// routes of disabled features are skipped, so neither shows up.
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	var methods []string
+	for _, m := range allowCandidates {
+		probe := r.Clone(r.Context())
+		probe.Method = m
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.MatchErr == nil && !isFeatureOff(match.Route) {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+// optionsAllow answers a plain OPTIONS request with 204 and an Allow header
+// naming the methods registered for the path, or lets it fall through to a
+// 404 if there are none. CORS preflights are answered by corsMiddleware
+// before they get here, so the two don't overlap.
+func optionsAllow(router *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			methods := allowedMethods(router, r)
+			if len(methods) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Allow", strings.Join(append(methods, http.MethodOptions), ", "))
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// methodNotAllowed is the router's 405 handler. As RFC 9110 requires, it
+// names the methods that would have worked in an Allow header.
+func methodNotAllowed(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", strings.Join(append(allowedMethods(router, r), http.MethodOptions), ", "))
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	})
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+// mergePatchField describes how a merge patch key maps onto a user.
+type mergePatchField struct {
+	set func(user *User, raw json.RawMessage) error
+	// clear handles a null value; nil means the field cannot be cleared.
+	clear func(user *User)
+}
+
+// mergePatchFields lists the fields a PATCH may touch. Anything else,
+// including id and the timestamps, is rejected. Username, name and email
+// are all required, so none of them can be cleared, and neither can active.
+// Metadata merges into the stored object key by key, as RFC 7396 prescribes
+// for nested objects.
+var mergePatchFields = map[string]mergePatchField{
+	"username": {set: func(user *User, raw json.RawMessage) error {
+		err := json.Unmarshal(raw, &user.Username)
+		user.Username = normalizeUsername(user.Username)
+		return err
+	}},
+	"name": {set: func(user *User, raw json.RawMessage) error {
+		return json.Unmarshal(raw, &user.Name)
+	}},
+	"email": {set: func(user *User, raw json.RawMessage) error {
+		err := json.Unmarshal(raw, &user.Email)
+		user.Email = normalizeEmail(user.Email)
+		return err
+	}},
+	"active": {set: func(user *User, raw json.RawMessage) error {
+		return json.Unmarshal(raw, &user.Active)
+	}},
+	"metadata": {
+		set: func(user *User, raw json.RawMessage) error {
+			var patch map[string]any
+			if err := json.Unmarshal(raw, &patch); err != nil {
+				return err
+			}
+			user.Metadata = mergeMetadata(user.Metadata, patch)
+			return nil
+		},
+		clear: func(user *User) { user.Metadata = nil },
+	},
+}
+
+// Media types accepted by PATCH.
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// patchUser serves PATCH /api/users/{id}. The body is a JSON Merge Patch
+// (RFC 7396) by default, or a JSON Patch (RFC 6902) when sent as
+// application/json-patch+json. Either way only mergePatchFields can be
+// changed, and only the fields the patch touched are validated.
+func patchUser(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	mediaType := mergePatchType
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || (mt != mergePatchType && mt != jsonPatchType && mt != "application/json") {
+			w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+			writeError(w, r, http.StatusUnsupportedMediaType, "Content-Type must be application/merge-patch+json or application/json-patch+json")
+			return
+		}
+		if mt == jsonPatchType {
+			mediaType = jsonPatchType
+		}
+	}
+
+	id, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var user User
+	if result := db.WithContext(r.Context()).First(&user, id); result.Error != nil {
+		if requestCanceled(w, r, result.Error) {
+			return
+		}
+		if !ifMatch(w, r, nil) {
+			return
+		}
+		writeError(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+	if !ifMatch(w, r, &user) {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		writeDecodeError(w, r, io.EOF)
+		return
+	}
+
+	var updated User
+	var touched []string
+	var patchErr *apiError
+	if mediaType == jsonPatchType {
+		if trimmed[0] != '[' {
+			writeError(w, r, http.StatusBadRequest, "JSON Patch must be an array of operations")
+			return
+		}
+		var ops []jsonPatchOp
+		if err := json.Unmarshal(body, &ops); err != nil {
+			writeDecodeError(w, r, err)
+			return
+		}
+		updated, touched, patchErr = applyJSONPatch(user, ops)
+	} else {
+		if trimmed[0] != '{' {
+			writeError(w, r, http.StatusBadRequest, "Merge patch must be a JSON object")
+			return
+		}
+		var patch map[string]json.RawMessage
+		if err := json.Unmarshal(body, &patch); err != nil {
+			writeDecodeError(w, r, err)
+			return
+		}
+		updated, touched, patchErr = applyMergePatch(user, patch)
+	}
+	if patchErr != nil {
+		writeAPIError(w, r, *patchErr)
+		return
+	}
+	if errs := patchedFieldErrors(updated, touched); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	user = updated
+
+	err = auditedWrite(r, auditUpdated, func(tx *gorm.DB) (uint, error) {
+		if err := lockForUpdate(tx, r, user.ID); err != nil {
+			return 0, err
+		}
+		return user.ID, tx.Save(&user).Error
+	})
+	if err != nil {
+		writeUpdateError(w, r, err)
+		return
+	}
+	hub.publish(userEvent{Type: eventUserUpdated, User: user})
+
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, r, http.StatusOK, user)
+}
+
+// applyMergePatch applies a JSON Merge Patch to user: keys set to a value
+// replace the field, keys set to null clear it, and absent keys are left
+// unchanged. It returns the patched copy and the keys it touched, sorted.
+func applyMergePatch(user User, patch map[string]json.RawMessage) (User, []string, *apiError) {
+	// Apply keys in a fixed order so the first error reported is stable.
+	keys := make([]string, 0, len(patch))
+	for k := range patch {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		field, ok := mergePatchFields[key]
+		if !ok {
+			return user, nil, &apiError{Status: http.StatusUnprocessableEntity, Message: fmt.Sprintf("Field '%s' cannot be patched", key)}
+		}
+		if string(bytes.TrimSpace(patch[key])) == "null" {
+			if field.clear == nil {
+				return user, nil, &apiError{Status: http.StatusUnprocessableEntity, Message: fmt.Sprintf("Field '%s' cannot be null", key)}
+			}
+			field.clear(&user)
+			continue
+		}
+		if err := field.set(&user, patch[key]); err != nil {
+			return user, nil, &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for field '%s'", key)}
+		}
+	}
+	return user, keys, nil
+}
+
+// patchedFieldErrors validates the touched fields of a patched user;
+// untouched fields stay as stored and aren't rechecked.
+func patchedFieldErrors(updated User, touched []string) []fieldError {
+	var subset User
+	var errs []fieldError
+	for _, key := range touched {
+		switch key {
+		case "username":
+			if updated.Username == "" {
+				errs = append(errs, fieldError{Field: "username", Code: codeUsernameRequired, Message: "Username is required"})
+			}
+			subset.Username = updated.Username
+		case "name":
+			if updated.Name == "" {
+				errs = append(errs, fieldError{Field: "name", Code: codeNameRequired, Message: "Name is required"})
+			}
+			subset.Name = updated.Name
+		case "email":
+			if updated.Email == "" {
+				errs = append(errs, emailErrors(updated.Email)...)
+			}
+			subset.Email = updated.Email
+		case "metadata":
+			subset.Metadata = updated.Metadata
+		}
+	}
+	return append(errs, validateUserUpdate(subset)...)
+}
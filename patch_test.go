@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func patchFixture() User {
+	return User{
+		ID:       1,
+		Username: "alice",
+		Name:     "Alice",
+		Email:    "alice@example.com",
+		Active:   true,
+		Metadata: userMetadata{"team": "core", "prefs": map[string]any{"theme": "dark", "lang": "en"}},
+	}
+}
+
+func mergePatch(t *testing.T, body string) map[string]json.RawMessage {
+	t.Helper()
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(body), &patch); err != nil {
+		t.Fatal(err)
+	}
+	return patch
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		want    func(*User)
+		touched []string
+	}{
+		{"empty patch leaves everything", `{}`, func(*User) {}, []string{}},
+		{"set name", `{"name": "Alice B"}`, func(u *User) { u.Name = "Alice B" }, []string{"name"}},
+		{"set username normalizes", `{"username": " Alice_B "}`, func(u *User) { u.Username = normalizeUsername(" Alice_B ") }, []string{"username"}},
+		{"set email normalizes", `{"email": " ALICE@Example.org "}`, func(u *User) { u.Email = "alice@example.org" }, []string{"email"}},
+		{"set active", `{"active": false}`, func(u *User) { u.Active = false }, []string{"active"}},
+		{"clear metadata", `{"metadata": null}`, func(u *User) { u.Metadata = nil }, []string{"metadata"}},
+		{"merge metadata key by key", `{"metadata": {"team": null, "prefs": {"theme": "light"}, "tier": 2}}`, func(u *User) {
+			u.Metadata = userMetadata{"prefs": map[string]any{"theme": "light", "lang": "en"}, "tier": float64(2)}
+		}, []string{"metadata"}},
+		{"several fields at once", `{"name": "Al", "active": false}`, func(u *User) { u.Name, u.Active = "Al", false }, []string{"active", "name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := patchFixture()
+			tt.want(&want)
+			got, touched, apiErr := applyMergePatch(patchFixture(), mergePatch(t, tt.patch))
+			if apiErr != nil {
+				t.Fatalf("error: %+v", apiErr)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("patched user = %+v, want %+v", got, want)
+			}
+			if !slices.Equal(touched, tt.touched) {
+				t.Errorf("touched = %v, want %v", touched, tt.touched)
+			}
+		})
+	}
+}
+
+func TestApplyMergePatchRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		patch  string
+		status int
+	}{
+		{"unknown field", `{"role": "admin"}`, http.StatusUnprocessableEntity},
+		{"protected field", `{"id": 2}`, http.StatusUnprocessableEntity},
+		{"clearing a required field", `{"name": null}`, http.StatusUnprocessableEntity},
+		{"clearing active", `{"active": null}`, http.StatusUnprocessableEntity},
+		{"wrong type", `{"active": "yes"}`, http.StatusBadRequest},
+		{"metadata not an object", `{"metadata": [1]}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, apiErr := applyMergePatch(patchFixture(), mergePatch(t, tt.patch))
+			if apiErr == nil {
+				t.Fatalf("patch accepted: %+v", got)
+			}
+			if apiErr.Status != tt.status {
+				t.Errorf("status = %d, want %d", apiErr.Status, tt.status)
+			}
+		})
+	}
+}
+
+func TestPatchedFieldErrors(t *testing.T) {
+	testConfig(t, nil)
+	user := patchFixture()
+	user.Name = ""
+	if errs := patchedFieldErrors(user, []string{"name"}); len(errs) == 0 {
+		t.Error("an emptied name passed validation")
+	}
+	// Untouched fields are not rechecked, even if the stored value would
+	// no longer pass.
+	user = patchFixture()
+	user.Name = "Al"
+	if errs := patchedFieldErrors(user, []string{"active"}); len(errs) != 0 {
+		t.Errorf("untouched fields were validated: %v", errs)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// errPoolExhausted means no pooled connection became free within
+// DB_ACQUIRE_TIMEOUT. requestCanceled answers it with a 503 and
+// Retry-After, so clients back off rather than pile up behind the pool.
+var errPoolExhausted = errors.New("database connection pool exhausted")
+
+var poolExhaustions = newCounterVec("db_pool_exhausted_total",
+	"Queries rejected because no pooled connection became free within DB_ACQUIRE_TIMEOUT.", "operation")
+
+// acquirePool is the gorm connection pool when DB_ACQUIRE_TIMEOUT is set.
+// Each statement or transaction first takes a connection under the acquire
+// timeout and then runs on it under its own context, so waiting on a full
+// pool fails fast while a slow query still gets its whole time budget.
+type acquirePool struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+// GetDBConn lets gorm's DB() find the underlying *sql.DB.
+func (p *acquirePool) GetDBConn() (*sql.DB, error) {
+	return p.db, nil
+}
+
+// acquire takes a connection, giving up after p.timeout if the pool stays
+// full. A timeout while the pool has room is a slow dial, not exhaustion,
+// and is reported as the deadline it was.
+func (p *acquirePool) acquire(ctx context.Context, operation string) (*sql.Conn, error) {
+	acquireCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	conn, err := p.db.Conn(acquireCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+		if stats := p.db.Stats(); stats.OpenConnections >= stats.MaxOpenConnections {
+			poolExhaustions.inc(operation)
+			return nil, errPoolExhausted
+		}
+	}
+	return conn, err
+}
+
+// releaseAfter returns conn to the pool once the rows, row or transaction
+// made on it is closed. sql.Conn.Close waits for that, so it
+// runs in the background.
+func releaseAfter(conn *sql.Conn) {
+	go conn.Close()
+}
+
+func (p *acquirePool) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	conn, err := p.acquire(ctx, "exec")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.ExecContext(ctx, query, args...)
+}
+
+func (p *acquirePool) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	conn, err := p.acquire(ctx, "query")
+	if err != nil {
+		return nil, err
+	}
+	defer releaseAfter(conn)
+	return conn.QueryContext(ctx, query, args...)
+}
+
+func (p *acquirePool) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	conn, err := p.acquire(ctx, "query")
+	if err != nil {
+		// A sql.Row can't carry an error set outside database/sql, so this
+		// one falls back to waiting on the pool like any other query.
+		return p.db.QueryRowContext(ctx, query, args...)
+	}
+	defer releaseAfter(conn)
+	return conn.QueryRowContext(ctx, query, args...)
+}
+
+// PrepareContext prepares on the pool itself: a statement prepared on a
+// single Conn stops working once that Conn is released.
+func (p *acquirePool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return p.db.PrepareContext(ctx, query)
+}
+
+func (p *acquirePool) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	conn, err := p.acquire(ctx, "begin")
+	if err != nil {
+		return nil, err
+	}
+	defer releaseAfter(conn)
+	return conn.BeginTx(ctx, opts)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeConnector opens connections to nowhere, after dialDelay. They accept
+// any statement and return no rows, which is all the pool needs to be
+// filled and drained.
+type fakeConnector struct{ dialDelay time.Duration }
+
+func (c fakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	select {
+	case <-time.After(c.dialDelay):
+		return fakeConn{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c fakeConnector) Driver() driver.Driver { return nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return fakeTx{}, nil }
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error                               { return nil }
+func (fakeStmt) NumInput() int                              { return -1 }
+func (fakeStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(0), nil }
+func (fakeStmt) Query([]driver.Value) (driver.Rows, error)  { return fakeRows{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string         { return nil }
+func (fakeRows) Close() error              { return nil }
+func (fakeRows) Next([]driver.Value) error { return io.EOF }
+
+// saturatedPool returns an acquirePool of one connection, which the test
+// holds until it ends.
+func saturatedPool(t *testing.T, timeout time.Duration) *acquirePool {
+	t.Helper()
+	sqlDB := sql.OpenDB(fakeConnector{})
+	sqlDB.SetMaxOpenConns(1)
+	held, err := sqlDB.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		held.Close()
+		sqlDB.Close()
+	})
+	return &acquirePool{db: sqlDB, timeout: timeout}
+}
+
+func poolExhaustionCount(operation string) float64 {
+	poolExhaustions.mu.Lock()
+	defer poolExhaustions.mu.Unlock()
+	return poolExhaustions.values[`operation="`+operation+`"`]
+}
+
+func TestAcquirePoolExhausted(t *testing.T) {
+	tests := []struct {
+		operation string
+		run       func(*acquirePool) error
+	}{
+		{"exec", func(p *acquirePool) error {
+			_, err := p.ExecContext(context.Background(), "UPDATE users SET name = $1", "x")
+			return err
+		}},
+		{"query", func(p *acquirePool) error {
+			_, err := p.QueryContext(context.Background(), "SELECT 1")
+			return err
+		}},
+		{"begin", func(p *acquirePool) error {
+			_, err := p.BeginTx(context.Background(), nil)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.operation, func(t *testing.T) {
+			pool := saturatedPool(t, 50*time.Millisecond)
+			before := poolExhaustionCount(tt.operation)
+
+			start := time.Now()
+			err := tt.run(pool)
+			if !errors.Is(err, errPoolExhausted) {
+				t.Fatalf("err = %v, want errPoolExhausted", err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("gave up after %s, want about the 50ms acquire timeout", elapsed)
+			}
+			if got := poolExhaustionCount(tt.operation) - before; got != 1 {
+				t.Errorf("db_pool_exhausted_total{operation=%q} went up by %v, want 1", tt.operation, got)
+			}
+		})
+	}
+}
+
+// A pool with room to spare hands out connections, and a dial slower than
+// the acquire timeout is a deadline, not exhaustion.
+func TestAcquirePoolWithRoom(t *testing.T) {
+	sqlDB := sql.OpenDB(fakeConnector{})
+	sqlDB.SetMaxOpenConns(2)
+	t.Cleanup(func() { sqlDB.Close() })
+	pool := &acquirePool{db: sqlDB, timeout: 50 * time.Millisecond}
+	if _, err := pool.ExecContext(context.Background(), "SELECT 1"); err != nil {
+		t.Fatalf("exec on a free pool: %v", err)
+	}
+
+	slow := sql.OpenDB(fakeConnector{dialDelay: time.Second})
+	slow.SetMaxOpenConns(2)
+	t.Cleanup(func() { slow.Close() })
+	pool = &acquirePool{db: slow, timeout: 50 * time.Millisecond}
+	before := poolExhaustionCount("exec")
+	_, err := pool.ExecContext(context.Background(), "SELECT 1")
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errPoolExhausted) {
+		t.Errorf("slow dial: err = %v, want a deadline", err)
+	}
+	if poolExhaustionCount("exec") != before {
+		t.Error("a slow dial was counted as pool exhaustion")
+	}
+}
+
+// A read against a saturated pool gets a prompt 503 with Retry-After
+// instead of waiting out its time budget.
+func TestGetUserPoolExhausted(t *testing.T) {
+	testConfig(t, nil)
+	pool := saturatedPool(t, 50*time.Millisecond)
+	conn, err := gorm.Open(postgres.New(postgres.Config{Conn: pool.db}), &gorm.Config{DisableAutomaticPing: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.ConnPool, conn.Statement.ConnPool = pool, pool
+	prev := db
+	db = conn
+	t.Cleanup(func() { db = prev })
+
+	start := time.Now()
+	rec := serve(getUser, http.MethodGet, "/api/users/1", map[string]string{"id": "1"}, "", nil)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("answered after %s", elapsed)
+	}
+}
+
+func TestRequestCanceled(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		ctxErr     error
+		handled    bool
+		status     int
+		retryAfter string
+	}{
+		{"no error", nil, nil, false, 0, ""},
+		{"other error", errors.New("boom"), nil, false, 0, ""},
+		{"pool exhausted", errPoolExhausted, nil, true, http.StatusServiceUnavailable, "1"},
+		{"deadline", context.DeadlineExceeded, nil, true, http.StatusServiceUnavailable, ""},
+		{"request past its deadline", errors.New("conn closed"), context.DeadlineExceeded, true, http.StatusServiceUnavailable, ""},
+		{"client went away", context.Canceled, nil, true, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.ctxErr != nil {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithDeadline(ctx, time.Now())
+				defer cancel()
+			}
+			req := httptest.NewRequest(http.MethodGet, "/api/users", nil).WithContext(ctx)
+			rec := httptest.NewRecorder()
+			if got := requestCanceled(rec, req, tt.err); got != tt.handled {
+				t.Fatalf("requestCanceled = %v, want %v", got, tt.handled)
+			}
+			if tt.status != 0 && rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status == 0 && rec.Body.Len() > 0 {
+				t.Errorf("responded %d: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.retryAfter)
+			}
+		})
+	}
+}
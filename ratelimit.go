@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// rateLimit allows Limit requests per Period.
+type rateLimit struct {
+	Limit  int
+	Period time.Duration
+}
+
+var rateLimitUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// parseRateLimit parses a limit such as "100/m".
+func parseRateLimit(s string) (rateLimit, error) {
+	count, unit, ok := strings.Cut(strings.TrimSpace(s), "/")
+	period, known := rateLimitUnits[unit]
+	n, err := strconv.Atoi(count)
+	if !ok || !known || err != nil || n <= 0 {
+		return rateLimit{}, fmt.Errorf("rate limit %q must look like 100/m (units s, m or h)", s)
+	}
+	return rateLimit{Limit: n, Period: period}, nil
+}
+
+// parseRouteRateLimits parses a comma-separated list of
+// "METHOD /path/template:limit" entries, e.g. "POST /api/users:5/m".
+func parseRouteRateLimits(s string) (map[string]rateLimit, error) {
+	limits := map[string]rateLimit{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("RATE_LIMITS entry %q must look like \"POST /api/users:5/m\"", entry)
+		}
+		route := strings.Join(strings.Fields(entry[:i]), " ")
+		if len(strings.Fields(route)) != 2 {
+			return nil, fmt.Errorf("RATE_LIMITS entry %q must name a method and a path", entry)
+		}
+		limit, err := parseRateLimit(entry[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("RATE_LIMITS entry %q: %v", entry, err)
+		}
+		limits[route] = limit
+	}
+	return limits, nil
+}
+
+// Rate limit modes for RATE_LIMIT_MODE.
+const (
+	rateLimitEnforce = "enforce"
+	rateLimitMonitor = "monitor"
+)
+
+// loadRateLimitMode reads RATE_LIMIT_MODE_FILE if it is set, so the mode
+// can be changed on a running process, and RATE_LIMIT_MODE otherwise.
+func loadRateLimitMode() (string, error) {
+	mode := os.Getenv("RATE_LIMIT_MODE")
+	if path := os.Getenv("RATE_LIMIT_MODE_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("RATE_LIMIT_MODE_FILE: %v", err)
+		}
+		mode = string(b)
+	}
+	switch mode = strings.ToLower(strings.TrimSpace(mode)); mode {
+	case "":
+		return rateLimitEnforce, nil
+	case rateLimitEnforce, rateLimitMonitor:
+		return mode, nil
+	}
+	return "", fmt.Errorf("RATE_LIMIT_MODE must be %s or %s, got %q", rateLimitEnforce, rateLimitMonitor, mode)
+}
+
+// reloadRateLimitModeOnHangup re-reads the rate limit mode whenever the
+// process gets SIGHUP. A mode that fails to load is logged and the previous
+// one stays in effect.
+func reloadRateLimitModeOnHangup(rl *rateLimiter) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		mode, err := loadRateLimitMode()
+		if err != nil {
+			log.Printf("❌ Keeping previous rate limit mode: %v", err)
+			continue
+		}
+		rl.setMode(mode)
+		log.Printf("🔧 Rate limit mode: %s", mode)
+	}
+}
+
+// rateLimitExceeded counts requests over their limit, whether they were
+// rejected or, in monitor mode, let through.
+var rateLimitExceeded = newCounterVec("rate_limit_exceeded_total",
+	"Requests over their rate limit, by rule and by whether they were blocked (enforce) or allowed (monitor).", "rule", "mode")
+
+// bucketKey identifies the token bucket for one client on one route.
+type bucketKey struct {
+	ClientIP string
+	Route    string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	period time.Duration
+}
+
+// rateLimiter is a token-bucket limiter keyed by client IP and route. Routes
+// listed in RATE_LIMITS get their own limit; every other route shares the
+// RATE_LIMIT default, if one is set.
+type rateLimiter struct {
+	mu       sync.Mutex
+	fallback *rateLimit
+	routes   map[string]rateLimit
+	buckets  map[bucketKey]*bucket
+	now      func() time.Time
+	// monitor lets requests over their limit through.
+	monitor atomic.Bool
+}
+
+// setMode switches between enforcing limits and only monitoring them.
+func (rl *rateLimiter) setMode(mode string) {
+	rl.monitor.Store(mode == rateLimitMonitor)
+}
+
+func newRateLimiter(fallback *rateLimit, routes map[string]rateLimit) *rateLimiter {
+	return &rateLimiter{
+		fallback: fallback,
+		routes:   routes,
+		buckets:  map[bucketKey]*bucket{},
+		now:      time.Now,
+	}
+}
+
+// limitFor returns the limit that applies to route and the name it is
+// reported under, or false if the route is unlimited.
+func (rl *rateLimiter) limitFor(route string) (rateLimit, string, bool) {
+	if limit, ok := rl.routes[route]; ok {
+		return limit, route, true
+	}
+	if rl.fallback != nil {
+		return *rl.fallback, "default", true
+	}
+	return rateLimit{}, "", false
+}
+
+// bucketState is what a client is told about its bucket after a request.
+type bucketState struct {
+	allowed bool
+	// remaining is the number of whole tokens left.
+	remaining int
+	// reset is how long until the bucket is full again.
+	reset time.Duration
+	// retryAfter is how long until the next token, when none is left.
+	retryAfter time.Duration
+}
+
+// allow takes a token from the bucket for key and reports the bucket's
+// state afterwards.
+func (rl *rateLimiter) allow(key bucketKey, limit rateLimit) bucketState {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	perSecond := float64(limit.Limit) / limit.Period.Seconds()
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Limit), last: now, period: limit.Period}
+		rl.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Limit), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+
+	state := bucketState{}
+	if b.tokens >= 1 {
+		b.tokens--
+		state.allowed = true
+	} else {
+		state.retryAfter = time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	state.remaining = int(b.tokens)
+	state.reset = time.Duration((float64(limit.Limit) - b.tokens) / perSecond * float64(time.Second))
+	return state
+}
+
+// evictExpired drops buckets that have been idle for a full period. Such a
+// bucket has refilled completely, so forgetting it changes nothing.
+func (rl *rateLimiter) evictExpired(now time.Time) int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	evicted := 0
+	for key, b := range rl.buckets {
+		if now.Sub(b.last) >= b.period {
+			delete(rl.buckets, key)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+func (rl *rateLimiter) name() string { return "rate_limiter" }
+
+// rateLimitMiddleware rejects requests over their route's limit with 429,
+// or in monitor mode logs them and lets them through, so the effect of a new
+// limit can be watched before it is enforced. Both are counted in
+// rate_limit_exceeded_total. It must be registered with r.Use so the
+// matched route is known. Every limited response carries X-RateLimit-Limit,
+// X-RateLimit-Remaining and X-RateLimit-Reset (seconds until the bucket is
+// full) so clients can pace themselves. The limit that was hit is named in
+// X-RateLimit-Rule to make throttling easy to debug.
+func rateLimitMiddleware(rl *rateLimiter) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := routePattern(r)
+			limit, rule, ok := rl.limitFor(route)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			state := rl.allow(bucketKey{ClientIP: ClientIP(r), Route: route}, limit)
+			h := w.Header()
+			h.Set("X-RateLimit-Limit", strconv.Itoa(limit.Limit))
+			h.Set("X-RateLimit-Remaining", strconv.Itoa(state.remaining))
+			h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(state.reset.Seconds()))))
+			if !state.allowed && rl.monitor.Load() {
+				rateLimitExceeded.inc(rule, rateLimitMonitor)
+				slog.Warn("rate limit exceeded (monitor mode)", "rule", rule, "route", route, "client_ip", ClientIP(r), "request_id", requestID(r.Context()))
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !state.allowed {
+				rateLimitExceeded.inc(rule, rateLimitEnforce)
+				h.Set("Retry-After", strconv.Itoa(int(math.Ceil(state.retryAfter.Seconds()))))
+				w.Header().Set("X-RateLimit-Rule", rule)
+				writeError(w, r, http.StatusTooManyRequests, "Too many requests")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// routePattern returns the matched route as "METHOD /path/template".
+func routePattern(r *http.Request) string {
+	path := r.URL.Path
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			path = tmpl
+		}
+	}
+	return r.Method + " " + path
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// rateLimitedRouter serves GET and POST /api/users behind rl, with rl's
+// clock frozen at the returned time.
+func rateLimitedRouter(rl *rateLimiter) (*mux.Router, *time.Time) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl.now = func() time.Time { return now }
+	r := mux.NewRouter()
+	r.Use(rateLimitMiddleware(rl))
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	r.HandleFunc("/api/users", ok).Methods(http.MethodGet)
+	r.HandleFunc("/api/users", ok).Methods(http.MethodPost)
+	return r, &now
+}
+
+func send(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func TestParseRouteRateLimits(t *testing.T) {
+	limits, err := parseRouteRateLimits("POST /api/users:5/m, GET  /api/users/{id}:100/s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := limits["POST /api/users"]; got != (rateLimit{Limit: 5, Period: time.Minute}) {
+		t.Errorf("POST /api/users = %+v", got)
+	}
+	if got := limits["GET /api/users/{id}"]; got != (rateLimit{Limit: 100, Period: time.Second}) {
+		t.Errorf("GET /api/users/{id} = %+v", got)
+	}
+	for _, bad := range []string{"POST /api/users", "/api/users:5/m", "POST /api/users:5/d", "POST /api/users:0/m"} {
+		if _, err := parseRouteRateLimits(bad); err == nil {
+			t.Errorf("parseRouteRateLimits(%q) succeeded", bad)
+		}
+	}
+}
+
+// A tight write limit must not throttle reads of the same path, and
+// exhausting it must not use up the read limit.
+func TestRouteRateLimitsAreIndependent(t *testing.T) {
+	testConfig(t, nil)
+	rl := newRateLimiter(&rateLimit{Limit: 10, Period: time.Minute}, map[string]rateLimit{
+		"POST /api/users": {Limit: 2, Period: time.Minute},
+	})
+	router, _ := rateLimitedRouter(rl)
+
+	for i := range 2 {
+		if rec := send(router, http.MethodPost, "/api/users"); rec.Code != http.StatusNoContent {
+			t.Fatalf("write %d: status = %d", i+1, rec.Code)
+		}
+	}
+	rec := send(router, http.MethodPost, "/api/users")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("third write: status = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("X-RateLimit-Rule"); got != "POST /api/users" {
+		t.Errorf("X-RateLimit-Rule = %q", got)
+	}
+
+	for i := range 10 {
+		rec := send(router, http.MethodGet, "/api/users")
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("read %d after writes were limited: status = %d", i+1, rec.Code)
+		}
+	}
+	if rec := send(router, http.MethodGet, "/api/users"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("eleventh read: status = %d, want 429 from the default limit", rec.Code)
+	}
+}
+
+func TestRateLimitRefills(t *testing.T) {
+	testConfig(t, nil)
+	rl := newRateLimiter(&rateLimit{Limit: 1, Period: time.Second}, nil)
+	router, now := rateLimitedRouter(rl)
+
+	send(router, http.MethodGet, "/api/users")
+	if rec := send(router, http.MethodGet, "/api/users"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	*now = now.Add(time.Second)
+	if rec := send(router, http.MethodGet, "/api/users"); rec.Code != http.StatusNoContent {
+		t.Errorf("after a full period: status = %d, want 204", rec.Code)
+	}
+}
+
+// The headers count down with each request and keep reporting the empty
+// bucket once it is throttled.
+func TestRateLimitHeaders(t *testing.T) {
+	testConfig(t, nil)
+	rl := newRateLimiter(&rateLimit{Limit: 3, Period: time.Minute}, nil)
+	router, now := rateLimitedRouter(rl)
+
+	tests := []struct {
+		advance    time.Duration
+		status     int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		{0, http.StatusNoContent, "2", "20", ""},
+		{0, http.StatusNoContent, "1", "40", ""},
+		{0, http.StatusNoContent, "0", "60", ""},
+		{0, http.StatusTooManyRequests, "0", "60", "20"},
+		{5 * time.Second, http.StatusTooManyRequests, "0", "55", "15"},
+		{15 * time.Second, http.StatusNoContent, "0", "60", ""},
+		{20 * time.Second, http.StatusNoContent, "0", "60", ""},
+	}
+	for i, tt := range tests {
+		*now = now.Add(tt.advance)
+		rec := send(router, http.MethodGet, "/api/users")
+		h := rec.Header()
+		got := []string{h.Get("X-RateLimit-Limit"), h.Get("X-RateLimit-Remaining"), h.Get("X-RateLimit-Reset"), h.Get("Retry-After")}
+		want := []string{"3", tt.remaining, tt.reset, tt.retryAfter}
+		if rec.Code != tt.status || fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("request %d: %d with limit, remaining, reset, retry-after %q, want %d with %q", i+1, rec.Code, got, tt.status, want)
+		}
+	}
+}
+
+func TestLoadRateLimitMode(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mode")
+	if err := os.WriteFile(file, []byte(" Monitor\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"default", nil, rateLimitEnforce, false},
+		{"enforce", map[string]string{"RATE_LIMIT_MODE": "enforce"}, rateLimitEnforce, false},
+		{"monitor, any case", map[string]string{"RATE_LIMIT_MODE": "MONITOR"}, rateLimitMonitor, false},
+		{"file wins", map[string]string{"RATE_LIMIT_MODE": "enforce", "RATE_LIMIT_MODE_FILE": file}, rateLimitMonitor, false},
+		{"unknown mode", map[string]string{"RATE_LIMIT_MODE": "warn"}, "", true},
+		{"missing file", map[string]string{"RATE_LIMIT_MODE_FILE": file + ".missing"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("RATE_LIMIT_MODE", "")
+			t.Setenv("RATE_LIMIT_MODE_FILE", "")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			got, err := loadRateLimitMode()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("mode = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func exceededCount(rule, mode string) float64 {
+	rateLimitExceeded.mu.Lock()
+	defer rateLimitExceeded.mu.Unlock()
+	return rateLimitExceeded.values[`rule="`+rule+`",mode="`+mode+`"`]
+}
+
+// captureLogs sends slog output to the returned buffer for the rest of the
+// test, and discards the standard logger's.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var logs bytes.Buffer
+	prevLogger, prevOutput := slog.Default(), log.Writer()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		slog.SetDefault(prevLogger)
+		log.SetOutput(prevOutput)
+	})
+	return &logs
+}
+
+func TestRateLimitModes(t *testing.T) {
+	testConfig(t, nil)
+	tests := []struct {
+		mode   string
+		status int
+		logged bool
+	}{
+		{rateLimitEnforce, http.StatusTooManyRequests, false},
+		{rateLimitMonitor, http.StatusNoContent, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			logs := captureLogs(t)
+			rl := newRateLimiter(&rateLimit{Limit: 1, Period: time.Minute}, nil)
+			rl.setMode(tt.mode)
+			router, _ := rateLimitedRouter(rl)
+			before := exceededCount("default", tt.mode)
+
+			if rec := send(router, http.MethodGet, "/api/users"); rec.Code != http.StatusNoContent {
+				t.Fatalf("first request: status = %d", rec.Code)
+			}
+			rec := send(router, http.MethodGet, "/api/users")
+			if rec.Code != tt.status {
+				t.Errorf("over the limit: status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("X-RateLimit-Remaining"); got != "0" {
+				t.Errorf("X-RateLimit-Remaining = %q, want 0", got)
+			}
+			if got := exceededCount("default", tt.mode) - before; got != 1 {
+				t.Errorf("rate_limit_exceeded_total{mode=%q} went up by %v, want 1", tt.mode, got)
+			}
+			if logged := strings.Contains(logs.String(), "rate limit exceeded (monitor mode)"); logged != tt.logged {
+				t.Errorf("warning logged = %v, want %v: %s", logged, tt.logged, logs)
+			}
+		})
+	}
+}
+
+func TestRateLimitModeReload(t *testing.T) {
+	testConfig(t, nil)
+	file := filepath.Join(t.TempDir(), "mode")
+	if err := os.WriteFile(file, []byte("monitor"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RATE_LIMIT_MODE_FILE", file)
+	captureLogs(t)
+	rl := newRateLimiter(&rateLimit{Limit: 1, Period: time.Minute}, nil)
+	go reloadRateLimitModeOnHangup(rl)
+	// Give the goroutine time to register for SIGHUP, which would otherwise
+	// kill the test binary.
+	time.Sleep(50 * time.Millisecond)
+
+	hangupUntil := func(wait time.Duration, monitor bool) bool {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+		for deadline := time.Now().Add(wait); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if rl.monitor.Load() == monitor {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !hangupUntil(2*time.Second, true) {
+		t.Fatal("monitor mode not picked up on SIGHUP")
+	}
+	if err := os.WriteFile(file, []byte("enforce"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if !hangupUntil(2*time.Second, false) {
+		t.Fatal("enforce mode not picked up on SIGHUP")
+	}
+	if err := os.WriteFile(file, []byte("bogus"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	hangupUntil(100*time.Millisecond, true)
+	if rl.monitor.Load() {
+		t.Error("an invalid mode replaced the previous one")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultRecentLimit is how many users /api/users/recent returns by default.
+const defaultRecentLimit = 10
+
+// getRecentUsers serves GET /api/users/recent: the most recently created
+// users, newest first. limit defaults to 10 and is capped at MAX_PAGE_SIZE.
+func getRecentUsers(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	limit := defaultRecentLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, r, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(n, config.MaxPageSize)
+	}
+	loc, ok := responseZone(w, r)
+	if !ok {
+		return
+	}
+
+	users := []User{}
+	if err := db.WithContext(r.Context()).Order("created_at DESC, id DESC").Limit(limit).Find(&users).Error; err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve users")
+		return
+	}
+
+	w.Header().Set("Cache-Control", config.ReadCacheControl)
+	resp := newUserResponses(users, time.Now().In(loc))
+	writeEnveloped(w, r, http.StatusOK, dataEnvelope{resp}, resp, false)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// recoverPanics turns a panicking handler into a 500 instead of a dropped
+// connection, and hands the panic to the error reporter. http.ErrAbortHandler
+// is re-raised since it is net/http's way of aborting a response on purpose.
+// With SENTRY_REPORT_5XX, ordinary 5xx responses are reported too.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			stack := string(debug.Stack())
+			slog.Error("panic serving request", "method", r.Method, "path", r.URL.Path, "request_id", requestID(r.Context()), "panic", v, "stack", stack)
+			reporter.Report(errorReport{
+				Message:   fmt.Sprintf("panic: %v", v),
+				Stack:     stack,
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				RequestID: requestID(r.Context()),
+				Status:    http.StatusInternalServerError,
+			})
+			writeError(w, r, http.StatusInternalServerError, "Internal server error")
+		}()
+
+		next.ServeHTTP(rec, r)
+
+		if config.SentryReport5xx && rec.status >= 500 {
+			reporter.Report(errorReport{
+				Message:   fmt.Sprintf("%d %s", rec.status, http.StatusText(rec.status)),
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				RequestID: requestID(r.Context()),
+				Status:    rec.status,
+			})
+		}
+	})
+}
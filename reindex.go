@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// reindexPath is the search reindex endpoint. It runs for as long as the
+// table takes, bounded by STREAM_MAX_LIFETIME instead of the route timeout.
+const reindexPath = "/api/admin/reindex"
+
+// reindexBatchSize is how many users are rewritten per statement, and so
+// how long each batch holds its row locks.
+const reindexBatchSize = 1000
+
+// reindexEvent is one line of the NDJSON reindex response. Type is
+// "progress" after each batch and "summary" last. A summary with Error set
+// means the reindex stopped early; it can be resumed with
+// ?after_id=ThroughID.
+type reindexEvent struct {
+	Type      string `json:"type"`
+	Users     int    `json:"users"`
+	ThroughID uint   `json:"through_id"`
+	Error     string `json:"error,omitempty"`
+}
+
+// reindexSearch serves POST /api/admin/reindex. The search vector is a
+// generated column, so it only goes stale when what it is computed with
+// changes underneath it, a text search dictionary for instance. Every user,
+// soft-deleted ones included, is rewritten in place in ID order, so
+// Postgres recomputes the vector without any column changing. Each batch
+// commits on its own, so concurrent writes wait for one batch at most, and
+// rewriting a row twice is harmless, so the reindex can be re-run or
+// resumed with ?after_id= at will. Progress streams back as NDJSON.
+func reindexSearch(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+	if !fullTextSearch {
+		writeError(w, r, http.StatusConflict, "users."+searchVectorColumn+" is missing; run migrate first")
+		return
+	}
+
+	var afterID uint
+	if v := r.URL.Query().Get("after_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "after_id must be a user ID")
+			return
+		}
+		afterID = uint(id)
+	}
+
+	ctx, cancel := streamContext(r)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	log.Printf("🔎 Search reindex started by %s after user %d", authUser(r), afterID)
+	summary := reindexEvent{Type: "summary", ThroughID: afterID}
+	for {
+		var ids []uint
+		err := db.WithContext(ctx).Raw(`UPDATE users SET name = name
+			WHERE id IN (SELECT id FROM users WHERE id > ? ORDER BY id LIMIT ?)
+			RETURNING id`, summary.ThroughID, reindexBatchSize).Scan(&ids).Error
+		if err != nil {
+			log.Printf("❌ Search reindex failed after user %d: %v", summary.ThroughID, err)
+			summary.Error = "Reindex stopped; resume with after_id=" + strconv.FormatUint(uint64(summary.ThroughID), 10)
+			break
+		}
+		if len(ids) == 0 {
+			break
+		}
+		summary.Users += len(ids)
+		for _, id := range ids {
+			summary.ThroughID = max(summary.ThroughID, id)
+		}
+		enc.Encode(reindexEvent{Type: "progress", Users: summary.Users, ThroughID: summary.ThroughID})
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if len(ids) < reindexBatchSize {
+			break
+		}
+	}
+	if summary.Error == "" {
+		log.Printf("✅ Search reindex finished: %d users through user %d", summary.Users, summary.ThroughID)
+	}
+	enc.Encode(summary)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// reindexEvents runs a reindex as the admin root and decodes the NDJSON
+// lines it streams back.
+func reindexEvents(t *testing.T, query string) []reindexEvent {
+	t.Helper()
+	rec := runReindex("root", query)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reindex: status = %d: %s", rec.Code, rec.Body)
+	}
+	var events []reindexEvent
+	dec := json.NewDecoder(rec.Body)
+	for dec.More() {
+		var ev reindexEvent
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatalf("decoding %s: %v", rec.Body, err)
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func runReindex(user, query string) *httptest.ResponseRecorder {
+	req := withAuthUser(httptest.NewRequest(http.MethodPost, reindexPath+query, nil), user)
+	rec := httptest.NewRecorder()
+	requireAdmin(http.HandlerFunc(reindexSearch)).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestReindexSearchRejects(t *testing.T) {
+	testConfig(t, map[string]string{"ADMIN_USERS": "root"})
+	prev, prevFullText := db, fullTextSearch
+	db = dryRunDB(t)
+	t.Cleanup(func() { db, fullTextSearch = prev, prevFullText })
+
+	tests := []struct {
+		name     string
+		user     string
+		query    string
+		fullText bool
+		status   int
+	}{
+		{"anonymous", "", "", true, http.StatusForbidden},
+		{"not an admin", "alice", "", true, http.StatusForbidden},
+		{"no search vector", "root", "", false, http.StatusConflict},
+		{"malformed after_id", "root", "?after_id=last", true, http.StatusBadRequest},
+		{"negative after_id", "root", "?after_id=-1", true, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fullTextSearch = tt.fullText
+			if rec := runReindex(tt.user, tt.query); rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+// The reindex rewrites every row in batches without changing any column,
+// so it can be run again or resumed, and search keeps working.
+func TestReindexSearch(t *testing.T) {
+	testConfig(t, map[string]string{"ADMIN_USERS": "root"})
+	testDB(t)
+	const users = reindexBatchSize + reindexBatchSize/2
+	err := db.Exec(`INSERT INTO users (username, name, email, created_at, updated_at)
+		SELECT 'user_' || n, 'User ' || n, 'user' || n || '@example.com', NOW() - INTERVAL '1 day', NOW() - INTERVAL '1 day'
+		FROM generate_series(1, ?) AS n`, users-1).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone := seedUser(t, "alice", "Alice Liddell", "alice@wonderland.example")
+	if err := db.Delete(&gone).Error; err != nil {
+		t.Fatal(err)
+	}
+	var before User
+	if err := db.First(&before, 1).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	want := []reindexEvent{
+		{Type: "progress", Users: reindexBatchSize, ThroughID: reindexBatchSize},
+		{Type: "progress", Users: users, ThroughID: users},
+		{Type: "summary", Users: users, ThroughID: users},
+	}
+	for run := range 2 {
+		if got := reindexEvents(t, ""); !slices.Equal(got, want) {
+			t.Fatalf("run %d: events = %+v, want %+v", run+1, got, want)
+		}
+	}
+
+	resumed := reindexEvents(t, "?after_id="+strconv.Itoa(reindexBatchSize))
+	if last := resumed[len(resumed)-1]; last.Users != users-reindexBatchSize || last.ThroughID != users || last.Error != "" {
+		t.Errorf("resumed summary = %+v, want %d users through %d", last, users-reindexBatchSize, users)
+	}
+
+	var after User
+	if err := db.First(&after, 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	if after.Name != before.Name || !after.UpdatedAt.Equal(before.UpdatedAt) {
+		t.Errorf("user 1 changed: %q at %v, was %q at %v", after.Name, after.UpdatedAt.Format(time.RFC3339Nano), before.Name, before.UpdatedAt.Format(time.RFC3339Nano))
+	}
+	if got := searchUsernames(t, "user 42"); !slices.Contains(got, "user_42") {
+		t.Errorf("search after reindex = %v, want user_42 among them", got)
+	}
+	if got := searchUsernames(t, "wonderland"); len(got) != 0 {
+		t.Errorf("search after reindex found the deleted user: %v", got)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+)
+
+// userRelation is a table whose rows belong to a user through column.
+type userRelation struct {
+	// Name is the key the relation is reported under.
+	Name   string
+	Model  any
+	Column string
+	// Dependent records block a plain delete of their user and are removed
+	// by a cascading one. The others outlive the user.
+	Dependent bool
+}
+
+// userRelations lists every kind of record tied to a user. A new model that
+// references users only needs an entry here to show up in /related. Audit
+// entries are history, and batch keys must keep pointing at deleted users
+// so a replay doesn't recreate them, so neither is dependent.
+var userRelations = []userRelation{
+	{Name: "audit_entries", Model: &auditEntry{}, Column: "user_id"},
+	{Name: "avatars", Model: &userAvatar{}, Column: "user_id"},
+	{Name: "batch_keys", Model: &batchItemKey{}, Column: "user_id"},
+	{Name: "email_changes", Model: &pendingEmailChange{}, Column: "user_id", Dependent: true},
+}
+
+// errHasDependents is returned when a user with dependent records is deleted
+// without cascading.
+var errHasDependents = errors.New("user has dependent records")
+
+// deleteDependents removes the user's dependent records within tx and
+// reports how many of each went. Without cascade it only checks that there
+// are none, returning errHasDependents otherwise.
+func deleteDependents(tx *gorm.DB, id uint, cascade bool) (map[string]int64, error) {
+	deleted := map[string]int64{}
+	for _, rel := range userRelations {
+		if !rel.Dependent {
+			continue
+		}
+		if !cascade {
+			var n int64
+			if err := tx.Model(rel.Model).Where(rel.Column+" = ?", id).Count(&n).Error; err != nil {
+				return nil, err
+			}
+			if n > 0 {
+				return nil, errHasDependents
+			}
+			continue
+		}
+		result := tx.Where(rel.Column+" = ?", id).Delete(rel.Model)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		deleted[rel.Name] = result.RowsAffected
+	}
+	return deleted, nil
+}
+
+// getRelatedCounts serves GET /api/users/{id}/related: how many records of
+// each relation belong to the user, so a client can tell what a delete
+// would affect. The counts run in parallel and a failure in one cancels
+// the rest.
+func getRelatedCounts(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	id, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var user User
+	if result := db.WithContext(r.Context()).Select("id").First(&user, id); result.Error != nil {
+		if requestCanceled(w, r, result.Error) {
+			return
+		}
+		writeError(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+
+	counts := make([]int64, len(userRelations))
+	g, ctx := errgroup.WithContext(r.Context())
+	for i, rel := range userRelations {
+		g.Go(func() error {
+			return db.WithContext(ctx).Model(rel.Model).Where(rel.Column+" = ?", id).Count(&counts[i]).Error
+		})
+	}
+	if err := g.Wait(); err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "Failed to count related records")
+		return
+	}
+
+	related := make(map[string]int64, len(userRelations))
+	for i, rel := range userRelations {
+		related[rel.Name] = counts[i]
+	}
+	writeJSON(w, r, http.StatusOK, map[string]any{"user_id": id, "related": related})
+}
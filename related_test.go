@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func seedEmailChange(t *testing.T, user User) {
+	t.Helper()
+	change := pendingEmailChange{UserID: user.ID, NewEmail: "new@example.com", TokenHash: hashEmailChangeToken("token"), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := db.Create(&change).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteUserWithDependents(t *testing.T) {
+	testDB(t)
+	user := seedUser(t, "alice", "Alice", "alice@example.com")
+	seedEmailChange(t, user)
+	id := strconv.FormatUint(uint64(user.ID), 10)
+	vars := map[string]string{"id": id}
+
+	rec := serve(deleteUser, http.MethodDelete, "/api/users/"+id, vars, "", nil)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("without cascade: status = %d, want 409", rec.Code)
+	}
+	var n int64
+	db.Model(&User{}).Where("id = ?", user.ID).Count(&n)
+	if n != 1 {
+		t.Fatal("blocked delete removed the user")
+	}
+
+	rec = serve(deleteUser, http.MethodDelete, "/api/users/"+id+"?cascade=true", vars, "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("with cascade: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var summary struct {
+		UserID  uint             `json:"user_id"`
+		Deleted map[string]int64 `json:"deleted"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.UserID != user.ID || summary.Deleted["email_changes"] != 1 {
+		t.Errorf("summary = %+v, want one email change removed for user %d", summary, user.ID)
+	}
+	db.Model(&pendingEmailChange{}).Where("user_id = ?", user.ID).Count(&n)
+	if n != 0 {
+		t.Errorf("%d email changes left after cascade", n)
+	}
+}
+
+func TestDeleteUserCascadeMissing(t *testing.T) {
+	testDB(t)
+	rec := serve(deleteUser, http.MethodDelete, "/api/users/999999?cascade=true", map[string]string{"id": "999999"}, "", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404: %s", rec.Code, rec.Body)
+	}
+}
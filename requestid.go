@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const requestIDKey contextKey = "requestID"
+
+// maxRequestIDLength caps a client-supplied request ID; longer ones are
+// replaced. The audit log's request_id column is sized to match.
+const maxRequestIDLength = 128
+
+// requestIDMiddleware tags each request with an ID, reusing the one sent by
+// the client or an upstream proxy if present, and echoes it in the response.
+// The header is cfg.RequestIDHeader, so the service can follow whatever
+// correlation header the surrounding infrastructure uses.
+func requestIDMiddleware(cfg *Config) func(http.Handler) http.Handler {
+	header := cfg.RequestIDHeader
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(header)
+			if id == "" || len(id) > maxRequestIDLength {
+				id = newRequestID()
+			}
+			w.Header().Set(header, id)
+			ctx := context.WithValue(r.Context(), requestIDKey, id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestID returns the ID attached to ctx by requestIDMiddleware, if any.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
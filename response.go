@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	// Embedded so ?tz= works on hosts and images without a zoneinfo
+	// database.
+	_ "time/tzdata"
+)
+
+// userResponse is how a user is rendered by the read endpoints: the stored
+// fields plus values derived from them at response time. Keeping these out
+// of User means they never end up as columns.
+type userResponse struct {
+	User
+	EmailDomain string `json:"email_domain"`
+	AgeDays     int    `json:"age_days"`
+}
+
+// newUserResponse renders user as of now. Timestamps are shown in now's
+// location; they are stored and compared in UTC regardless.
+func newUserResponse(user User, now time.Time) userResponse {
+	user.CreatedAt = user.CreatedAt.In(now.Location())
+	user.UpdatedAt = user.UpdatedAt.In(now.Location())
+	if user.DeletedAt.Valid {
+		user.DeletedAt.Time = user.DeletedAt.Time.In(now.Location())
+	}
+	resp := userResponse{User: user, EmailDomain: emailDomain(user.Email)}
+	if !user.CreatedAt.IsZero() && now.After(user.CreatedAt) {
+		resp.AgeDays = int(now.Sub(user.CreatedAt) / (24 * time.Hour))
+	}
+	return resp
+}
+
+func newUserResponses(users []User, now time.Time) []userResponse {
+	resp := make([]userResponse, len(users))
+	for i, u := range users {
+		resp[i] = newUserResponse(u, now)
+	}
+	return resp
+}
+
+// userPageResponse is userPage as sent to clients.
+type userPageResponse struct {
+	Data    []userResponse `json:"data"`
+	Total   *int64         `json:"total,omitempty"`
+	HasMore bool           `json:"has_more"`
+	Limit   int            `json:"limit"`
+	Offset  int            `json:"offset"`
+}
+
+func (p userPage) response(now time.Time) userPageResponse {
+	return userPageResponse{Data: newUserResponses(p.Data, now), Total: p.Total, HasMore: p.HasMore, Limit: p.Limit, Offset: p.Offset}
+}
+
+// responseZone returns the zone named by ?tz=, or DEFAULT_TIMEZONE when
+// there is none. It has already responded with a 400 when ok is false.
+func responseZone(w http.ResponseWriter, r *http.Request) (loc *time.Location, ok bool) {
+	name := r.URL.Query().Get("tz")
+	if name == "" {
+		return config.Timezone, true
+	}
+	// LoadLocation also accepts "Local", which would leak the host's zone.
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Unknown timezone '%s'", name))
+		return nil, false
+	}
+	return loc, true
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewUserResponse(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		user    User
+		domain  string
+		ageDays int
+	}{
+		{"created today", User{Email: "alice@Example.COM", CreatedAt: now.Add(-time.Hour)}, "example.com", 0},
+		{"just under a day", User{Email: "a@b.io", CreatedAt: now.Add(-23 * time.Hour)}, "b.io", 0},
+		{"whole days", User{Email: "a@b.io", CreatedAt: now.Add(-72*time.Hour - time.Minute)}, "b.io", 3},
+		{"clock skew", User{Email: "a@b.io", CreatedAt: now.Add(time.Hour)}, "b.io", 0},
+		{"never stored", User{Email: "a@b.io"}, "b.io", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := newUserResponse(tt.user, now)
+			if resp.EmailDomain != tt.domain || resp.AgeDays != tt.ageDays {
+				t.Errorf("email_domain, age_days = %q, %d, want %q, %d", resp.EmailDomain, resp.AgeDays, tt.domain, tt.ageDays)
+			}
+		})
+	}
+}
+
+// readEndpoint is one of the endpoints that return users, set up to find
+// the user seedUser created as alice.
+type readEndpoint struct {
+	name    string
+	handler http.HandlerFunc
+	target  string
+	vars    map[string]string
+	list    bool
+}
+
+func readEndpoints(id string) []readEndpoint {
+	return []readEndpoint{
+		{"getUser", getUser, "/api/users/" + id, map[string]string{"id": id}, false},
+		{"getUserByEmail", getUserByEmail, "/api/users/by-email?email=Alice@Example.com", nil, false},
+		{"getUserByUsername", getUserByUsername, "/api/users/by-username/alice", map[string]string{"username": "alice"}, false},
+		{"getUsers", getUsers, "/api/users", nil, true},
+		{"getUsers by ids", getUsers, "/api/users?ids=" + id, nil, true},
+		{"searchUsers", searchUsers, "/api/users/search?q=alice", nil, true},
+		{"getRecentUsers", getRecentUsers, "/api/users/recent", nil, true},
+	}
+}
+
+// withQuery adds query to e's target.
+func (e readEndpoint) withQuery(query string) string {
+	if strings.Contains(e.target, "?") {
+		return e.target + "&" + query
+	}
+	return e.target + "?" + query
+}
+
+// users fetches e with query added, expecting exactly one user back.
+func (e readEndpoint) users(t *testing.T, query string) []map[string]any {
+	t.Helper()
+	target := e.target
+	if query != "" {
+		target = e.withQuery(query)
+	}
+	rec := serve(e.handler, http.MethodGet, target, e.vars, "", http.Header{"X-Response-Envelope": {"true"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Data json.RawMessage `json:"data"`
+	}
+	decodeBody(t, rec, &body)
+	var users []map[string]any
+	if e.list {
+		if err := json.Unmarshal(body.Data, &users); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		users = make([]map[string]any, 1)
+		if err := json.Unmarshal(body.Data, &users[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(users) != 1 {
+		t.Fatalf("got %d users, want 1", len(users))
+	}
+	return users
+}
+
+func TestResponseZone(t *testing.T) {
+	testConfig(t, map[string]string{"DEFAULT_TIMEZONE": "Europe/Berlin"})
+	tests := []struct {
+		query string
+		want  string
+		ok    bool
+	}{
+		{"", "Europe/Berlin", true},
+		{"tz=UTC", "UTC", true},
+		{"tz=Asia/Tokyo", "Asia/Tokyo", true},
+		{"tz=Mars/Olympus", "", false},
+		{"tz=Local", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			loc, ok := responseZone(rec, httptest.NewRequest(http.MethodGet, "/api/users?"+tt.query, nil))
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				if rec.Code != http.StatusBadRequest {
+					t.Errorf("status = %d, want 400", rec.Code)
+				}
+				return
+			}
+			if loc.String() != tt.want {
+				t.Errorf("zone = %s, want %s", loc, tt.want)
+			}
+		})
+	}
+}
+
+// Every endpoint that returns users rejects an unknown zone before it
+// queries anything.
+func TestReadEndpointsUnknownZone(t *testing.T) {
+	testConfig(t, nil)
+	prev := db
+	db = dryRunDB(t)
+	t.Cleanup(func() { db = prev })
+
+	for _, e := range readEndpoints("1") {
+		t.Run(e.name, func(t *testing.T) {
+			rec := serve(e.handler, http.MethodGet, e.withQuery("tz=Mars/Olympus"), e.vars, "", nil)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+// Every endpoint that returns users shows its timestamps in the ?tz= zone.
+func TestReadEndpointsZone(t *testing.T) {
+	testDB(t)
+	user := seedUser(t, "alice", "Alice", "alice@example.com")
+	id := strconv.FormatUint(uint64(user.ID), 10)
+
+	for _, e := range readEndpoints(id) {
+		t.Run(e.name, func(t *testing.T) {
+			users := e.users(t, "tz=Asia/Tokyo")
+			for _, field := range []string{"created_at", "updated_at"} {
+				if v, _ := users[0][field].(string); !strings.HasSuffix(v, "+09:00") {
+					t.Errorf("%s = %v, want a +09:00 offset", field, users[0][field])
+				}
+			}
+		})
+	}
+}
+
+// Every endpoint that returns users renders them with the computed fields.
+func TestReadEndpointsComputedFields(t *testing.T) {
+	testDB(t)
+	user := seedUser(t, "alice", "Alice", "alice@example.com")
+	id := strconv.FormatUint(uint64(user.ID), 10)
+
+	for _, tt := range readEndpoints(id) {
+		t.Run(tt.name, func(t *testing.T) {
+			users := tt.users(t, "")
+			if users[0]["email_domain"] != "example.com" {
+				t.Errorf("email_domain = %v", users[0]["email_domain"])
+			}
+			if _, ok := users[0]["age_days"]; !ok {
+				t.Errorf("age_days missing: %v", users[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Roles a user may hold. New users get roleUser.
+const (
+	roleUser  = "user"
+	roleAdmin = "admin"
+)
+
+var userRoles = []string{roleUser, roleAdmin}
+
+// assignRoleRequest is the body of POST /api/admin/users/assign-role.
+type assignRoleRequest struct {
+	IDs  []uint `json:"ids"`
+	Role string `json:"role"`
+}
+
+// assignRole serves POST /api/admin/users/assign-role, giving every listed
+// user the role in one UPDATE. Users that already hold it, or don't exist,
+// are left alone, so updated counts only real changes. Role is read-only on
+// the regular user endpoints; this is the only way to change it.
+func assignRole(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	var req assignRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if len(req.IDs) == 0 {
+		writeError(w, r, http.StatusBadRequest, "ids must list at least one user")
+		return
+	}
+	if len(req.IDs) > maxBatchItems {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("ids may list at most %d users", maxBatchItems))
+		return
+	}
+	req.Role = strings.ToLower(strings.TrimSpace(req.Role))
+	if !slices.Contains(userRoles, req.Role) {
+		writeValidationErrors(w, r, []fieldError{{Field: "role", Code: codeRoleInvalid, Message: fmt.Sprintf("Role must be one of %s", strings.Join(userRoles, ", "))}})
+		return
+	}
+
+	var changed []User
+	err := db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&changed).Clauses(clause.Returning{}).
+			Where("id IN ? AND role <> ?", req.IDs, req.Role).
+			Update("role", req.Role).Error
+		if err != nil {
+			return err
+		}
+		for _, u := range changed {
+			if err := tx.Create(newAuditEntry(r.Context(), auditUpdated, u)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "Failed to assign role")
+		return
+	}
+	for _, u := range changed {
+		hub.publish(userEvent{Type: eventUserUpdated, User: u})
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]any{"role": req.Role, "updated": len(changed)})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAssignRoleValidation(t *testing.T) {
+	testConfig(t, nil)
+	prev := db
+	db = dryRunDB(t)
+	t.Cleanup(func() { db = prev })
+
+	tooMany := strings.TrimSuffix(strings.Repeat("1,", maxBatchItems+1), ",")
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"empty body", "", http.StatusBadRequest},
+		{"no ids", `{"role": "admin"}`, http.StatusBadRequest},
+		{"empty ids", `{"ids": [], "role": "admin"}`, http.StatusBadRequest},
+		{"too many ids", `{"ids": [` + tooMany + `], "role": "admin"}`, http.StatusBadRequest},
+		{"unknown role", `{"ids": [1], "role": "owner"}`, http.StatusUnprocessableEntity},
+		{"no role", `{"ids": [1]}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(assignRole, http.MethodPost, "/api/admin/users/assign-role", nil, tt.body, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusUnprocessableEntity && !strings.Contains(rec.Body.String(), codeRoleInvalid) {
+				t.Errorf("body = %s, want %s", rec.Body, codeRoleInvalid)
+			}
+		})
+	}
+}
+
+func TestAssignRole(t *testing.T) {
+	testDB(t)
+	alice := seedUser(t, "alice", "Alice", "alice@example.com")
+	bob := seedUser(t, "bob", "Bob", "bob@example.com")
+	carol := seedUser(t, "carol", "Carol", "carol@example.com")
+
+	tests := []struct {
+		name    string
+		ids     []uint
+		role    string
+		updated int
+	}{
+		{"promote two", []uint{alice.ID, bob.ID, 999999}, " Admin ", 2},
+		{"already admins", []uint{alice.ID, bob.ID}, "admin", 0},
+		{"demote one", []uint{bob.ID}, "user", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids := strings.Trim(strings.Join(strings.Fields(fmt.Sprint(tt.ids)), ","), "[]")
+			body := fmt.Sprintf(`{"ids": [%s], "role": %q}`, ids, tt.role)
+			rec := serve(assignRole, http.MethodPost, "/api/admin/users/assign-role", nil, body, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var resp struct {
+				Updated int `json:"updated"`
+			}
+			decodeBody(t, rec, &resp)
+			if resp.Updated != tt.updated {
+				t.Errorf("updated = %d, want %d", resp.Updated, tt.updated)
+			}
+		})
+	}
+
+	want := map[uint]string{alice.ID: roleAdmin, bob.ID: roleUser, carol.ID: roleUser}
+	for id, role := range want {
+		var user User
+		if err := db.First(&user, id).Error; err != nil {
+			t.Fatal(err)
+		}
+		if user.Role != role {
+			t.Errorf("user %d has role %q, want %q", id, user.Role, role)
+		}
+	}
+}
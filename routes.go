@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// routeTable registers routes while honoring DISABLED_ENDPOINTS. Disabled
+// method+path pairs are simply never registered, so mux answers them with
+// 404 or 405 like any other unknown route.
+type routeTable struct {
+	disabled map[string]bool
+	known    map[string]bool
+}
+
+func newRouteTable(disabled []string) *routeTable {
+	t := &routeTable{disabled: map[string]bool{}, known: map[string]bool{}}
+	for _, d := range disabled {
+		t.disabled[d] = true
+	}
+	return t
+}
+
+// handle registers h for path on router under each method that isn't
+// disabled. prefix is the path prefix of router when it is a subrouter, so
+// the route can be matched against its full path.
+func (t *routeTable) handle(router *mux.Router, prefix, path string, h http.HandlerFunc, methods ...string) {
+	var enabled []string
+	for _, m := range methods {
+		key := m + " " + prefix + path
+		t.known[key] = true
+		if !t.disabled[key] {
+			enabled = append(enabled, m)
+		}
+	}
+	if len(enabled) > 0 {
+		router.HandleFunc(path, h).Methods(enabled...)
+	}
+}
+
+// handleFeature registers h like handle when the feature is on. When it is
+// off the path still gets a route for methods, answering 404 exactly as an
+// unknown path would; otherwise a request for it could fall through to a
+// broader route, such as GET /api/users/{id} reading "events" as an ID.
+func (t *routeTable) handleFeature(on bool, router *mux.Router, prefix, path string, h http.HandlerFunc, methods ...string) {
+	if on {
+		t.handle(router, prefix, path, h, methods...)
+		return
+	}
+	router.Handle(path, featureOff{}).Methods(methods...)
+}
+
+// featureOff is the handler of a disabled feature's routes. Listings and
+// Allow headers leave them out, since as far as clients can tell the routes
+// don't exist.
+type featureOff struct{}
+
+func (featureOff) ServeHTTP(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) }
+
+func isFeatureOff(route *mux.Route) bool {
+	_, off := route.GetHandler().(featureOff)
+	return off
+}
+
+// unknownDisabled returns the DISABLED_ENDPOINTS entries that don't match
+// any registered route, which are almost certainly typos.
+func (t *routeTable) unknownDisabled() []string {
+	var unknown []string
+	for d := range t.disabled {
+		if !t.known[d] {
+			unknown = append(unknown, d)
+		}
+	}
+	slices.Sort(unknown)
+	return unknown
+}
+
+// duplicateRoutes walks router and returns every "METHOD /path/template"
+// registered more than once. mux accepts duplicates and silently serves the
+// first, so a second registration is always a mistake.
+func duplicateRoutes(router *mux.Router) ([]string, error) {
+	seen := map[string]bool{}
+	var dups []string
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil // a subrouter or matcher without a path
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil // the PathPrefix holding a subrouter
+		}
+		for _, m := range methods {
+			key := m + " " + tmpl
+			if seen[key] && !slices.Contains(dups, key) {
+				dups = append(dups, key)
+			}
+			seen[key] = true
+		}
+		return nil
+	})
+	slices.Sort(dups)
+	return dups, err
+}
+
+// parseEndpointList parses a comma-separated list of "METHOD /path"
+// entries, normalizing the method to upper case.
+func parseEndpointList(key string) ([]string, error) {
+	var endpoints []string
+	for _, entry := range envList(key, nil) {
+		fields := strings.Fields(entry)
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "/") {
+			return nil, fmt.Errorf("%s entry %q must look like \"POST /api/users\"", key, entry)
+		}
+		endpoints = append(endpoints, strings.ToUpper(fields[0])+" "+fields[1])
+	}
+	return endpoints, nil
+}
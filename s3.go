@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3PartSize is the size of each multipart upload part. S3 requires at
+// least 5 MiB for every part but the last; one part is held in memory at a
+// time.
+const s3PartSize = 8 << 20
+
+// s3MaxErrorBody caps how much of an error response is read.
+const s3MaxErrorBody = 64 << 10
+
+// s3Client uploads objects to an S3-compatible store with path-style URLs
+// (endpoint/bucket/key) and Signature Version 4, which AWS, MinIO, R2 and
+// the like all accept.
+type s3Client struct {
+	cfg    s3Config
+	client *http.Client
+}
+
+// s3Config is a bucket on an S3-compatible store and the credentials for
+// it.
+type s3Config struct {
+	Bucket    string
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// loadS3Config reads <prefix>BUCKET, <prefix>ENDPOINT (default AWS S3),
+// <prefix>REGION (default us-east-1), <prefix>ACCESS_KEY_ID and
+// <prefix>SECRET_ACCESS_KEY. The credentials fall back to
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+func loadS3Config(prefix string) (s3Config, error) {
+	cfg := s3Config{
+		Bucket:    os.Getenv(prefix + "BUCKET"),
+		Region:    envString(prefix+"REGION", "us-east-1"),
+		AccessKey: envString(prefix+"ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID")),
+		SecretKey: envString(prefix+"SECRET_ACCESS_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+	}
+	if cfg.Bucket == "" {
+		return cfg, fmt.Errorf("%sBUCKET is required", prefix)
+	}
+	cfg.Endpoint = strings.TrimSuffix(envString(prefix+"ENDPOINT", "https://s3."+cfg.Region+".amazonaws.com"), "/")
+	if u, err := url.Parse(cfg.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return cfg, fmt.Errorf("%sENDPOINT must be an http or https URL, got %q", prefix, cfg.Endpoint)
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return cfg, fmt.Errorf("%sBUCKET requires %sACCESS_KEY_ID and %sSECRET_ACCESS_KEY", prefix, prefix, prefix)
+	}
+	return cfg, nil
+}
+
+func newS3Client(cfg s3Config) *s3Client {
+	return &s3Client{cfg: cfg, client: &http.Client{}}
+}
+
+// upload streams body to key as a multipart upload, so the object can be
+// any size without being buffered whole. It returns the bytes uploaded. A
+// failed upload is aborted, leaving no partial object or stored parts.
+func (c *s3Client) upload(ctx context.Context, key, contentType string, body io.Reader) (int64, error) {
+	var created struct {
+		UploadID string `xml:"UploadId"`
+	}
+	header := http.Header{"Content-Type": {contentType}}
+	if err := c.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, header, nil, &created); err != nil {
+		return 0, fmt.Errorf("create multipart upload: %w", err)
+	}
+
+	size, err := c.uploadParts(ctx, key, created.UploadID, body)
+	if err != nil {
+		// Abort even if ctx is done, or the stored parts linger and are
+		// billed until a lifecycle rule removes them.
+		abortCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		if abortErr := c.do(abortCtx, http.MethodDelete, key, url.Values{"uploadId": {created.UploadID}}, nil, nil, nil); abortErr != nil {
+			err = errors.Join(err, fmt.Errorf("abort upload: %w", abortErr))
+		}
+		return 0, err
+	}
+	return size, nil
+}
+
+// putObject uploads payload to key in a single request.
+func (c *s3Client) putObject(ctx context.Context, key, contentType string, payload []byte) error {
+	return c.do(ctx, http.MethodPut, key, nil, http.Header{"Content-Type": {contentType}}, payload, nil)
+}
+
+// getObject opens key for reading. It returns errBlobNotFound if there is
+// no such object.
+func (c *s3Client) getObject(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	resp, err := c.send(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, "", errBlobNotFound
+	}
+	if err := s3ResponseError(resp); err != nil {
+		resp.Body.Close()
+		return nil, "", err
+	}
+	return resp.Body, resp.Header.Get("Content-Type"), nil
+}
+
+// deleteObject removes key. Deleting a missing object succeeds.
+func (c *s3Client) deleteObject(ctx context.Context, key string) error {
+	return c.do(ctx, http.MethodDelete, key, nil, nil, nil, nil)
+}
+
+// objectURL is the path-style URL of key.
+func (c *s3Client) objectURL(key string) string {
+	return c.cfg.Endpoint + "/" + s3Escape(c.cfg.Bucket, false) + "/" + s3Escape(key, true)
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (c *s3Client) uploadParts(ctx context.Context, key, uploadID string, body io.Reader) (int64, error) {
+	var parts []s3CompletedPart
+	var size int64
+	buf := make([]byte, s3PartSize)
+	for {
+		n, err := io.ReadFull(body, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, err
+		}
+		// A completed upload needs at least one part, even an empty one.
+		if n > 0 || len(parts) == 0 {
+			number := len(parts) + 1
+			query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+			var etag string
+			if err := c.doETag(ctx, key, query, buf[:n], &etag); err != nil {
+				return 0, fmt.Errorf("upload part %d: %w", number, err)
+			}
+			parts = append(parts, s3CompletedPart{PartNumber: number, ETag: etag})
+			size += int64(n)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	complete := struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts}
+	payload, err := xml.Marshal(complete)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, payload, nil); err != nil {
+		return 0, fmt.Errorf("complete upload: %w", err)
+	}
+	return size, nil
+}
+
+// doETag PUTs one part and returns the ETag S3 assigned it.
+func (c *s3Client) doETag(ctx context.Context, key string, query url.Values, payload []byte, etag *string) error {
+	resp, err := c.send(ctx, http.MethodPut, key, query, nil, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := s3ResponseError(resp); err != nil {
+		return err
+	}
+	*etag = resp.Header.Get("ETag")
+	return nil
+}
+
+// do sends a signed request and, if out is non-nil, decodes the XML reply
+// into it.
+func (c *s3Client) do(ctx context.Context, method, key string, query url.Values, header http.Header, payload []byte, out any) error {
+	resp, err := c.send(ctx, method, key, query, header, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := s3ResponseError(resp); err != nil {
+		return err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	// CompleteMultipartUpload can fail after a 200, with the error in the
+	// body.
+	var s3Err struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}
+	if xml.Unmarshal(body, &s3Err) == nil && s3Err.Code != "" {
+		return fmt.Errorf("%s: %s", s3Err.Code, s3Err.Message)
+	}
+	if out != nil {
+		return xml.Unmarshal(body, out)
+	}
+	return nil
+}
+
+func (c *s3Client) send(ctx context.Context, method, key string, query url.Values, header http.Header, payload []byte) (*http.Response, error) {
+	target := c.objectURL(key)
+	if len(query) > 0 {
+		target += "?" + s3CanonicalQuery(query)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	c.sign(req, payload, time.Now().UTC())
+	return c.client.Do(req)
+}
+
+// sign adds a Signature Version 4 Authorization header to req, signing the
+// host, the payload hash and the date.
+func (c *s3Client) sign(req *http.Request, payload []byte, now time.Time) {
+	payloadHash := sha256.Sum256(payload)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + hex.EncodeToString(payloadHash[:]),
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+
+	scope := date + "/" + c.cfg.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	signingKey := []byte("AWS4" + c.cfg.SecretKey)
+	for _, part := range []string{date, c.cfg.Region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes s the way SigV4 expects: everything but
+// unreserved characters, and slashes too unless keepSlash is set.
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~', ch == '/' && keepSlash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+// s3CanonicalQuery encodes query sorted by key, with every key given an
+// = even when its value is empty, as the canonical request requires.
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			pairs = append(pairs, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3ResponseError turns a non-2xx response into an error carrying S3's
+// error code when the body has one.
+func s3ResponseError(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, s3MaxErrorBody))
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(body, &s3Err) == nil && s3Err.Code != "" {
+		return fmt.Errorf("%s: %s: %s", resp.Status, s3Err.Code, s3Err.Message)
+	}
+	return errors.New(resp.Status)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// schemaField describes one User field for clients building forms.
+type schemaField struct {
+	Name      string `json:"name"`
+	JSONKey   string `json:"json_key"`
+	Type      string `json:"type"`
+	Format    string `json:"format,omitempty"`
+	Required  bool   `json:"required"`
+	ReadOnly  bool   `json:"read_only"`
+	MaxLength int    `json:"max_length,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+}
+
+// fieldRules are the validation rules that live in code rather than on the
+// struct, keyed by JSON key. They reference the same patterns and limits the
+// validators use, so the schema can't drift from what is enforced.
+var fieldRules = map[string]schemaField{
+	"username": {Required: true, Pattern: usernamePattern.String()},
+	"name":     {Required: true, MaxLength: maxNameLength},
+	"email":    {Required: true, Format: "email", MaxLength: maxEmailLength, Pattern: emailPattern.String()},
+	"role":     {ReadOnly: true},
+}
+
+var (
+	timeType      = reflect.TypeFor[time.Time]()
+	deletedAtType = reflect.TypeFor[gorm.DeletedAt]()
+)
+
+// userSchema derives the field list from the User struct: JSON keys from
+// the json tags, lengths from the gorm size, and read-only from the columns
+// the server manages itself.
+func userSchema() []schemaField {
+	t := reflect.TypeFor[User]()
+	fields := make([]schemaField, 0, t.NumField())
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		key, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if key == "-" || !sf.IsExported() {
+			continue
+		}
+		if key == "" {
+			key = sf.Name
+		}
+
+		f := fieldRules[key]
+		f.Name = sf.Name
+		f.JSONKey = key
+		switch {
+		case sf.Type == timeType || sf.Type == deletedAtType:
+			f.Type, f.Format, f.ReadOnly = "string", "date-time", true
+		case sf.Type.Kind() == reflect.String:
+			f.Type = "string"
+		case sf.Type.Kind() == reflect.Bool:
+			f.Type = "boolean"
+		case sf.Type.Kind() >= reflect.Int && sf.Type.Kind() <= reflect.Uint64:
+			f.Type = "integer"
+		default:
+			f.Type = "object"
+		}
+
+		for _, setting := range strings.Split(sf.Tag.Get("gorm"), ";") {
+			name, value, _ := strings.Cut(setting, ":")
+			switch name {
+			case "primaryKey":
+				f.ReadOnly = true
+			case "default":
+				// Values generated by a database function can't be set.
+				if strings.Contains(value, "(") {
+					f.ReadOnly = true
+				}
+			case "type":
+				if value == "uuid" {
+					f.Format = "uuid"
+				}
+			case "size":
+				if n, err := strconv.Atoi(value); err == nil && f.MaxLength == 0 {
+					f.MaxLength = n
+				}
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// getUserSchema serves GET /api/users/schema.
+func getUserSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", config.ReadCacheControl)
+	writeJSON(w, r, http.StatusOK, map[string]any{"resource": "user", "fields": userSchema()})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// searchVectorColumn is a generated tsvector over name and email. It is
+// kept out of the User model because no Go code ever writes it.
+const searchVectorColumn = "search_vector"
+
+// fullTextSearch is set at startup when users has a search vector to
+// query. Without one, search falls back to ILIKE.
+var fullTextSearch bool
+
+// migrateSearchVector adds the search vector and its GIN index on Postgres,
+// after AutoMigrate has created the table. As a generated column the vector
+// is recomputed by Postgres on every insert and update, however the row is
+// written, so it can't drift from the data it covers. Names weigh more than
+// emails in the ranking, and emails are also split at @ and dots so a
+// search for the domain finds them.
+func migrateSearchVector() error {
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+	err := db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS ` + searchVectorColumn + ` tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+			setweight(to_tsvector('simple', coalesce(email, '') || ' ' || translate(coalesce(email, ''), '@.', '  ')), 'B')
+		) STORED`).Error
+	if err != nil {
+		return err
+	}
+	return db.Exec(`CREATE INDEX IF NOT EXISTS idx_users_search_vector ON users USING GIN (` + searchVectorColumn + `)`).Error
+}
+
+// detectSearchVector sets fullTextSearch from the live schema, so a
+// database migrated by the migrate subcommand or another instance is
+// searched the same way as one migrated at startup.
+func detectSearchVector() {
+	fullTextSearch = db.Dialector.Name() == "postgres" && db.Migrator().HasColumn(&User{}, searchVectorColumn)
+	if !fullTextSearch {
+		fmt.Printf("⚠️  users.%s is missing; search falls back to ILIKE\n", searchVectorColumn)
+	}
+}
+
+// searchTerms splits q into the words a full-text query is built from.
+// Anything but letters and digits separates words, which also keeps
+// tsquery operators out of the query.
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchUsers returns users matching q, best matches first. With a search
+// vector every word of q must prefix-match a word of the name or email, and
+// rows are ranked by ts_rank; the GIN index keeps this fast on large
+// tables. Otherwise, or when q has no words at all, q is matched as a
+// substring with ILIKE.
+func searchUsers(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeError(w, r, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	loc, ok := responseZone(w, r)
+	if !ok {
+		return
+	}
+
+	query := db.WithContext(r.Context()).Model(&User{})
+	var rank clause.OrderBy
+	if terms := searchTerms(q); fullTextSearch && len(terms) > 0 {
+		query, rank = rankedSearch(query, terms)
+	} else {
+		query, rank = substringSearch(query, q)
+	}
+
+	var total int64
+	page := userPage{Limit: limit, Offset: offset, Total: &total}
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "Failed to search users")
+		return
+	}
+	if err := query.Order(rank).Limit(limit).Offset(offset).Find(&page.Data).Error; err != nil {
+		if requestCanceled(w, r, err) {
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "Failed to search users")
+		return
+	}
+
+	page.HasMore = int64(offset+len(page.Data)) < total
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	resp := page.response(time.Now().In(loc))
+	writeEnveloped(w, r, http.StatusOK, resp, resp.Data, true)
+}
+
+// rankedSearch matches terms against the search vector as prefixes
+// ("ali" finds "alice"), ordered by ts_rank and then id so pages are
+// stable.
+func rankedSearch(tx *gorm.DB, terms []string) (*gorm.DB, clause.OrderBy) {
+	tsquery := strings.Join(terms, ":* & ") + ":*"
+	rank := clause.OrderBy{Expression: clause.Expr{
+		SQL:                "ts_rank(" + searchVectorColumn + ", to_tsquery('simple', ?)) DESC, id",
+		Vars:               []any{tsquery},
+		WithoutParentheses: true,
+	}}
+	return tx.Where(searchVectorColumn+" @@ to_tsquery('simple', ?)", tsquery), rank
+}
+
+// substringSearch matches q anywhere in the name or email.
+func substringSearch(tx *gorm.DB, q string) (*gorm.DB, clause.OrderBy) {
+	escaped := escapeLike(q)
+	prefix := escaped + "%"
+	substring := "%" + escaped + "%"
+
+	// Rank each row by how well it matches:
+	//   0 - the email is exactly q (ignoring case)
+	//   1 - the name or email starts with q
+	//   2 - q appears somewhere else in the name or email
+	// Rows of the same rank fall back to id order so pages are stable.
+	rank := clause.OrderBy{Expression: clause.Expr{
+		SQL: `CASE
+			WHEN LOWER(email) = LOWER(?) THEN 0
+			WHEN name ILIKE ? OR email ILIKE ? THEN 1
+			ELSE 2
+		END, id`,
+		Vars:               []any{q, prefix, prefix},
+		WithoutParentheses: true,
+	}}
+	return tx.Where("name ILIKE ? OR email ILIKE ?", substring, substring), rank
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// searchUsernames runs a search for q and returns the usernames found, in
+// order.
+func searchUsernames(t *testing.T, q string) []string {
+	t.Helper()
+	rec := serve(searchUsers, http.MethodGet, "/api/users/search?q="+url.QueryEscape(q), nil, "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("search %q: status = %d: %s", q, rec.Code, rec.Body)
+	}
+	var page userPageResponse
+	decodeBody(t, rec, &page)
+	usernames := []string{}
+	for _, u := range page.Data {
+		usernames = append(usernames, u.Username)
+	}
+	return usernames
+}
+
+// The search vector is a generated column, so rows written any way at all
+// are searchable at once, without a reindex.
+func TestSearchAfterWrite(t *testing.T) {
+	testDB(t)
+	seedUser(t, "alice", "Alice Liddell", "alice@example.com")
+
+	rec := serve(importUsersCSV, http.MethodPost, importCSVPath, nil,
+		"username,name,email\nbob,Bob Builder,bob@builders.example\ncarol,Carol Danvers,carol@example.com\n",
+		http.Header{"Content-Type": {"text/csv"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import: status = %d: %s", rec.Code, rec.Body)
+	}
+	if err := db.Exec("UPDATE users SET name = 'Alice Pleasance' WHERE username = 'alice'").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("INSERT INTO users (username, name, email, created_at, updated_at) VALUES ('dave', 'Dave Bowman', 'dave@discovery.example', now(), now())").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"builder", []string{"bob"}},
+		{"builders", []string{"bob"}},
+		{"danvers", []string{"carol"}},
+		{"pleasance", []string{"alice"}},
+		{"liddell", []string{}},
+		{"discovery", []string{"dave"}},
+	}
+	for _, tt := range tests {
+		if got := searchUsernames(t, tt.q); !slices.Equal(got, tt.want) {
+			t.Errorf("search %q = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"alice", []string{"alice"}},
+		{"Alice Liddell", []string{"alice", "liddell"}},
+		{"alice@example.com", []string{"alice", "example", "com"}},
+		{"a & b | !c", []string{"a", "b", "c"}},
+		{"ali:* & (bob | !carol)", []string{"ali", "bob", "carol"}},
+		{"o'brien", []string{"o", "brien"}},
+		{"'':*", nil},
+		{"&|!:*()'", nil},
+		{"Zoë Ünal", []string{"zoë", "ünal"}},
+		{"user42", []string{"user42"}},
+	}
+	for _, tt := range tests {
+		if got := searchTerms(tt.q); !slices.Equal(got, tt.want) {
+			t.Errorf("searchTerms(%q) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
+
+// No tsquery operator survives into the query, so user input can't make
+// to_tsquery fail or change what the query means.
+func TestRankedSearchSQL(t *testing.T) {
+	tests := []struct {
+		q       string
+		tsquery string
+	}{
+		{"alice", "alice:*"},
+		{"Alice Liddell", "alice:* & liddell:*"},
+		{"ali:* | !bob", "ali:* & bob:*"},
+		{"(o'brien) & )", "o:* & brien:*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			var users []User
+			query, rank := rankedSearch(dryRunDB(t).Model(&User{}), searchTerms(tt.q))
+			stmt := query.Order(rank).Find(&users).Statement
+			sql := stmt.SQL.String()
+			for _, want := range []string{
+				searchVectorColumn + " @@ to_tsquery('simple', $1)",
+				"ORDER BY ts_rank(" + searchVectorColumn + ", to_tsquery('simple', $2)) DESC, id",
+			} {
+				if !strings.Contains(sql, want) {
+					t.Errorf("SQL = %s, want it to contain %s", sql, want)
+				}
+			}
+			if want := []any{tt.tsquery, tt.tsquery}; !reflect.DeepEqual(stmt.Vars, want) {
+				t.Errorf("vars = %v, want %v", stmt.Vars, want)
+			}
+		})
+	}
+}
+
+// Names outrank emails, every word must match, words match as prefixes,
+// and rows of equal rank come back in id order.
+func TestSearchRelevance(t *testing.T) {
+	testDB(t)
+	seedUser(t, "mail", "Someone Else", "jordan@example.com")
+	seedUser(t, "name", "Jordan Smith", "js@example.com")
+	seedUser(t, "both", "Jordan Jordanson", "jordan@jordan.example")
+	seedUser(t, "other", "Alex Jordan", "alex@example.com")
+	seedUser(t, "nomatch", "Riley Quinn", "riley@example.com")
+
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"jordan", []string{"both", "name", "other", "mail"}},
+		{"jord", []string{"both", "name", "other", "mail"}},
+		{"jordan smith", []string{"name"}},
+		// Operators are dropped, so this is just "jordan smith".
+		{"jordan | !smith", []string{"name"}},
+		{"quinn", []string{"nomatch"}},
+		{"nobody", []string{}},
+	}
+	for _, tt := range tests {
+		if got := searchUsernames(t, tt.q); !slices.Equal(got, tt.want) {
+			t.Errorf("search %q = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+}
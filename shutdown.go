@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// shutdownRequested receives the reason for a graceful shutdown, from either
+// a signal or the admin endpoint.
+var shutdownRequested = make(chan string, 1)
+
+// requestShutdown asks main to shut down. Only the first request counts.
+func requestShutdown(reason string) {
+	select {
+	case shutdownRequested <- reason:
+	default:
+	}
+}
+
+// gracefulShutdown stops taking traffic, drains in-flight requests, stops
+// background work and closes the database.
+func gracefulShutdown(srv *http.Server, housekeepingDone chan struct{}, reason string) {
+	fmt.Printf("\n🛑 Shutting down server gracefully (%s)...\n", reason)
+	ready.Store(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("❌ Server shutdown incomplete: %v", err)
+	}
+	close(housekeepingDone)
+	// Stop a running broadcast before the database goes away, so it logs
+	// where to resume instead of failing on a closed pool.
+	broadcasts.stop(ctx)
+
+	// Close database connection
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatalf("❌ Error getting DB connection: %v", err)
+	}
+	sqlDB.Close()
+	fmt.Println("✅ Database connection closed")
+
+	// Closing the listener normally unlinks the socket; make sure of it.
+	if config.ListenSocket != "" {
+		if err := os.Remove(config.ListenSocket); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("❌ Failed to remove socket %s: %v", config.ListenSocket, err)
+		}
+	}
+}
+
+// adminShutdown triggers the same graceful shutdown as SIGINT. It answers
+// 202 straight away; the shutdown itself runs in main once this returns.
+// The route is only registered when ADMIN_SHUTDOWN_ENABLED is set.
+func adminShutdown(w http.ResponseWriter, r *http.Request) {
+	log.Printf("🛑 Shutdown requested via admin API by %s", authUser(r))
+
+	writeJSON(w, r, http.StatusAccepted, map[string]string{"status": "shutting down"})
+
+	requestShutdown("admin request")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm/clause"
+)
+
+// sortableColumns are the columns the list may be ordered by.
+var sortableColumns = map[string]bool{
+	"id":         true,
+	"username":   true,
+	"name":       true,
+	"email":      true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+// parseSort parses a comma-separated sort parameter such as "name,-id",
+// falling back to def when param is empty. Each column is ascending unless
+// prefixed with "-"; the first column is the primary order and later ones
+// break ties. id is appended as a final tie-breaker when it isn't already
+// listed, so rows with equal sort keys keep a stable order across pages.
+func parseSort(param, def string) (clause.OrderBy, error) {
+	var order clause.OrderBy
+	if param == "" {
+		param = def
+	}
+
+	seen := map[string]bool{}
+	for _, part := range strings.Split(param, ",") {
+		part = strings.TrimSpace(part)
+		desc := strings.HasPrefix(part, "-")
+		column := strings.TrimPrefix(part, "-")
+		if !sortableColumns[column] {
+			return order, fmt.Errorf("cannot sort by '%s'", column)
+		}
+		if seen[column] {
+			return order, fmt.Errorf("'%s' appears more than once in sort", column)
+		}
+		seen[column] = true
+		order.Columns = append(order.Columns, clause.OrderByColumn{Column: clause.Column{Name: column}, Desc: desc})
+	}
+	if !seen["id"] {
+		order.Columns = append(order.Columns, clause.OrderByColumn{Column: clause.Column{Name: "id"}})
+	}
+	return order, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gorm.io/gorm/clause"
+)
+
+// orderString renders order the way it reads in a sort parameter.
+func orderString(order clause.OrderBy) string {
+	var parts []string
+	for _, c := range order.Columns {
+		if c.Desc {
+			parts = append(parts, "-"+c.Column.Name)
+		} else {
+			parts = append(parts, c.Column.Name)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		name    string
+		param   string
+		want    string
+		wantErr string
+	}{
+		{"default", "", "id", ""},
+		{"single column gets id tie-breaker", "name", "name,id", ""},
+		{"descending", "-created_at", "-created_at,id", ""},
+		{"multiple columns keep their order", "name,-created_at", "name,-created_at,id", ""},
+		{"explicit id is not repeated", "-id,name", "-id,name", ""},
+		{"id last", "email,-id", "email,-id", ""},
+		{"spaces around columns", " name , -email ", "name,-email,id", ""},
+		{"unknown column", "name,password", "", "cannot sort by 'password'"},
+		{"repeated column", "name,-name", "", "'name' appears more than once in sort"},
+		{"empty column", "name,", "", "cannot sort by ''"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := parseSort(tt.param, "id")
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := orderString(order); got != tt.want {
+				t.Errorf("order = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
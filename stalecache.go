@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// maxStaleEntries bounds how many read results are kept for stale-if-error.
+const maxStaleEntries = 1000
+
+// staleWarning is sent with a response served from readCache.
+const staleWarning = `110 - "Response is stale"`
+
+// staleCache keeps the last successful result of each shared read so it can
+// be served while the database is unreachable. Entries older than ttl are
+// no longer served and are dropped by housekeeping.
+type staleCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]staleEntry
+}
+
+type staleEntry struct {
+	value  any
+	stored time.Time
+}
+
+func newStaleCache(ttl time.Duration) *staleCache {
+	return &staleCache{ttl: ttl, entries: map[string]staleEntry{}}
+}
+
+// readCache is nil when STALE_IF_ERROR is 0.
+var readCache *staleCache
+
+func (c *staleCache) put(key string, value any, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxStaleEntries {
+		// Make room by dropping an arbitrary entry; map order is random
+		// enough that no key is starved.
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = staleEntry{value: value, stored: now}
+}
+
+func (c *staleCache) get(key string, now time.Time) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || now.Sub(e.stored) > c.ttl {
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *staleCache) evictExpired(now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for k, e := range c.entries {
+		if now.Sub(e.stored) > c.ttl {
+			delete(c.entries, k)
+			n++
+		}
+	}
+	return n
+}
+
+func (c *staleCache) name() string { return "stale read cache" }
+
+// dbUnavailable reports whether err means the database couldn't be reached
+// or can't serve queries at the moment, as opposed to the query itself
+// failing. A Postgres error response only counts if it is a connection,
+// resource or shutdown condition.
+func dbUnavailable(err error) bool {
+	if err == nil || errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "53") || strings.HasPrefix(pgErr.Code, "57P")
+	}
+	return true
+}
+
+// serveStale falls back to the last good result for key when err says the
+// database is down, marking the response with a Warning header.
+func serveStale(w http.ResponseWriter, key string, err error) (any, bool) {
+	if readCache == nil || !dbUnavailable(err) {
+		return nil, false
+	}
+	v, ok := readCache.get(key, time.Now())
+	if ok {
+		w.Header().Set("Warning", staleWarning)
+	}
+	return v, ok
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestDBUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no error", nil, false},
+		{"not found", gorm.ErrRecordNotFound, false},
+		{"canceled", context.Canceled, false},
+		{"deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), false},
+		{"connection refused", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"connection failure", &pgconn.PgError{Code: "08006"}, true},
+		{"too many connections", &pgconn.PgError{Code: "53300"}, true},
+		{"admin shutdown", &pgconn.PgError{Code: "57P01"}, true},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, false},
+		{"syntax error", &pgconn.PgError{Code: "42601"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dbUnavailable(tt.err); got != tt.want {
+				t.Errorf("dbUnavailable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStaleCache(t *testing.T) {
+	now := time.Now()
+	c := newStaleCache(time.Minute)
+	c.put("fresh", 1, now)
+	c.put("old", 2, now.Add(-2*time.Minute))
+
+	if v, ok := c.get("fresh", now); !ok || v != 1 {
+		t.Errorf("get fresh = %v, %v", v, ok)
+	}
+	if _, ok := c.get("old", now); ok {
+		t.Error("served an entry older than the ttl")
+	}
+	if n := c.evictExpired(now); n != 1 {
+		t.Errorf("evicted %d entries, want 1", n)
+	}
+
+	for i := range maxStaleEntries + 10 {
+		c.put(fmt.Sprint(i), i, now)
+	}
+	if len(c.entries) > maxStaleEntries {
+		t.Errorf("cache grew to %d entries, cap is %d", len(c.entries), maxStaleEntries)
+	}
+}
+
+// A load that fails because the database is down is answered from the last
+// good result, flagged with a Warning; anything else fails as usual.
+func TestSharedLoadServesStale(t *testing.T) {
+	down := &pgconn.PgError{Code: "08006"}
+	tests := []struct {
+		name   string
+		cached bool
+		age    time.Duration
+		err    error
+		stale  bool
+	}{
+		{"database down with a cached result", true, 0, down, true},
+		{"database down with nothing cached", false, 0, down, false},
+		{"database down with an expired result", true, 2 * time.Minute, down, false},
+		{"query error with a cached result", true, 0, &pgconn.PgError{Code: "42601"}, false},
+		{"not found with a cached result", true, 0, gorm.ErrRecordNotFound, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := readCache
+			readCache = newStaleCache(time.Minute)
+			t.Cleanup(func() { readCache = prev })
+
+			key := fmt.Sprintf("user:%d", i)
+			if tt.cached {
+				readCache.put(key, "cached", time.Now().Add(-tt.age))
+			}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/users/1", nil)
+			v, err := sharedLoad(rec, req, key, func(context.Context) (any, error) { return nil, tt.err })
+
+			if tt.stale {
+				if err != nil || v != "cached" {
+					t.Fatalf("sharedLoad = %v, %v, want the cached result", v, err)
+				}
+				if got := rec.Header().Get("Warning"); got != staleWarning {
+					t.Errorf("Warning = %q, want %q", got, staleWarning)
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if got := rec.Header().Get("Warning"); got != "" {
+				t.Errorf("Warning = %q on a failed load", got)
+			}
+		})
+	}
+}
+
+// A successful load refreshes the cached result.
+func TestSharedLoadCachesResults(t *testing.T) {
+	prev := readCache
+	readCache = newStaleCache(time.Minute)
+	t.Cleanup(func() { readCache = prev })
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users/1", nil)
+	if _, err := sharedLoad(httptest.NewRecorder(), req, "user:cache-test", func(context.Context) (any, error) { return "page", nil }); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := readCache.get("user:cache-test", time.Now()); !ok || v != "page" {
+		t.Errorf("cached = %v, %v", v, ok)
+	}
+}
+
+// With the database unreachable, a user read before the outage is still
+// served, and one that never was gets a 503.
+func TestGetUserWhileDBDown(t *testing.T) {
+	testConfig(t, nil)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	dsn := fmt.Sprintf("host=127.0.0.1 port=%d user=app dbname=users sslmode=disable connect_timeout=2", port)
+	down, err := gorm.Open(postgres.Open(dsn), &gorm.Config{DisableAutomaticPing: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevDB, prevCache := db, readCache
+	db, readCache = down, newStaleCache(time.Minute)
+	t.Cleanup(func() { db, readCache = prevDB, prevCache })
+	readCache.put("user:1", User{ID: 1, Username: "alice", Email: "alice@example.com"}, time.Now())
+
+	tests := []struct {
+		id     string
+		status int
+		stale  bool
+	}{
+		{"1", http.StatusOK, true},
+		{"2", http.StatusServiceUnavailable, false},
+	}
+	for _, tt := range tests {
+		t.Run("user "+tt.id, func(t *testing.T) {
+			rec := serve(getUser, http.MethodGet, "/api/users/"+tt.id, map[string]string{"id": tt.id}, "", nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if stale := rec.Header().Get("Warning") == staleWarning; stale != tt.stale {
+				t.Errorf("Warning = %q", rec.Header().Get("Warning"))
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// maxStatsDays bounds the range of the daily stats so the zero-filled
+// response stays small.
+const maxStatsDays = 366
+
+// dailyCount is the number of users created on one UTC day.
+type dailyCount struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
+
+// getDailySignups serves GET /api/users/stats/daily?from=&to=, counting
+// signups per UTC day from from to to inclusive (YYYY-MM-DD; the last 30
+// days by default). Days without signups are included with a zero count so
+// charts have no gaps. The aggregation happens in the database; only one
+// row per day comes back.
+func getDailySignups(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	from := to.AddDate(0, 0, -29)
+	for _, bound := range []struct {
+		param string
+		dst   *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		if v := r.URL.Query().Get(bound.param); v != "" {
+			t, err := time.Parse(time.DateOnly, v)
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, bound.param+" must be a date like 2024-01-31")
+				return
+			}
+			*bound.dst = t
+		}
+	}
+	if to.Before(from) {
+		writeError(w, r, http.StatusBadRequest, "from must not be after to")
+		return
+	}
+	days := int(to.Sub(from)/(24*time.Hour)) + 1
+	if days > maxStatsDays {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("The range may span at most %d days", maxStatsDays))
+		return
+	}
+
+	var rows []struct {
+		Day   time.Time
+		Count int64
+	}
+	err := db.WithContext(r.Context()).Model(&User{}).
+		Select("DATE(created_at AT TIME ZONE 'UTC') AS day, COUNT(*) AS count").
+		Where("created_at >= ? AND created_at < ?", from, to.AddDate(0, 0, 1)).
+		Group("day").
+		Order("day").
+		Scan(&rows).Error
+	if requestCanceled(w, r, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to compute signup stats")
+		return
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Day.Format(time.DateOnly)] = row.Count
+	}
+	stats := make([]dailyCount, days)
+	for i := range stats {
+		date := from.AddDate(0, 0, i).Format(time.DateOnly)
+		stats[i] = dailyCount{Date: date, Count: counts[date]}
+	}
+
+	w.Header().Set("Cache-Control", config.ReadCacheControl)
+	writeJSON(w, r, http.StatusOK, stats)
+}
+
+// statusCounts is the response of getStatusCounts.
+type statusCounts struct {
+	Active   int64 `json:"active"`
+	Inactive int64 `json:"inactive"`
+	Total    int64 `json:"total"`
+}
+
+// getStatusCounts serves GET /api/users/stats/status, the number of active
+// and inactive users from a single grouped query. Soft-deleted users are
+// not counted.
+func getStatusCounts(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	var rows []struct {
+		Active bool
+		Count  int64
+	}
+	err := db.WithContext(r.Context()).Model(&User{}).
+		Select("active, COUNT(*) AS count").
+		Group("active").
+		Scan(&rows).Error
+	if requestCanceled(w, r, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to compute status stats")
+		return
+	}
+
+	var counts statusCounts
+	for _, row := range rows {
+		if row.Active {
+			counts.Active = row.Count
+		} else {
+			counts.Inactive = row.Count
+		}
+	}
+	counts.Total = counts.Active + counts.Inactive
+
+	w.Header().Set("Cache-Control", config.ReadCacheControl)
+	writeJSON(w, r, http.StatusOK, counts)
+}
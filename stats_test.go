@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestGetStatusCounts(t *testing.T) {
+	tests := []struct {
+		name     string
+		active   int
+		inactive int
+		deleted  int
+		want     statusCounts
+	}{
+		{"no users", 0, 0, 0, statusCounts{}},
+		{"only active", 3, 0, 0, statusCounts{Active: 3, Total: 3}},
+		{"only inactive", 0, 2, 0, statusCounts{Inactive: 2, Total: 2}},
+		{"mixed, deleted left out", 3, 2, 4, statusCounts{Active: 3, Inactive: 2, Total: 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testDB(t)
+			n := 0
+			seed := func(count int, active, deleted bool) {
+				for range count {
+					n++
+					user := seedUser(t, fmt.Sprintf("user%d", n), "User", fmt.Sprintf("user%d@example.com", n))
+					if !active {
+						if err := db.Model(&user).Update("active", false).Error; err != nil {
+							t.Fatal(err)
+						}
+					}
+					if deleted {
+						if err := db.Delete(&user).Error; err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+			}
+			seed(tt.active, true, false)
+			seed(tt.inactive, false, false)
+			// Deleted users of both kinds.
+			seed(tt.deleted/2, true, true)
+			seed(tt.deleted-tt.deleted/2, false, true)
+
+			rec := serve(getStatusCounts, http.MethodGet, "/api/users/stats/status", nil, "", nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var got statusCounts
+			decodeBody(t, rec, &got)
+			if got != tt.want {
+				t.Errorf("counts = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
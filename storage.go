@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// BlobStorage keeps uploaded files, such as avatars, under slash-separated
+// keys. Put returns the URL clients fetch the file from.
+type BlobStorage interface {
+	Put(ctx context.Context, key, contentType string, body io.Reader) (url string, err error)
+	Get(ctx context.Context, key string) (body io.ReadCloser, contentType string, err error)
+	Delete(ctx context.Context, key string) error
+}
+
+// errBlobNotFound is returned by Get for a key that holds nothing.
+var errBlobNotFound = errors.New("blob not found")
+
+// Storage backends.
+const (
+	storageLocal = "local"
+	storageS3    = "s3"
+)
+
+// blobsPath is where the local backend serves its files from.
+const blobsPath = "/blobs/"
+
+// storageConfig is the STORAGE_* configuration.
+type storageConfig struct {
+	Backend   string
+	LocalDir  string
+	PublicURL string
+	S3        s3Config
+}
+
+// blobs is the BlobStorage chosen from the configuration at startup.
+var blobs BlobStorage
+
+func newBlobStorage(cfg storageConfig) BlobStorage {
+	if cfg.Backend == storageS3 {
+		return &s3Storage{client: newS3Client(cfg.S3), publicURL: cfg.PublicURL}
+	}
+	return &localStorage{dir: cfg.LocalDir, publicURL: cfg.PublicURL}
+}
+
+// publicBlobURL joins base and key, or returns "" when no base is set.
+func publicBlobURL(base, key string) string {
+	if base == "" {
+		return ""
+	}
+	return strings.TrimSuffix(base, "/") + "/" + key
+}
+
+// localStorage keeps blobs as files under dir and serves them itself from
+// blobsPath. The content type is derived from the key's extension.
+type localStorage struct {
+	dir       string
+	publicURL string
+}
+
+// path maps key to a file under dir, rejecting keys that would escape it.
+func (s *localStorage) path(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s *localStorage) Put(ctx context.Context, key, contentType string, body io.Reader) (string, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return "", err
+	}
+	// Write to a temporary file and rename it into place, so a reader never
+	// sees a half-written blob.
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return "", err
+	}
+
+	if url := publicBlobURL(s.publicURL, key); url != "" {
+		return url, nil
+	}
+	return blobsPath + key, nil
+}
+
+func (s *localStorage) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, "", errBlobNotFound
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", errBlobNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return f, mime.TypeByExtension(path.Ext(key)), nil
+}
+
+func (s *localStorage) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// serveBlob serves GET /blobs/{key} for the local backend.
+func (s *localStorage) serveBlob(w http.ResponseWriter, r *http.Request) {
+	body, contentType, err := s.Get(r.Context(), mux.Vars(r)["key"])
+	if errors.Is(err, errBlobNotFound) {
+		writeError(w, r, http.StatusNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to read file")
+		return
+	}
+	defer body.Close()
+
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// Keys are never reused for different content.
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	io.Copy(w, body)
+}
+
+// s3Storage keeps blobs in a bucket. Clients fetch them straight from the
+// store, so the bucket (or a CDN in front of it, via STORAGE_PUBLIC_URL)
+// must allow public reads of the keys handed out.
+type s3Storage struct {
+	client    *s3Client
+	publicURL string
+}
+
+// Put reads body whole: blobs are small, and a single PUT needs its
+// length and hash up front.
+func (s *s3Storage) Put(ctx context.Context, key, contentType string, body io.Reader) (string, error) {
+	payload, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	if err := s.client.putObject(ctx, key, contentType, payload); err != nil {
+		return "", err
+	}
+	if url := publicBlobURL(s.publicURL, key); url != "" {
+		return url, nil
+	}
+	return s.client.objectURL(key), nil
+}
+
+func (s *s3Storage) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	return s.client.getObject(ctx, key)
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	return s.client.deleteObject(ctx, key)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memoryStorage is a BlobStorage that keeps blobs in a map.
+type memoryStorage struct {
+	mu    sync.Mutex
+	blobs map[string]memoryBlob
+}
+
+type memoryBlob struct {
+	contentType string
+	data        []byte
+}
+
+func (s *memoryStorage) Put(ctx context.Context, key, contentType string, body io.Reader) (string, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.blobs == nil {
+		s.blobs = map[string]memoryBlob{}
+	}
+	s.blobs[key] = memoryBlob{contentType, data}
+	return "https://blobs.test/" + key, nil
+}
+
+func (s *memoryStorage) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blob, ok := s.blobs[key]
+	if !ok {
+		return nil, "", errBlobNotFound
+	}
+	return io.NopCloser(bytes.NewReader(blob.data)), blob.contentType, nil
+}
+
+func (s *memoryStorage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blobs, key)
+	return nil
+}
+
+func (s *memoryStorage) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.blobs {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// useBlobs installs store as blobs for the rest of the test.
+func useBlobs(t *testing.T, store BlobStorage) {
+	prev := blobs
+	blobs = store
+	t.Cleanup(func() { blobs = prev })
+}
+
+func TestNewBlobStorage(t *testing.T) {
+	if _, ok := newBlobStorage(storageConfig{Backend: storageLocal, LocalDir: t.TempDir()}).(*localStorage); !ok {
+		t.Error("local backend is not a localStorage")
+	}
+	if _, ok := newBlobStorage(storageConfig{Backend: storageS3, S3: s3Config{Bucket: "b"}}).(*s3Storage); !ok {
+		t.Error("s3 backend is not an s3Storage")
+	}
+}
+
+func TestPublicBlobURL(t *testing.T) {
+	tests := []struct {
+		base, key, want string
+	}{
+		{"", "avatars/1/a.png", ""},
+		{"https://cdn.example.com", "avatars/1/a.png", "https://cdn.example.com/avatars/1/a.png"},
+		{"https://cdn.example.com/", "avatars/1/a.png", "https://cdn.example.com/avatars/1/a.png"},
+	}
+	for _, tt := range tests {
+		if got := publicBlobURL(tt.base, tt.key); got != tt.want {
+			t.Errorf("publicBlobURL(%q, %q) = %q, want %q", tt.base, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := &localStorage{dir: dir}
+
+	url, err := store.Put(ctx, "avatars/1/a.png", "image/png", strings.NewReader("png bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != blobsPath+"avatars/1/a.png" {
+		t.Errorf("url = %q", url)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "avatars", "1", "a.png")); err != nil || string(data) != "png bytes" {
+		t.Errorf("file = %q, %v", data, err)
+	}
+	if temps, _ := filepath.Glob(filepath.Join(dir, "avatars", "1", ".upload-*")); len(temps) > 0 {
+		t.Errorf("temporary files left behind: %v", temps)
+	}
+
+	body, contentType, err := store.Get(ctx, "avatars/1/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "png bytes" || contentType != "image/png" {
+		t.Errorf("Get = %q, %q", data, contentType)
+	}
+
+	if err := store.Delete(ctx, "avatars/1/a.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Get(ctx, "avatars/1/a.png"); !errors.Is(err, errBlobNotFound) {
+		t.Errorf("Get after Delete: err = %v", err)
+	}
+	if err := store.Delete(ctx, "avatars/1/a.png"); err != nil {
+		t.Errorf("deleting a missing blob: %v", err)
+	}
+
+	store.publicURL = "https://cdn.example.com"
+	if url, err := store.Put(ctx, "avatars/1/b.png", "image/png", strings.NewReader("x")); err != nil || url != "https://cdn.example.com/avatars/1/b.png" {
+		t.Errorf("Put with a public URL = %q, %v", url, err)
+	}
+}
+
+func TestLocalStorageRejectsEscapingKeys(t *testing.T) {
+	ctx := context.Background()
+	store := &localStorage{dir: t.TempDir()}
+	for _, key := range []string{"../outside.png", "avatars/../../outside.png", "/etc/passwd", ""} {
+		if _, err := store.Put(ctx, key, "image/png", strings.NewReader("x")); err == nil {
+			t.Errorf("Put(%q) accepted", key)
+		}
+		if _, _, err := store.Get(ctx, key); !errors.Is(err, errBlobNotFound) {
+			t.Errorf("Get(%q): err = %v, want errBlobNotFound", key, err)
+		}
+		if err := store.Delete(ctx, key); err == nil {
+			t.Errorf("Delete(%q) accepted", key)
+		}
+	}
+}
+
+func TestServeBlob(t *testing.T) {
+	testConfig(t, nil)
+	store := &localStorage{dir: t.TempDir()}
+	if _, err := store.Put(context.Background(), "avatars/1/a.png", "image/png", strings.NewReader("png bytes")); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(store.serveBlob, http.MethodGet, blobsPath+"avatars/1/a.png", map[string]string{"key": "avatars/1/a.png"}, "", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "png bytes" {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+	}
+	for header, want := range map[string]string{
+		"Content-Type":           "image/png",
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "public, max-age=31536000, immutable",
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	rec = serve(store.serveBlob, http.MethodGet, blobsPath+"avatars/1/missing.png", map[string]string{"key": "avatars/1/missing.png"}, "", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing blob: status = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+)
+
+// streamFlushEvery is how many rows are written between flushes.
+const streamFlushEvery = 100
+
+// streamShutdown is closed when the server starts shutting down, so that
+// long-lived streams end and srv.Shutdown isn't left waiting on them.
+var streamShutdown = make(chan struct{})
+
+// streamContext derives a context for a long-lived response that ends when
+// the client goes away, the stream's maximum lifetime elapses, or the server
+// begins shutting down.
+func streamContext(r *http.Request) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(r.Context(), config.StreamMaxLifetime)
+	go func() {
+		select {
+		case <-streamShutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// streamUsers writes every user matching the list filters as newline-delimited
+// JSON. Rows are read from a cursor one at a time so the table is never held
+// in memory, and the query is cancelled if the client goes away. A stream cut
+// short by its lifetime or by shutdown simply ends; NDJSON has no framing for
+// a closing message.
+func streamUsers(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	if _, ok := includeDeleted(w, r); !ok {
+		return
+	}
+
+	ctx, cancel := streamContext(r)
+	defer cancel()
+
+	rows, err := applyUserFilters(db.WithContext(ctx).Model(&User{}), r).Order("id").Rows()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve users")
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	written := 0
+	for rows.Next() {
+		var user User
+		if err := db.ScanRows(rows, &user); err != nil {
+			log.Printf("❌ Failed to scan user row: %v", err)
+			return
+		}
+		if err := enc.Encode(user); err != nil {
+			// The client disconnected; nothing left to write to.
+			return
+		}
+
+		written++
+		if flusher != nil && written%streamFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		switch {
+		case r.Context().Err() != nil:
+			// The client went away; nothing to report.
+		case ctx.Err() != nil:
+			slog.Debug("user stream closed before completion", "reason", ctx.Err())
+		case !errors.Is(err, context.Canceled):
+			log.Printf("❌ User stream aborted: %v", err)
+		}
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
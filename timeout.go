@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const requestTimeoutHeader = "X-Request-Timeout"
+
+// requestTimeout bounds how long the server works on a request. Clients may
+// ask for a tighter budget with X-Request-Timeout (milliseconds), clamped to
+// cfg.MaxRequestTimeout; without it cfg.RequestTimeout applies. The deadline
+// rides on the request context, so in-flight queries are cancelled with it
+// and requestCanceled turns the result into a 503. Streaming endpoints are
+// bounded by STREAM_MAX_LIFETIME instead.
+//
+// Routes listed in ROUTE_TIMEOUTS use their own timeout as both default and
+// cap. The middleware sits outside router, so it matches the request
+// against router itself to learn the route template.
+func requestTimeout(cfg *Config, router *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if streamingPaths[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			timeout, maxTimeout := cfg.RequestTimeout, cfg.MaxRequestTimeout
+			if len(cfg.RouteTimeouts) > 0 {
+				var match mux.RouteMatch
+				if router.Match(r, &match) && match.MatchErr == nil {
+					if tmpl, err := match.Route.GetPathTemplate(); err == nil {
+						if t, ok := cfg.RouteTimeouts[r.Method+" "+tmpl]; ok {
+							timeout, maxTimeout = t, t
+						}
+					}
+				}
+			}
+			if v := r.Header.Get(requestTimeoutHeader); v != "" {
+				ms, err := strconv.Atoi(v)
+				if err != nil || ms <= 0 {
+					writeError(w, r, http.StatusBadRequest, requestTimeoutHeader+" must be a positive number of milliseconds")
+					return
+				}
+				timeout = time.Duration(ms) * time.Millisecond
+			}
+			if maxTimeout > 0 && (timeout <= 0 || timeout > maxTimeout) {
+				timeout = maxTimeout
+			}
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// defaultRouteTimeouts apply unless ROUTE_TIMEOUTS overrides them. The CSV
+// import runs for as long as the upload takes, bounded by
+// STREAM_MAX_LIFETIME instead, as does a search reindex, and a backup is
+// bounded by BACKUP_TIMEOUT.
+var defaultRouteTimeouts = map[string]time.Duration{
+	"POST " + importCSVPath: 0,
+	"POST " + backupPath:    0,
+	"POST " + reindexPath:   0,
+}
+
+// parseRouteTimeouts parses a comma-separated list of
+// "METHOD /path/template:duration" entries, e.g. "POST /api/users/batch:2m",
+// on top of defaultRouteTimeouts.
+func parseRouteTimeouts(s string) (map[string]time.Duration, error) {
+	timeouts := maps.Clone(defaultRouteTimeouts)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("ROUTE_TIMEOUTS entry %q must look like \"POST /api/users/batch:2m\"", entry)
+		}
+		route := strings.Join(strings.Fields(entry[:i]), " ")
+		if len(strings.Fields(route)) != 2 {
+			return nil, fmt.Errorf("ROUTE_TIMEOUTS entry %q must name a method and a path", entry)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(entry[i+1:]))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("ROUTE_TIMEOUTS entry %q must end in a non-negative duration such as 30s", entry)
+		}
+		method, path, _ := strings.Cut(route, " ")
+		timeouts[strings.ToUpper(method)+" "+path] = d
+	}
+	return timeouts, nil
+}
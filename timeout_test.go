@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// budgetRouter serves /api/users, reporting the time left on the request's
+// deadline in X-Budget, or "none".
+func budgetRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/api/users", func(w http.ResponseWriter, r *http.Request) {
+		budget := "none"
+		if deadline, ok := r.Context().Deadline(); ok {
+			budget = time.Until(deadline).Round(time.Second).String()
+		}
+		w.Header().Set("X-Budget", budget)
+	}).Methods(http.MethodGet)
+	return router
+}
+
+func TestRequestTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		header string
+		status int
+		budget string
+	}{
+		{"no limit by default", map[string]string{"MAX_REQUEST_TIMEOUT": "0"}, "", http.StatusOK, "none"},
+		{"server default", map[string]string{"REQUEST_TIMEOUT": "10s"}, "", http.StatusOK, "10s"},
+		{"capped server default", map[string]string{"REQUEST_TIMEOUT": "0"}, "", http.StatusOK, "30s"},
+		{"client budget", nil, "5000", http.StatusOK, "5s"},
+		{"client budget below the default", map[string]string{"REQUEST_TIMEOUT": "10s"}, "2000", http.StatusOK, "2s"},
+		{"client budget clamped", map[string]string{"MAX_REQUEST_TIMEOUT": "4s"}, "60000", http.StatusOK, "4s"},
+		{"client budget without a cap", map[string]string{"MAX_REQUEST_TIMEOUT": "0"}, "60000", http.StatusOK, "1m0s"},
+		{"not a number", nil, "soon", http.StatusBadRequest, ""},
+		{"zero", nil, "0", http.StatusBadRequest, ""},
+		{"negative", nil, "-5", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, tt.env)
+			router := budgetRouter()
+			req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+			if tt.header != "" {
+				req.Header.Set(requestTimeoutHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			requestTimeout(cfg, router)(router).ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("X-Budget"); got != tt.budget {
+				t.Errorf("budget = %q, want %q", got, tt.budget)
+			}
+		})
+	}
+}
+
+// A request that outlives the client's budget is cancelled and answered
+// with a 503.
+func TestRequestTimeoutCancels(t *testing.T) {
+	cfg := testConfig(t, nil)
+	router := mux.NewRouter()
+	router.HandleFunc("/api/users", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			requestCanceled(w, r, r.Context().Err())
+		case <-time.After(5 * time.Second):
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	req.Header.Set(requestTimeoutHeader, "20")
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	requestTimeout(cfg, router)(router).ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request ran for %v despite a 20ms budget", elapsed)
+	}
+}
+
+func TestRequestTimeoutRouteOverride(t *testing.T) {
+	cfg := testConfig(t, map[string]string{
+		"REQUEST_TIMEOUT": "5s",
+		"ROUTE_TIMEOUTS":  "POST /api/users/batch:2m, GET /api/users/{id}:1s",
+	})
+	router := mux.NewRouter()
+	report := func(w http.ResponseWriter, r *http.Request) {
+		budget := "none"
+		if deadline, ok := r.Context().Deadline(); ok {
+			budget = time.Until(deadline).Round(time.Second).String()
+		}
+		w.Header().Set("X-Budget", budget)
+	}
+	router.HandleFunc("/api/users", report).Methods(http.MethodGet)
+	router.HandleFunc("/api/users/batch", report).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/{id}", report).Methods(http.MethodGet, http.MethodPut)
+	router.HandleFunc(importCSVPath, report).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/stream", report).Methods(http.MethodGet)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header string
+		budget string
+	}{
+		{"override beyond the default cap", http.MethodPost, "/api/users/batch", "", "2m0s"},
+		{"other routes keep the default", http.MethodGet, "/api/users", "", "5s"},
+		{"override matches the template", http.MethodGet, "/api/users/42", "", "1s"},
+		{"override is per method", http.MethodPut, "/api/users/42", "", "5s"},
+		{"override caps the client budget", http.MethodGet, "/api/users/42", "10000", "1s"},
+		{"client may ask for less", http.MethodPost, "/api/users/batch", "3000", "3s"},
+		{"zero override means no limit", http.MethodPost, importCSVPath, "", "none"},
+		{"streaming endpoints are exempt", http.MethodGet, "/api/users/stream", "", "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set(requestTimeoutHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			requestTimeout(cfg, router)(router).ServeHTTP(rec, req)
+			if got := rec.Header().Get("X-Budget"); got != tt.budget {
+				t.Errorf("budget = %q, want %q", got, tt.budget)
+			}
+		})
+	}
+}
+
+func TestParseRouteTimeouts(t *testing.T) {
+	timeouts, err := parseRouteTimeouts("post  /api/users/batch:2m, GET /api/users/{id}:500ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]time.Duration{
+		"POST /api/users/batch": 2 * time.Minute,
+		"GET /api/users/{id}":   500 * time.Millisecond,
+		"POST " + importCSVPath: 0,
+		"POST " + backupPath:    0,
+		"POST " + reindexPath:   0,
+	}
+	if len(timeouts) != len(want) {
+		t.Errorf("timeouts = %v, want %v", timeouts, want)
+	}
+	for route, d := range want {
+		if got, ok := timeouts[route]; !ok || got != d {
+			t.Errorf("%s = %v, want %v", route, got, d)
+		}
+	}
+	for _, bad := range []string{"POST /api/users/batch", "/api/users:2m", "POST /api/users:soon", "POST /api/users:-1s"} {
+		if _, err := parseRouteTimeouts(bad); err == nil {
+			t.Errorf("parseRouteTimeouts(%q) succeeded", bad)
+		}
+	}
+}
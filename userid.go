@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+)
+
+// flexibleID decodes a user ID sent either as a JSON number or as a JSON
+// string of digits. JavaScript clients send large IDs as strings because
+// numbers beyond 2^53 lose precision there.
+type flexibleID uint
+
+func (id *flexibleID) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	raw := string(data)
+	if unquoted, err := strconv.Unquote(raw); err == nil {
+		raw = unquoted
+	}
+	n, err := strconv.ParseUint(raw, 10, 0)
+	if err != nil {
+		return &json.UnmarshalTypeError{Value: "id " + string(data), Type: reflect.TypeFor[uint](), Field: "id"}
+	}
+	*id = flexibleID(n)
+	return nil
+}
+
+// UnmarshalJSON decodes a user like the default decoder would, except that
+// id may be a number or a numeric string.
+func (u *User) UnmarshalJSON(data []byte) error {
+	type plain User
+	aux := struct {
+		*plain
+		ID flexibleID `json:"id"`
+	}{plain: (*plain)(u), ID: flexibleID(u.ID)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	u.ID = uint(aux.ID)
+	return nil
+}
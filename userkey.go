@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Values of USER_ID_TYPE.
+const (
+	userIDInteger = "integer"
+	userIDUUID    = "uuid"
+)
+
+// uuidPattern is the canonical 8-4-4-4-12 hex form of a UUID. Input is
+// lowercased before it is checked.
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+var errInvalidUserID = errors.New("invalid user ID")
+
+// parseUserID parses the {id} path variable. IDs span the full uint range,
+// so ParseUint is used rather than Atoi, and zero, negative and overflowing
+// values are rejected.
+func parseUserID(r *http.Request) (uint, error) {
+	n, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 0)
+	if err != nil || n == 0 {
+		return 0, errInvalidUserID
+	}
+	return uint(n), nil
+}
+
+// userIDParam resolves the {id} path variable to a user's integer ID. With
+// USER_ID_TYPE=uuid the path carries the user's UUID instead, which is
+// looked up here, soft-deleted users included, so handlers keep working on
+// integer IDs either way. It writes the error response itself: 400 for a
+// malformed ID and 404 for a UUID that matches no user.
+func userIDParam(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	if config.UserIDType != userIDUUID {
+		id, err := parseUserID(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid user ID")
+			return 0, false
+		}
+		return id, true
+	}
+
+	uuid := strings.ToLower(mux.Vars(r)["id"])
+	if !uuidPattern.MatchString(uuid) {
+		writeError(w, r, http.StatusBadRequest, "Invalid user ID: must be a UUID")
+		return 0, false
+	}
+	var user User
+	err := db.WithContext(r.Context()).Unscoped().Select("id").Where("uuid = ?", uuid).First(&user).Error
+	if requestCanceled(w, r, err) {
+		return 0, false
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, r, http.StatusNotFound, "User not found")
+		return 0, false
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve user")
+		return 0, false
+	}
+	return user.ID, true
+}
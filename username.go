@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// usernamePattern is the shape of a URL-safe handle. Input is lowercased
+// before it is checked.
+var usernamePattern = regexp.MustCompile(`^[a-z0-9_]{3,30}$`)
+
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func usernameErrors(username string) []fieldError {
+	if !usernamePattern.MatchString(username) {
+		return []fieldError{{Field: "username", Code: codeUsernameInvalidFormat, Message: "Username must be 3-30 characters of a-z, 0-9 or _"}}
+	}
+	return nil
+}
+
+// backfillUsernames gives rows created before usernames existed a
+// placeholder handle derived from their ID, so the unique index holds and
+// later saves don't collide on an empty username.
+func backfillUsernames() error {
+	return db.Exec(`UPDATE users SET username = 'user_' || id WHERE username IS NULL OR username = ''`).Error
+}
+
+// uniqueViolation reports whether err is a unique constraint violation
+// and, if so, the error naming the conflicting field.
+func uniqueViolation(err error) (fieldError, bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return fieldError{}, false
+	}
+	switch {
+	case strings.Contains(pgErr.ConstraintName, "batch_item_keys"):
+		return fieldError{Field: "key", Code: codeKeyConflict, Message: "Key is being processed by another request"}, true
+	case strings.Contains(pgErr.ConstraintName, "username"):
+		return fieldError{Field: "username", Code: codeUsernameDuplicate, Message: "Username already taken"}, true
+	case strings.Contains(pgErr.ConstraintName, "email"):
+		return fieldError{Field: "email", Code: codeEmailDuplicate, Message: "User already exists"}, true
+	}
+	return fieldError{Code: codeUserDuplicate, Message: "User already exists"}, true
+}
+
+// writeSaveError responds to a failed create or update, turning unique
+// constraint violations into a 409.
+func writeSaveError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	if conflict, ok := uniqueViolation(err); ok {
+		writeAPIError(w, r, apiError{Status: http.StatusConflict, Message: conflict.Message, Errors: []fieldError{conflict}})
+		return
+	}
+	writeError(w, r, http.StatusInternalServerError, msg)
+}
+
+func getUserByUsername(w http.ResponseWriter, r *http.Request) {
+	if !dbReady(w, r) {
+		return
+	}
+
+	username := normalizeUsername(mux.Vars(r)["username"])
+	if errs := usernameErrors(username); len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, errs[0].Message)
+		return
+	}
+	loc, ok := responseZone(w, r)
+	if !ok {
+		return
+	}
+
+	var user User
+	err := db.WithContext(r.Context()).Where("username = ?", username).First(&user).Error
+	if requestCanceled(w, r, err) {
+		return
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, r, http.StatusNotFound, fmt.Sprintf("User '%s' not found", username))
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve user")
+		return
+	}
+
+	w.Header().Set("Cache-Control", config.ReadCacheControl)
+	w.Header().Set("ETag", userETag(user))
+	resp := newUserResponse(user, time.Now().In(loc))
+	writeEnveloped(w, r, http.StatusOK, dataEnvelope{resp}, resp, false)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestUniqueViolation(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		ok   bool
+		want fieldError
+	}{
+		{"not a database error", errors.New("boom"), false, fieldError{}},
+		{"other violation", &pgconn.PgError{Code: "23503", ConstraintName: "fk_users_roles"}, false, fieldError{}},
+		{"username", &pgconn.PgError{Code: "23505", ConstraintName: "idx_users_username"}, true, fieldError{Field: "username", Code: codeUsernameDuplicate, Message: "Username already taken"}},
+		{"email", &pgconn.PgError{Code: "23505", ConstraintName: "idx_users_email"}, true, fieldError{Field: "email", Code: codeEmailDuplicate, Message: "User already exists"}},
+		{"batch item key", &pgconn.PgError{Code: "23505", ConstraintName: "batch_item_keys_pkey"}, true, fieldError{Field: "key", Code: codeKeyConflict, Message: "Key is being processed by another request"}},
+		{"wrapped", fmt.Errorf("insert: %w", &pgconn.PgError{Code: "23505", ConstraintName: "batch_item_keys_pkey"}), true, fieldError{Field: "key", Code: codeKeyConflict, Message: "Key is being processed by another request"}},
+		{"unknown constraint", &pgconn.PgError{Code: "23505", ConstraintName: "users_pkey"}, true, fieldError{Code: codeUserDuplicate, Message: "User already exists"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := uniqueViolation(tt.err)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("uniqueViolation = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
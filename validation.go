@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var emailPattern = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+
+func isValidEmail(email string) bool {
+	return emailPattern.MatchString(email)
+}
+
+// validateNewUser checks a user about to be created, returning every
+// problem found.
+func validateNewUser(user User) []fieldError {
+	var errs []fieldError
+	if user.Username == "" {
+		errs = append(errs, fieldError{Field: "username", Code: codeUsernameRequired, Message: "Username is required"})
+	} else {
+		errs = append(errs, usernameErrors(user.Username)...)
+	}
+	if user.Name == "" {
+		errs = append(errs, fieldError{Field: "name", Code: codeNameRequired, Message: "Name is required"})
+	} else if utf8.RuneCountInString(user.Name) > maxNameLength {
+		errs = append(errs, fieldError{Field: "name", Code: codeNameTooLong, Message: "Name must be at most 100 characters"})
+	}
+	errs = append(errs, newEmailErrors(user.Email)...)
+	return append(errs, metadataErrors(user.Metadata)...)
+}
+
+// validateUserUpdate checks the fields set on a partial update, returning
+// every problem found. Empty fields are treated as not provided.
+func validateUserUpdate(updateData User) []fieldError {
+	var errs []fieldError
+	if updateData.Username != "" {
+		errs = append(errs, usernameErrors(updateData.Username)...)
+	}
+	if updateData.Name != "" {
+		if len(updateData.Name) < 3 {
+			errs = append(errs, fieldError{Field: "name", Code: codeNameTooShort, Message: "Name must be at least 3 characters"})
+		} else if utf8.RuneCountInString(updateData.Name) > maxNameLength {
+			errs = append(errs, fieldError{Field: "name", Code: codeNameTooLong, Message: "Name must be at most 100 characters"})
+		}
+	}
+	if updateData.Email != "" {
+		errs = append(errs, newEmailErrors(updateData.Email)...)
+	}
+	return append(errs, metadataErrors(updateData.Metadata)...)
+}
+
+// newEmailErrors checks an email about to be stored: its format first, and
+// only for a well-formed address the domain policy.
+func newEmailErrors(email string) []fieldError {
+	if errs := emailErrors(email); len(errs) > 0 {
+		return errs
+	}
+	return emailDomainErrors(email)
+}
+
+// emailErrors checks the format of an email. A blank one is reported as
+// missing rather than malformed.
+func emailErrors(email string) []fieldError {
+	switch {
+	case strings.TrimSpace(email) == "":
+		return []fieldError{{Field: "email", Code: codeEmailRequired, Message: "Email is required"}}
+	case len(email) > maxEmailLength:
+		return []fieldError{{Field: "email", Code: codeEmailTooLong, Message: "Email must be at most 254 characters"}}
+	case !isValidEmail(email):
+		return []fieldError{{Field: "email", Code: codeEmailInvalidFormat, Message: "Invalid email format"}}
+	}
+	return nil
+}
+
+// writeValidationErrors responds with a 422 listing every field error. The
+// first one doubles as the top-level message. The body parsed fine but
+// broke a business rule; JSON that can't be decoded is a 400 from
+// writeDecodeError instead, so clients can tell the two apart by status.
+func writeValidationErrors(w http.ResponseWriter, r *http.Request, errs []fieldError) {
+	writeAPIError(w, r, apiError{
+		Status:  http.StatusUnprocessableEntity,
+		Message: errs[0].Message,
+		Errors:  errs,
+	})
+}
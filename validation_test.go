@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// errorCodes returns the codes of errs, in order.
+func errorCodes(errs []fieldError) []string {
+	codes := make([]string, len(errs))
+	for i, e := range errs {
+		codes[i] = e.Code
+	}
+	return codes
+}
+
+func TestLengthLimits(t *testing.T) {
+	testConfig(t, nil)
+	const domain = "@example.com"
+
+	tests := []struct {
+		name  string
+		user  User
+		codes []string
+	}{
+		{"name at the limit", User{Name: strings.Repeat("n", maxNameLength)}, nil},
+		{"name over the limit", User{Name: strings.Repeat("n", maxNameLength+1)}, []string{codeNameTooLong}},
+		{"multibyte name counted in runes", User{Name: strings.Repeat("é", maxNameLength)}, nil},
+		{"multibyte name over the limit", User{Name: strings.Repeat("é", maxNameLength+1)}, []string{codeNameTooLong}},
+		{"email at the limit", User{Email: strings.Repeat("a", maxEmailLength-len(domain)) + domain}, nil},
+		{"email over the limit", User{Email: strings.Repeat("a", maxEmailLength-len(domain)+1) + domain}, []string{codeEmailTooLong}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := User{Username: "alice", Name: "Alice", Email: "alice" + domain}
+			if tt.user.Name != "" {
+				user.Name = tt.user.Name
+			}
+			if tt.user.Email != "" {
+				user.Email = tt.user.Email
+			}
+			got := errorCodes(validateNewUser(user))
+			if strings.Join(got, ",") != strings.Join(tt.codes, ",") {
+				t.Errorf("create: codes = %v, want %v", got, tt.codes)
+			}
+			got = errorCodes(validateUserUpdate(tt.user))
+			if strings.Join(got, ",") != strings.Join(tt.codes, ",") {
+				t.Errorf("update: codes = %v, want %v", got, tt.codes)
+			}
+		})
+	}
+}
+
+func TestEmailErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		email string
+		codes []string
+	}{
+		{"valid", "alice@example.com", nil},
+		{"empty", "", []string{codeEmailRequired}},
+		{"whitespace only", " \t\n", []string{codeEmailRequired}},
+		{"no domain", "alice@", []string{codeEmailInvalidFormat}},
+		{"no at", "alice.example.com", []string{codeEmailInvalidFormat}},
+		{"too long", strings.Repeat("a", maxEmailLength) + "@example.com", []string{codeEmailTooLong}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := errorCodes(emailErrors(tt.email))
+			if !slices.Equal(got, tt.codes) {
+				t.Errorf("codes = %v, want %v", got, tt.codes)
+			}
+		})
+	}
+}
+
+// A missing email is reported the same way by create, PATCH and the
+// normalize utility.
+func TestEmailRequired(t *testing.T) {
+	testConfig(t, nil)
+	got := errorCodes(validateNewUser(User{Username: "alice", Name: "Alice", Email: normalizeEmail("   ")}))
+	if !slices.Equal(got, []string{codeEmailRequired}) {
+		t.Errorf("create: codes = %v", got)
+	}
+
+	user := patchFixture()
+	user.Email = ""
+	got = errorCodes(patchedFieldErrors(user, []string{"email"}))
+	if !slices.Equal(got, []string{codeEmailRequired}) {
+		t.Errorf("patch: codes = %v", got)
+	}
+
+	rec := serve(normalizeEmailUtil, http.MethodPost, "/api/utils/normalize-email", nil, `{"email": "  "}`, nil)
+	var result normalizedEmail
+	decodeBody(t, rec, &result)
+	if result.Valid || result.Code != codeEmailRequired {
+		t.Errorf("normalize-email: %+v", result)
+	}
+}
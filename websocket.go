@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsMaxMessageBytes caps a message from the client. Larger ones close
+	// the connection with 1009 (message too big).
+	wsMaxMessageBytes = 4 << 10
+	// wsPongWait is how long the client has to answer a ping before the
+	// connection is considered dead. Pings go out a little more often.
+	wsPongWait  = 60 * time.Second
+	wsPingEvery = wsPongWait * 9 / 10
+	// wsWriteWait bounds each write, so a client that stops reading can't
+	// hold the connection open forever.
+	wsWriteWait = 10 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{CheckOrigin: wsCheckOrigin}
+
+// wsCheckOrigin accepts same-origin upgrades, clients that send no Origin
+// (anything but a browser), and origins allowed by CORS_ALLOWED_ORIGINS.
+func wsCheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+		return true
+	}
+	return slices.Contains(config.CORSAllowedOrigins, "*") || slices.Contains(config.CORSAllowedOrigins, origin)
+}
+
+// wsSubscription is a message from the client choosing which events it
+// gets. Each one replaces the last; an empty list means no restriction.
+type wsSubscription struct {
+	Subscribe []string `json:"subscribe"`
+	UserIDs   []uint   `json:"user_ids"`
+}
+
+// wsEventTypes are the event types a client can subscribe to.
+var wsEventTypes = []string{eventUserCreated, eventUserUpdated, eventUserDeleted}
+
+// wsReply acknowledges or rejects a subscription message. It carries a type
+// like the events do, so clients can tell them apart.
+type wsReply struct {
+	Type      string   `json:"type"`
+	Error     string   `json:"error,omitempty"`
+	Subscribe []string `json:"subscribe,omitempty"`
+	UserIDs   []uint   `json:"user_ids,omitempty"`
+}
+
+// wsFilter is a connection's current subscription.
+type wsFilter struct {
+	types map[string]bool
+	ids   map[uint]bool
+}
+
+func (f *wsFilter) matches(ev userEvent) bool {
+	return f != nil && (len(f.types) == 0 || f.types[ev.Type]) && (len(f.ids) == 0 || f.ids[ev.User.ID])
+}
+
+// parseSubscription turns a client message into a filter and the reply
+// confirming it. A message that doesn't parse gets an error reply and no
+// filter, leaving the previous subscription in place.
+func parseSubscription(data []byte) (*wsFilter, wsReply) {
+	var sub wsSubscription
+	if err := json.Unmarshal(data, &sub); err != nil {
+		return nil, wsReply{Type: "error", Error: "Subscription must be a JSON object"}
+	}
+	f := &wsFilter{types: map[string]bool{}, ids: map[uint]bool{}}
+	for _, t := range sub.Subscribe {
+		if !slices.Contains(wsEventTypes, t) {
+			return nil, wsReply{Type: "error", Error: fmt.Sprintf("Unknown event type '%s'", t)}
+		}
+		f.types[t] = true
+	}
+	for _, id := range sub.UserIDs {
+		f.ids[id] = true
+	}
+	return f, wsReply{Type: "subscribed", Subscribe: sub.Subscribe, UserIDs: sub.UserIDs}
+}
+
+// subscribeUserEvents serves GET /api/users/ws. After the upgrade the
+// client sends a subscription such as
+// {"subscribe": ["user.created"], "user_ids": [1, 2]} and receives the
+// matching events from the same hub as the SSE endpoint, in the same
+// shape. It receives nothing until it has subscribed, and may send a new
+// subscription at any time. Like the SSE stream, the connection is closed
+// with a reason on shutdown and at STREAM_MAX_LIFETIME.
+func subscribeUserEvents(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already answered with an HTTP error.
+		slog.Debug("websocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	events := hub.subscribe()
+	defer hub.unsubscribe(events)
+
+	lifetime := time.NewTimer(config.StreamMaxLifetime)
+	defer lifetime.Stop()
+	ping := time.NewTicker(wsPingEvery)
+	defer ping.Stop()
+
+	// The reader hands subscriptions over to this goroutine, which does all
+	// the writing; a websocket connection allows one writer at a time.
+	messages := make(chan []byte)
+	readerDone := make(chan struct{})
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(readerDone)
+		conn.SetReadLimit(wsMaxMessageBytes)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			select {
+			case messages <- data:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	closeWith := func(reason string) {
+		msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, reason)
+		conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteWait))
+	}
+	write := func(v any) error {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		return conn.WriteJSON(v)
+	}
+
+	var filter *wsFilter
+	for {
+		select {
+		case <-readerDone:
+			return
+		case <-streamShutdown:
+			closeWith("server_shutdown")
+			return
+		case <-lifetime.C:
+			closeWith("max_lifetime")
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		case data := <-messages:
+			f, reply := parseSubscription(data)
+			if f != nil {
+				filter = f
+			}
+			if err := write(reply); err != nil {
+				return
+			}
+		case ev := <-events:
+			if !filter.matches(ev) {
+				continue
+			}
+			if err := write(ev); err != nil {
+				return
+			}
+		}
+	}
+}
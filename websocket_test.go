@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestParseSubscription(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    string
+		filter *wsFilter
+		reply  wsReply
+	}{
+		{"everything", `{}`, &wsFilter{types: map[string]bool{}, ids: map[uint]bool{}}, wsReply{Type: "subscribed"}},
+		{"types and ids", `{"subscribe": ["user.created", "user.deleted"], "user_ids": [1, 2]}`,
+			&wsFilter{types: map[string]bool{eventUserCreated: true, eventUserDeleted: true}, ids: map[uint]bool{1: true, 2: true}},
+			wsReply{Type: "subscribed", Subscribe: []string{eventUserCreated, eventUserDeleted}, UserIDs: []uint{1, 2}}},
+		{"unknown type", `{"subscribe": ["user.renamed"]}`, nil, wsReply{Type: "error", Error: "Unknown event type 'user.renamed'"}},
+		{"not an object", `["user.created"]`, nil, wsReply{Type: "error", Error: "Subscription must be a JSON object"}},
+		{"not JSON", `subscribe`, nil, wsReply{Type: "error", Error: "Subscription must be a JSON object"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, reply := parseSubscription([]byte(tt.msg))
+			if !reflect.DeepEqual(filter, tt.filter) {
+				t.Errorf("filter = %+v, want %+v", filter, tt.filter)
+			}
+			if !reflect.DeepEqual(reply, tt.reply) {
+				t.Errorf("reply = %+v, want %+v", reply, tt.reply)
+			}
+		})
+	}
+}
+
+func TestWSFilterMatches(t *testing.T) {
+	created := userEvent{Type: eventUserCreated, User: User{ID: 1}}
+	tests := []struct {
+		name   string
+		filter *wsFilter
+		want   bool
+	}{
+		{"not subscribed", nil, false},
+		{"no restriction", &wsFilter{}, true},
+		{"matching type", &wsFilter{types: map[string]bool{eventUserCreated: true}}, true},
+		{"other type", &wsFilter{types: map[string]bool{eventUserDeleted: true}}, false},
+		{"matching id", &wsFilter{ids: map[uint]bool{1: true}}, true},
+		{"other id", &wsFilter{ids: map[uint]bool{2: true}}, false},
+		{"type and id", &wsFilter{types: map[string]bool{eventUserCreated: true}, ids: map[uint]bool{1: true}}, true},
+		{"type but not id", &wsFilter{types: map[string]bool{eventUserCreated: true}, ids: map[uint]bool{2: true}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.matches(created); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWSCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed string
+		origin  string
+		want    bool
+	}{
+		{"no origin", "", "", true},
+		{"same origin", "", "http://api.example.com", true},
+		{"cross origin", "", "https://evil.example", false},
+		{"allowed origin", "https://app.example.com", "https://app.example.com", true},
+		{"other origin", "https://app.example.com", "https://evil.example", false},
+		{"wildcard", "*", "https://evil.example", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testConfig(t, map[string]string{"CORS_ALLOWED_ORIGINS": tt.allowed})
+			req := httptest.NewRequest(http.MethodGet, "http://api.example.com/api/users/ws", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if got := wsCheckOrigin(req); got != tt.want {
+				t.Errorf("wsCheckOrigin = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// dialEvents connects a websocket client to subscribeUserEvents.
+func dialEvents(t *testing.T) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(subscribeUserEvents))
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func TestSubscribeUserEvents(t *testing.T) {
+	testConfig(t, nil)
+	conn := dialEvents(t)
+
+	subscribe := func(msg string) wsReply {
+		t.Helper()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		var reply wsReply
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	if reply := subscribe(`{"subscribe": ["user.created"], "user_ids": [1]}`); reply.Type != "subscribed" {
+		t.Fatalf("reply = %+v", reply)
+	}
+	// A rejected subscription leaves the previous one in place.
+	if reply := subscribe(`{"subscribe": ["user.renamed"]}`); reply.Type != "error" {
+		t.Fatalf("bad subscription: reply = %+v", reply)
+	}
+
+	hub.publish(userEvent{Type: eventUserUpdated, User: User{ID: 1}})
+	hub.publish(userEvent{Type: eventUserCreated, User: User{ID: 2}})
+	hub.publish(userEvent{Type: eventUserCreated, User: User{ID: 1, Name: "Alice"}})
+	var ev userEvent
+	if err := conn.ReadJSON(&ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != eventUserCreated || ev.User.ID != 1 || ev.User.Name != "Alice" {
+		t.Errorf("first event = %+v, want user.created for user 1", ev)
+	}
+}
+
+func TestSubscribeUserEventsMaxLifetime(t *testing.T) {
+	testConfig(t, map[string]string{"STREAM_MAX_LIFETIME": "100ms"})
+	conn := dialEvents(t)
+
+	_, _, err := conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("err = %v, want a close frame", err)
+	}
+	if closeErr.Code != websocket.CloseGoingAway || closeErr.Text != "max_lifetime" {
+		t.Errorf("closed with %d %q, want 1001 max_lifetime", closeErr.Code, closeErr.Text)
+	}
+}
+
+func TestSubscribeUserEventsMessageTooBig(t *testing.T) {
+	testConfig(t, nil)
+	conn := dialEvents(t)
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"subscribe": ["`+strings.Repeat("x", wsMaxMessageBytes)+`"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseMessageTooBig {
+		t.Errorf("err = %v, want close 1009", err)
+	}
+}